@@ -16,6 +16,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -32,6 +33,7 @@ const (
 	MapArg
 	ToStrArg // special arg type that converts anything to a string, used for the debug action
 	ArgsArg  // special arg type for arguments passed to the call action
+	ListArg
 )
 
 const (
@@ -114,13 +116,28 @@ func (h *Holochain) ValidateAction(a ValidatingAction, entryType string, pkg *Pa
 		return
 	}
 
+	// validations see chunked entries whole and encrypted ones decrypted if we can
+	a, err = h.openAction(a)
+	sealed := err == ErrNotRecipient
+	if sealed {
+		// only the recipients of partial entries can validate what's in them
+		if def.Sharing != Partial {
+			err = ValidationFailedErr
+			return
+		}
+		err = nil
+	}
+	if err != nil {
+		return
+	}
+
 	// run the action's system level validations
 	err = a.SysValidation(h, def, pkg, sources)
 	if err != nil {
 		h.Debugf("Sys ValidateAction(%T) err:%v\n", a, err)
 		return
 	}
-	if !def.IsSysEntry() {
+	if !def.IsSysEntry() && !sealed {
 
 		// validation actions for application defined entry types
 		var vpkg *ValidationPackage
@@ -179,6 +196,8 @@ func (h *Holochain) GetValidationResponse(a ValidatingAction, hash Hash) (resp V
 		return
 	case KeyEntryType:
 		// if key entry there no extra info to return in the package so do nothing
+	case ChunkEntryType:
+		// chunks are just data so there's nothing to package either
 	case AgentEntryType:
 		// if agent, the package to return is the entry-type chain
 		// so that sys validation can confirm this agent entry in the chain
@@ -239,6 +258,9 @@ func MakeActionFromMessage(msg *Message) (a Action, err error) {
 	case GETLINK_REQUEST:
 		a = &ActionGetLinks{}
 		t = reflect.TypeOf(LinkQuery{})
+	case GETMANY_REQUEST:
+		a = &ActionGetMany{}
+		t = reflect.TypeOf(GetManyReq{})
 	case LISTADD_REQUEST:
 		a = &ActionListAdd{}
 		t = reflect.TypeOf(ListAddReq{})
@@ -599,7 +621,11 @@ func (a *ActionQuery) Args() []Arg {
 }
 
 func (a *ActionQuery) Do(h *Holochain) (response interface{}, err error) {
-	response, err = h.Query(a.options)
+	if a.options != nil && a.options.Aggregate.isSet() {
+		response, err = h.QueryAggregate(a.options)
+	} else {
+		response, err = h.Query(a.options)
+	}
 	return
 }
 
@@ -639,6 +665,9 @@ func (a *ActionGet) Do(h *Holochain) (response interface{}, err error) {
 		if (mask & GetMaskEntry) != 0 {
 			resp.Entry = *entry.(*GobEntry)
 		}
+		if err = h.openResp(&resp); err != nil {
+			return
+		}
 
 		response = resp
 		return
@@ -667,6 +696,7 @@ func (a *ActionGet) Do(h *Holochain) (response interface{}, err error) {
 	}
 	switch t := rsp.(type) {
 	case GetResp:
+		err = h.openResp(&t)
 		response = t
 	default:
 		err = fmt.Errorf("expected GetResp response from GET_REQUEST, got: %T", t)
@@ -733,6 +763,114 @@ func (a *ActionGet) Receive(dht *DHT, msg *Message, retries int) (response inter
 	return
 }
 
+//------------------------------------------------------------
+// GetMany
+
+// BatchResult holds the response or error for one item of a batch action
+type BatchResult struct {
+	Response interface{}
+	Err      error
+}
+
+type ActionGetMany struct {
+	reqs    []GetReq
+	options *GetOptions
+}
+
+func NewGetManyAction(hashes []Hash, options *GetOptions) *ActionGetMany {
+	if options == nil {
+		options = &GetOptions{StatusMask: StatusDefault}
+	}
+	a := ActionGetMany{reqs: make([]GetReq, len(hashes)), options: options}
+	for i, hash := range hashes {
+		a.reqs[i] = GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+	}
+	return &a
+}
+
+func (a *ActionGetMany) Name() string {
+	return "getMany"
+}
+
+func (a *ActionGetMany) Args() []Arg {
+	return []Arg{{Name: "hashes", Type: ListArg}, {Name: "options", Type: MapArg, MapType: reflect.TypeOf(GetOptions{}), Optional: true}}
+}
+
+func (a *ActionGetMany) Do(h *Holochain) (response interface{}, err error) {
+	results := make([]BatchResult, len(a.reqs))
+	response = results
+	if a.options.Local {
+		for i, req := range a.reqs {
+			results[i].Response, results[i].Err = NewGetAction(req, a.options).Do(h)
+		}
+		return
+	}
+
+	resps, errs := h.dht.GetMany(a.reqs)
+	wg := sync.WaitGroup{}
+	for i := range a.reqs {
+		switch errs[i] {
+		case nil:
+			results[i].Err = h.openResp(&resps[i])
+			results[i].Response = resps[i]
+		case ErrHashDeleted, ErrHashRejected:
+			results[i].Err = errs[i]
+		default:
+			// the responsible peer didn't have it, couldn't be reached, or the entry was
+			// modified and has to be followed, so fall back to a get of its own
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i].Response, results[i].Err = NewGetAction(a.reqs[i], a.options).Do(h)
+			}(i)
+		}
+	}
+	wg.Wait()
+	return
+}
+
+func (a *ActionGetMany) Receive(dht *DHT, msg *Message, retries int) (response interface{}, err error) {
+	req := msg.Body.(GetManyReq)
+	if len(req.Reqs) > MaxGetManyBatch {
+		err = ErrGetManyTooLarge
+		return
+	}
+	resp := GetManyResp{Resps: make([]GetResp, len(req.Reqs)), Errs: make([]ErrorResponse, len(req.Reqs))}
+	for i := range req.Reqs {
+		m := *msg
+		m.Type = GET_REQUEST
+		m.Body = req.Reqs[i]
+		r, e := (&ActionGet{}).Receive(dht, &m, retries)
+		if t, ok := r.(GetResp); ok {
+			resp.Resps[i] = t
+		} else if e == nil {
+			// we were sent closer peers, the requester can look for it there
+			e = ErrHashNotFound
+		}
+		if e != nil {
+			resp.Errs[i] = NewErrorResponse(e)
+		}
+	}
+	response = resp
+	return
+}
+
+// getManyHashes converts the hashes argument of a getMany
+func getManyHashes(list []interface{}) (hashes []Hash, err error) {
+	hashes = make([]Hash, len(list))
+	for i, item := range list {
+		s, ok := item.(string)
+		if !ok {
+			err = fmt.Errorf("expecting hash string in hashes, got %T", item)
+			return
+		}
+		if hashes[i], err = NewHash(s); err != nil {
+			return
+		}
+	}
+	return
+}
+
 // doCommit adds an entry to the local chain after validating the action it's part of
 func (h *Holochain) doCommit(a CommittingAction, change *StatusChange) (d *EntryDef, header *Header, entryHash Hash, err error) {
 
@@ -781,6 +919,7 @@ type ActionCommit struct {
 	entryType string
 	entry     Entry
 	header    *Header
+	options   *CommitOptions
 }
 
 func NewCommitAction(entryType string, entry Entry) *ActionCommit {
@@ -801,7 +940,7 @@ func (a *ActionCommit) Name() string {
 }
 
 func (a *ActionCommit) Args() []Arg {
-	return []Arg{{Name: "entryType", Type: StringArg}, {Name: "entry", Type: EntryArg}}
+	return []Arg{{Name: "entryType", Type: StringArg}, {Name: "entry", Type: EntryArg}, {Name: "options", Type: MapArg, MapType: reflect.TypeOf(CommitOptions{}), Optional: true}}
 }
 
 func (a *ActionCommit) SetHeader(header *Header) {
@@ -811,11 +950,50 @@ func (a *ActionCommit) SetHeader(header *Header) {
 func (a *ActionCommit) Do(h *Holochain) (response interface{}, err error) {
 	var d *EntryDef
 	var entryHash Hash
-	//	var header *Header
-	d, _, entryHash, err = h.doCommit(a, nil)
+	d, entryHash, err = a.commit(h)
+	if err != nil {
+		return
+	}
+	err = a.share(h, d, entryHash)
+	response = entryHash
+	return
+}
+
+// commit adds the entry to the local chain, encrypting or chunking it first if its
+// definition calls for it
+func (a *ActionCommit) commit(h *Holochain) (d *EntryDef, entryHash Hash, err error) {
+	_, d, err = h.GetEntryDef(a.entryType)
 	if err != nil {
 		return
 	}
+	if d != nil {
+		if a.entry, err = h.prepareEntry(d, a.entry, a.options); err != nil {
+			return
+		}
+	}
+	//	var header *Header
+	d, _, entryHash, err = h.doCommit(a, nil)
+	return
+}
+
+// prepareEntry returns what gets committed for an entry, which for partial entries is the
+// entry encrypted to their recipients, and for large entries a manifest of its chunks
+func (h *Holochain) prepareEntry(d *EntryDef, entry Entry, options *CommitOptions) (prepared Entry, err error) {
+	prepared = entry
+	if d.Sharing == Partial {
+		var recipients []string
+		if options != nil {
+			recipients = options.Recipients
+		}
+		prepared, err = h.encryptEntry(entry, recipients)
+	} else if content, ok := chunkable(d, entry); ok {
+		prepared, err = h.commitChunks(content, d.DataFormat == DataFormatBinary)
+	}
+	return
+}
+
+// share sends a committed entry to the DHT, or the links it makes if it's a links entry
+func (a *ActionCommit) share(h *Holochain, d *EntryDef, entryHash Hash) (err error) {
 	if d.DataFormat == DataFormatLinks {
 		// if this is a Link entry we have to send the DHT Link message
 		var le LinksEntry
@@ -835,15 +1013,17 @@ func (a *ActionCommit) Do(h *Holochain) (response interface{}, err error) {
 				bases[l.Base] = true
 			}
 		}
-	} else if d.Sharing == Public {
-		// otherwise we check to see if it's a public entry and if so send the DHT put message
+	} else if d.Sharing == Public || d.Sharing == Partial {
+		// otherwise we check to see if it's a shared entry and if so send the DHT put message
 		err = h.dht.Change(entryHash, PUT_REQUEST, PutReq{H: entryHash})
+		if err == nil {
+			err = h.dht.announceSuccessor(entryHash, a.header)
+		}
 		if err == ErrEmptyRoutingTable {
 			// will still have committed locally and can gossip later
 			err = nil
 		}
 	}
-	response = entryHash
 	return
 }
 
@@ -869,6 +1049,12 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 				return err
 			}
 		}
+	case ChunkEntryType:
+		chunk, ok := entry.Content().([]byte)
+		if !ok || len(chunk) == 0 {
+			err = ValidationFailedErr
+			return
+		}
 	case AgentEntryType:
 		ae, ok := entry.Content().(AgentEntry)
 		if !ok {
@@ -900,6 +1086,27 @@ func sysValidateEntry(h *Holochain, def *EntryDef, entry Entry, pkg *Package) (e
 		err = ErrNilEntryInvalid
 		return
 	}
+	if max := h.nucleus.dna.DHTConfig.MaxEntrySize; max > 0 {
+		var b []byte
+		if b, err = entry.Marshal(); err != nil {
+			return
+		}
+		if len(b) > max {
+			err = ErrEntryTooLarge
+			return
+		}
+	}
+	if e, ok := entry.Content().(EncryptedEntry); ok {
+		// the content of entries not encrypted to us can't be checked
+		err = checkEncryptedEntry(e)
+		return
+	}
+	if def.DataFormat == DataFormatBinary {
+		if _, ok := entry.Content().([]byte); !ok {
+			err = ValidationFailedErr
+			return
+		}
+	}
 	// see if there is a schema validator for the entry type and validate it if so
 	if def.validator != nil {
 		var input interface{}
@@ -971,6 +1178,131 @@ func (a *ActionCommit) CheckValidationRequest(def *EntryDef) (err error) {
 	return
 }
 
+//------------------------------------------------------------
+// CommitMany
+
+// CommitManyItem is one of the entries committed by a commitMany
+type CommitManyItem struct {
+	EntryType string
+	Entry     Entry
+	Options   *CommitOptions
+}
+
+type ActionCommitMany struct {
+	items []CommitManyItem
+}
+
+func NewCommitManyAction(items []CommitManyItem) *ActionCommitMany {
+	a := ActionCommitMany{items: items}
+	return &a
+}
+
+func (a *ActionCommitMany) Name() string {
+	return "commitMany"
+}
+
+func (a *ActionCommitMany) Args() []Arg {
+	return []Arg{{Name: "entries", Type: ListArg}}
+}
+
+// Do adds the entries to the chain one after the other and then shares them all with the
+// DHT at once, returning the hash of each entry or the error committing it
+func (a *ActionCommitMany) Do(h *Holochain) (response interface{}, err error) {
+	results := make([]BatchResult, len(a.items))
+	commits := make([]*ActionCommit, len(a.items))
+	defs := make([]*EntryDef, len(a.items))
+	hashes := make([]Hash, len(a.items))
+	for i, item := range a.items {
+		commits[i] = NewCommitAction(item.EntryType, item.Entry)
+		commits[i].options = item.Options
+		defs[i], hashes[i], results[i].Err = commits[i].commit(h)
+	}
+	wg := sync.WaitGroup{}
+	for i := range commits {
+		if results[i].Err != nil {
+			continue
+		}
+		results[i].Response = hashes[i]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Err = commits[i].share(h, defs[i], hashes[i])
+		}(i)
+	}
+	wg.Wait()
+	response = results
+	return
+}
+
+func (a *ActionCommitMany) Receive(dht *DHT, msg *Message, retries int) (response interface{}, err error) {
+	err = NonDHTAction
+	return
+}
+
+// commitManyItems converts the entries argument of a commitMany, each of which is an
+// object with the EntryType, Entry and optionally the Options of a commit
+func commitManyItems(h *Holochain, list []interface{}) (items []CommitManyItem, err error) {
+	items = make([]CommitManyItem, len(list))
+	for i, item := range list {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			err = fmt.Errorf("expecting object in entries, got %T", item)
+			return
+		}
+		entryType, ok := m["EntryType"].(string)
+		if !ok {
+			err = fmt.Errorf("expecting string EntryType in entry %d", i)
+			return
+		}
+		var def *EntryDef
+		if _, def, err = h.GetEntryDef(entryType); err != nil {
+			return
+		}
+		var content interface{}
+		switch def.DataFormat {
+		case DataFormatRawJS, DataFormatRawZygo, DataFormatString:
+			s, ok := m["Entry"].(string)
+			if !ok {
+				err = fmt.Errorf("expecting string Entry in entry %d", i)
+				return
+			}
+			content = s
+		case DataFormatLinks, DataFormatJSON:
+			var j []byte
+			if j, err = json.Marshal(m["Entry"]); err != nil {
+				return
+			}
+			content = string(j)
+		case DataFormatBinary:
+			l, ok := m["Entry"].([]interface{})
+			b := make([]byte, len(l))
+			for k := range l {
+				n, isNum := l[k].(float64)
+				if !isNum || n < 0 || n > 255 {
+					ok = false
+					break
+				}
+				b[k] = byte(n)
+			}
+			if !ok {
+				err = fmt.Errorf("expecting array of bytes Entry in entry %d", i)
+				return
+			}
+			content = b
+		default:
+			err = errors.New("data format not implemented: " + def.DataFormat)
+			return
+		}
+		items[i] = CommitManyItem{EntryType: entryType, Entry: &GobEntry{C: content}}
+		if opts, ok := m["Options"]; ok {
+			if items[i].Options, err = commitOptionsFrom(opts); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
 //------------------------------------------------------------
 // Put
 
@@ -1021,9 +1353,32 @@ func RunValidationPhase(h *Holochain, source peer.ID, msgType MsgType, query Has
 
 func (a *ActionPut) Receive(dht *DHT, msg *Message, retries int) (response interface{}, err error) {
 	t := msg.Body.(PutReq)
+	if t.Successor {
+		response, err = dht.receiveSuccessor(msg)
+		return
+	}
+	// overflow puts come from a sender whose holders were full so they're held here anyway
+	if !t.Overflow {
+		if response = dht.redirectIfNotHolding(t.H, msg.From); response != nil {
+			return
+		}
+	}
+	if err = dht.checkQuota(t.H); err != nil {
+		return
+	}
 	err = RunValidationPhase(dht.h, msg.From, VALIDATE_PUT_REQUEST, t.H, func(resp ValidateResponse) error {
+		// gossip may bring puts of entries that have already expired elsewhere
+		if dht.expired(resp.Type, msg.Time, time.Now()) {
+			dht.dlog.Logf("Put %v expired, ignoring", t.H)
+			return nil
+		}
 		a := NewPutAction(resp.Type, &resp.Entry, &resp.Header)
 		_, err := dht.h.ValidateAction(a, a.entryType, &resp.Package, []peer.ID{msg.From})
+		if err == ErrHashNotFound && dht.h.missingChunk(&resp.Entry) {
+			// the chunks of a chunked entry haven't all reached the DHT yet
+			dht.dlog.Logf("Put %v missing chunks, trying again later", t.H)
+			return dht.queueRetry(msg, retries)
+		}
 
 		var status int
 		if err != nil {
@@ -1066,6 +1421,7 @@ type ActionMod struct {
 	entry     Entry
 	header    *Header
 	replaces  Hash
+	options   *CommitOptions
 }
 
 func NewModAction(entryType string, entry Entry, replaces Hash) *ActionMod {
@@ -1086,7 +1442,7 @@ func (a *ActionMod) Name() string {
 }
 
 func (a *ActionMod) Args() []Arg {
-	return []Arg{{Name: "entryType", Type: StringArg}, {Name: "entry", Type: EntryArg}, {Name: "replaces", Type: HashArg}}
+	return []Arg{{Name: "entryType", Type: StringArg}, {Name: "entry", Type: EntryArg}, {Name: "replaces", Type: HashArg}, {Name: "options", Type: MapArg, MapType: reflect.TypeOf(CommitOptions{}), Optional: true}}
 }
 
 func (a *ActionMod) SetHeader(header *Header) {
@@ -1095,15 +1451,23 @@ func (a *ActionMod) SetHeader(header *Header) {
 
 func (a *ActionMod) Do(h *Holochain) (response interface{}, err error) {
 	var d *EntryDef
+	if _, d, err = h.GetEntryDef(a.entryType); err != nil {
+		return
+	}
+	// the new entry is encrypted or chunked just as it would be committed
+	if a.entry, err = h.prepareEntry(d, a.entry, a.options); err != nil {
+		return
+	}
 	var entryHash Hash
 	d, a.header, entryHash, err = h.doCommit(a, &StatusChange{Action: ModAction, Hash: a.replaces})
 	if err != nil {
 		return
 	}
-	if d.Sharing == Public {
-		// if it's a public entry send the DHT MOD & PUT messages
+	if d.Sharing == Public || d.Sharing == Partial {
+		// if it's a shared entry send the DHT MOD & PUT messages
 		// TODO handle errors better!!
 		h.dht.Change(entryHash, PUT_REQUEST, PutReq{H: entryHash})
+		h.dht.announceSuccessor(entryHash, a.header)
 		h.dht.Change(a.replaces, MOD_REQUEST, ModReq{H: a.replaces, N: entryHash})
 	}
 	response = entryHash
@@ -1158,6 +1522,9 @@ func (a *ActionMod) Receive(dht *DHT, msg *Message, retries int) (response inter
 	//var hashStatus int
 	t := msg.Body.(ModReq)
 	from := msg.From
+	if response = dht.redirectIfNotHolding(t.H, msg.From); response != nil {
+		return
+	}
 
 	response, err = dht.retryIfHashNotFound(t.H, msg, retries)
 	if response != nil || err != nil {
@@ -1389,6 +1756,9 @@ func (a *ActionDel) SysValidation(h *Holochain, def *EntryDef, pkg *Package, sou
 func (a *ActionDel) Receive(dht *DHT, msg *Message, retries int) (response interface{}, err error) {
 	t := msg.Body.(DelReq)
 	from := msg.From
+	if response = dht.redirectIfNotHolding(t.H, msg.From); response != nil {
+		return
+	}
 	response, err = dht.retryIfHashNotFound(t.H, msg, retries)
 	if response != nil || err != nil {
 		return
@@ -1459,6 +1829,9 @@ func (a *ActionLink) Receive(dht *DHT, msg *Message, retries int) (response inte
 	t := msg.Body.(LinkReq)
 	base := t.Base
 	from := msg.From
+	if response = dht.redirectIfNotHolding(base, from); response != nil {
+		return
+	}
 
 	response, err = dht.retryIfHashNotFound(base, msg, retries)
 	if response != nil || err != nil {
@@ -1538,45 +1911,7 @@ func (a *ActionGetLinks) Do(h *Holochain) (response interface{}, err error) {
 		case *LinkQueryResp:
 			response = t
 			if a.options.Load {
-				for i := range t.Links {
-					var hash Hash
-					hash, err = NewHash(t.Links[i].H)
-					if err != nil {
-						return
-					}
-					opts := GetOptions{GetMask: GetMaskEntryType + GetMaskEntry, StatusMask: StatusDefault}
-					req := GetReq{H: hash, StatusMask: StatusDefault, GetMask: opts.GetMask}
-					var rsp interface{}
-					rsp, err = NewGetAction(req, &opts).Do(h)
-					if err == nil {
-						// TODO: bleah, really this should be another of those
-						// case statements that choses the encoding baste on
-						// entry type, time for a refactor!
-						entry := rsp.(GetResp).Entry
-						switch content := entry.Content().(type) {
-						case string:
-							t.Links[i].E = content
-						case []byte:
-							var j []byte
-							j, err = json.Marshal(content)
-							if err != nil {
-								return
-							}
-							t.Links[i].E = string(j)
-						case AgentEntry:
-							var j []byte
-							j, err = json.Marshal(content)
-							if err != nil {
-								return
-							}
-							t.Links[i].E = string(j)
-						default:
-							err = fmt.Errorf("bad type in entry content: %T:%v", content, content)
-						}
-						t.Links[i].EntryType = rsp.(GetResp).EntryType
-					}
-					//TODO better error handling here, i.e break out of the loop and return if error?
-				}
+				err = loadLinks(h, t)
 			}
 		default:
 			err = fmt.Errorf("unexpected response type from SendGetLinks: %T", t)
@@ -1585,6 +1920,50 @@ func (a *ActionGetLinks) Do(h *Holochain) (response interface{}, err error) {
 	return
 }
 
+// loadLinks fills in the entries and entry types of the links in a get links response
+func loadLinks(h *Holochain, t *LinkQueryResp) (err error) {
+	for i := range t.Links {
+		var hash Hash
+		hash, err = NewHash(t.Links[i].H)
+		if err != nil {
+			return
+		}
+		opts := GetOptions{GetMask: GetMaskEntryType + GetMaskEntry, StatusMask: StatusDefault}
+		req := GetReq{H: hash, StatusMask: StatusDefault, GetMask: opts.GetMask}
+		var rsp interface{}
+		rsp, err = NewGetAction(req, &opts).Do(h)
+		if err == nil {
+			// TODO: bleah, really this should be another of those
+			// case statements that choses the encoding baste on
+			// entry type, time for a refactor!
+			entry := rsp.(GetResp).Entry
+			switch content := entry.Content().(type) {
+			case string:
+				t.Links[i].E = content
+			case []byte:
+				var j []byte
+				j, err = json.Marshal(content)
+				if err != nil {
+					return
+				}
+				t.Links[i].E = string(j)
+			case AgentEntry:
+				var j []byte
+				j, err = json.Marshal(content)
+				if err != nil {
+					return
+				}
+				t.Links[i].E = string(j)
+			default:
+				err = fmt.Errorf("bad type in entry content: %T:%v", content, content)
+			}
+			t.Links[i].EntryType = rsp.(GetResp).EntryType
+		}
+		//TODO better error handling here, i.e break out of the loop and return if error?
+	}
+	return
+}
+
 func (a *ActionGetLinks) SysValidation(h *Holochain, d *EntryDef, pkg *Package, sources []peer.ID) (err error) {
 	//@TODO what sys level getlinks validation?  That they are all valid hash format for the DNA?
 	return
@@ -1594,11 +1973,87 @@ func (a *ActionGetLinks) Receive(dht *DHT, msg *Message, retries int) (response
 	lq := msg.Body.(LinkQuery)
 	var r LinkQueryResp
 	r.Links, err = dht.getLinks(lq.Base, lq.T, lq.StatusMask)
+	if lq.tagQuery() {
+		if isNoLinksErr(err) {
+			// a base without links has none matching the query
+			r.Links, err = nil, nil
+		}
+		matching := make([]TaggedHash, 0)
+		for _, th := range r.Links {
+			if lq.matchTag(th.T) {
+				matching = append(matching, th)
+			}
+		}
+		r.Links = matching
+	}
+	if err == nil {
+		r.Links, r.Next = dht.pageLinks(r.Links, lq.T, lq.Cursor, lq.Limit)
+	}
+	if err == ErrHashNotFound {
+		closest := dht.h.node.betterPeersForHash(&lq.Base, msg.From, CloserPeerCount)
+		if len(closest) > 0 {
+			err = nil
+			resp := CloserPeersResp{}
+			resp.CloserPeers = dht.h.node.peers2PeerInfos(closest)
+			response = resp
+			return
+		}
+	}
 	response = &r
 
 	return
 }
 
+//------------------------------------------------------------
+// QueryLinks
+
+type ActionQueryLinks struct {
+	linkQuery *LinkQuery
+	options   *QueryLinksOptions
+}
+
+func NewQueryLinksAction(base Hash, options *QueryLinksOptions) *ActionQueryLinks {
+	lq := LinkQuery{
+		Base:       base,
+		StatusMask: options.StatusMask,
+		Limit:      options.PageSize,
+		Cursor:     options.Cursor,
+		TagPrefix:  options.TagPrefix,
+		Tags:       options.Tags,
+	}
+	a := ActionQueryLinks{linkQuery: &lq, options: options}
+	return &a
+}
+
+func (a *ActionQueryLinks) Name() string {
+	return "queryLinks"
+}
+
+func (a *ActionQueryLinks) Args() []Arg {
+	return []Arg{{Name: "base", Type: HashArg}, {Name: "options", Type: MapArg, MapType: reflect.TypeOf(QueryLinksOptions{})}}
+}
+
+func (a *ActionQueryLinks) Do(h *Holochain) (response interface{}, err error) {
+	if !a.linkQuery.tagQuery() {
+		err = errors.New("queryLinks needs a TagPrefix or Tags")
+		return
+	}
+	var r *LinkQueryResp
+	r, err = h.dht.QueryLinks(*a.linkQuery)
+	if err == nil {
+		response = r
+		if a.options.Load {
+			err = loadLinks(h, r)
+		}
+	}
+	return
+}
+
+func (a *ActionQueryLinks) Receive(dht *DHT, msg *Message, retries int) (response interface{}, err error) {
+	err = NonDHTAction
+	return
+}
+
 //------------------------------------------------------------
 // ListAdd
 
@@ -1654,7 +2109,26 @@ func (a *ActionListAdd) Receive(dht *DHT, msg *Message, retries int) (response i
 		return
 	}
 
-	// TODO verify that the warrant, if valid, is sufficient to allow list addition #300
+	// the warrant only allows adding its parties to the list
+	var parties []Hash
+	if parties, err = w.Parties(); err != nil {
+		err = fmt.Errorf("%s: %v", prefix, err)
+		return
+	}
+	for _, r := range a.list.Records {
+		party := HashFromPeerID(r.ID)
+		found := false
+		for i := range parties {
+			if parties[i].Equal(&party) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			err = fmt.Errorf("%s: %v is not a party to the warrant", prefix, peer.IDB58Encode(r.ID))
+			return
+		}
+	}
 
 	err = dht.addToList(msg, a.list)
 	if err != nil {
@@ -1678,10 +2152,10 @@ func (dht *DHT) retryIfHashNotFound(hash Hash, msg *Message, retries int) (respo
 	if err != nil {
 		if err == ErrHashNotFound {
 			dht.dlog.Logf("don't yet have %s, trying again later", hash)
-			retry := &retry{msg: *msg, retries: retries}
-			dht.retryQueue <- retry
-			response = DHTChangeUnknownHashQueuedForRetry
-			err = nil
+			err = dht.queueRetry(msg, retries)
+			if err == nil {
+				response = DHTChangeUnknownHashQueuedForRetry
+			}
 		}
 	}
 	return
@@ -164,6 +164,24 @@ func TestSysValidateEntry(t *testing.T) {
 		So(err, ShouldNotBeNil)
 		So(err.Error(), ShouldEqual, "invalid links entry: missing Tag")
 	})
+
+	Convey("validate should fail on entries larger than the DNA allows", t, func() {
+		_, def, _ := h.GetEntryDef("evenNumbers")
+		h.nucleus.dna.DHTConfig.MaxEntrySize = 10
+		err := sysValidateEntry(h, def, &GobEntry{C: "12345678901234567890"}, nil)
+		So(err, ShouldEqual, ErrEntryTooLarge)
+		h.nucleus.dna.DHTConfig.MaxEntrySize = 0
+		err = sysValidateEntry(h, def, &GobEntry{C: "12345678901234567890"}, nil)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("validate should check that binary entries are bytes", t, func() {
+		_, def, _ := h.GetEntryDef("image")
+		err := sysValidateEntry(h, def, &GobEntry{C: "not bytes"}, nil)
+		So(err, ShouldEqual, ValidationFailedErr)
+		err = sysValidateEntry(h, def, &GobEntry{C: []byte{0, 1, 255}}, nil)
+		So(err, ShouldBeNil)
+	})
 }
 
 func TestSysValidateMod(t *testing.T) {
@@ -318,3 +336,109 @@ func TestActionGetLocal(t *testing.T) {
 		So(getResp.Entry.Content().(string), ShouldEqual, "31415")
 	})
 }
+
+func TestActionGetMany(t *testing.T) {
+	nodesCount := 3
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+
+	h := mt.nodes[0]
+	ringConnect(t, mt.ctx, mt.nodes, nodesCount)
+
+	hash3 := commit(h, "oddNumbers", "3")
+	hash5 := commit(mt.nodes[1], "oddNumbers", "5")
+	e := GobEntry{C: "7"}
+	missing, _ := e.Sum(h.hashSpec)
+
+	Convey("receive should return a response or error for each request", t, func() {
+		m := h.node.NewMessage(GETMANY_REQUEST, GetManyReq{Reqs: []GetReq{{H: hash3, GetMask: GetMaskEntry}, {H: missing, GetMask: GetMaskEntry}}})
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		resp := r.(GetManyResp)
+		So(len(resp.Resps), ShouldEqual, 2)
+		So(resp.itemErr(0), ShouldBeNil)
+		So(resp.Resps[0].Entry.Content(), ShouldEqual, "3")
+		So(resp.itemErr(1), ShouldEqual, ErrHashNotFound)
+	})
+
+	Convey("receive should reject requests with too many gets", t, func() {
+		defer func(n int) { MaxGetManyBatch = n }(MaxGetManyBatch)
+		MaxGetManyBatch = 1
+		m := h.node.NewMessage(GETMANY_REQUEST, GetManyReq{Reqs: []GetReq{{H: hash3}, {H: missing}}})
+		_, err := ActionReceiver(h, m)
+		So(err, ShouldEqual, ErrGetManyTooLarge)
+	})
+
+	Convey("it should get each hash with an error for those it can't", t, func() {
+		r, err := NewGetManyAction([]Hash{hash3, missing, hash5}, &GetOptions{StatusMask: StatusDefault}).Do(mt.nodes[2])
+		So(err, ShouldBeNil)
+		results := r.([]BatchResult)
+		So(len(results), ShouldEqual, 3)
+		So(results[0].Err, ShouldBeNil)
+		So(results[0].Response.(GetResp).Entry.C, ShouldEqual, "3")
+		So(results[1].Err, ShouldEqual, ErrHashNotFound)
+		So(results[2].Err, ShouldBeNil)
+		So(results[2].Response.(GetResp).Entry.C, ShouldEqual, "5")
+	})
+
+	Convey("it should split the gets into batches of at most MaxGetManyBatch", t, func() {
+		defer func(n int) { MaxGetManyBatch = n }(MaxGetManyBatch)
+		MaxGetManyBatch = 1
+		r, err := NewGetManyAction([]Hash{hash3, hash5}, nil).Do(mt.nodes[2])
+		So(err, ShouldBeNil)
+		results := r.([]BatchResult)
+		So(results[0].Err, ShouldBeNil)
+		So(results[0].Response.(GetResp).Entry.C, ShouldEqual, "3")
+		So(results[1].Err, ShouldBeNil)
+		So(results[1].Response.(GetResp).Entry.C, ShouldEqual, "5")
+	})
+
+	Convey("it should get private local values", t, func() {
+		secret := commit(h, "secret", "31415")
+		r, err := NewGetManyAction([]Hash{secret, hash3}, &GetOptions{GetMask: GetMaskEntry, Local: true}).Do(h)
+		So(err, ShouldBeNil)
+		results := r.([]BatchResult)
+		So(results[0].Err, ShouldBeNil)
+		So(results[0].Response.(GetResp).Entry.C, ShouldEqual, "31415")
+		So(results[1].Err, ShouldBeNil)
+	})
+}
+
+func TestActionCommitMany(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	Convey("it should commit each entry with an error for those it can't", t, func() {
+		l := h.chain.Length()
+		items := []CommitManyItem{
+			{EntryType: "oddNumbers", Entry: &GobEntry{C: "7"}},
+			{EntryType: "oddNumbers", Entry: &GobEntry{C: "2"}},
+			{EntryType: "secret", Entry: &GobEntry{C: "2718"}},
+		}
+		r, err := NewCommitManyAction(items).Do(h)
+		So(err, ShouldBeNil)
+		results := r.([]BatchResult)
+		So(len(results), ShouldEqual, 3)
+		So(results[0].Err, ShouldBeNil)
+		So(results[1].Err, ShouldNotBeNil)
+		So(results[2].Err, ShouldBeNil)
+		So(h.chain.Length(), ShouldEqual, l+2)
+
+		e := GobEntry{C: "7"}
+		hash, _ := e.Sum(h.hashSpec)
+		So(results[0].Response.(Hash).String(), ShouldEqual, hash.String())
+	})
+
+	Convey("it should convert the entries argument", t, func() {
+		items, err := commitManyItems(h, []interface{}{
+			map[string]interface{}{"EntryType": "oddNumbers", "Entry": "9"},
+			map[string]interface{}{"EntryType": "profile", "Entry": map[string]interface{}{"firstName": "Betty"}},
+		})
+		So(err, ShouldBeNil)
+		So(items[0].Entry.Content(), ShouldEqual, "9")
+		So(items[1].Entry.Content(), ShouldEqual, `{"firstName":"Betty"}`)
+
+		_, err = commitManyItems(h, []interface{}{map[string]interface{}{"Entry": "9"}})
+		So(err.Error(), ShouldEqual, "expecting string EntryType in entry 0")
+	})
+}
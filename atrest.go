@@ -0,0 +1,396 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// encryption at rest of the chain and DHT stores.  The data is encrypted with a random key
+// which is kept in the chain's db directory sealed with a key derived from a passphrase, so
+// changing the passphrase only means resealing that key.  The passphrase is read from the
+// environment when a holochain is loaded and is never saved with its config.
+//
+// Only the values in the DHT store are encrypted.  The links and tags on them, the put
+// index and the messages in it, changes queued for retry and the records of chain
+// successors kept for spotting forks are stored in plaintext, as they are looked up by
+// their contents and are mostly hashes anyway.
+
+package holochain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// PassphraseEnvVar names the environment variable holding the passphrase of a holochain
+// that's encrypted at rest
+const PassphraseEnvVar = "HC_PASSPHRASE"
+
+var ErrBadPassphrase = errors.New("wrong passphrase for encrypted chain data")
+var ErrNoPassphrase = errors.New("chain data is encrypted but no passphrase was given in " + PassphraseEnvVar)
+var ErrNotEncrypted = errors.New("chain data isn't encrypted, use hcadmin encrypt")
+var ErrAlreadyEncrypted = errors.New("chain data is already encrypted")
+var ErrBadSealedData = errors.New("encrypted chain data can't be decrypted")
+
+// RestKey is the key the chain and DHT stores are encrypted with
+type RestKey struct {
+	key [32]byte
+}
+
+// restKeyFile is how a RestKey is stored, sealed with a key derived from the passphrase
+type restKeyFile struct {
+	Salt  []byte
+	Nonce []byte
+	Key   []byte
+}
+
+// passphraseKey derives the key a RestKey is sealed with from a passphrase
+func passphraseKey(passphrase string, salt []byte) (key *[32]byte, err error) {
+	var b []byte
+	b, err = scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return
+	}
+	key = new([32]byte)
+	copy(key[:], b)
+	return
+}
+
+// newRestKey generates a random RestKey
+func newRestKey() (k *RestKey, err error) {
+	k = &RestKey{}
+	_, err = rand.Read(k.key[:])
+	return
+}
+
+// seal encrypts data prefixing it with the nonce used
+func (k *RestKey) seal(data []byte) (sealed []byte, err error) {
+	var nonce [24]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return
+	}
+	sealed = secretbox.Seal(nonce[:], data, &nonce, &k.key)
+	return
+}
+
+// open decrypts data sealed with seal
+func (k *RestKey) open(sealed []byte) (data []byte, err error) {
+	if len(sealed) < 24+secretbox.Overhead {
+		err = ErrBadSealedData
+		return
+	}
+	var nonce [24]byte
+	copy(nonce[:], sealed)
+	data, ok := secretbox.Open(nil, sealed[24:], &nonce, &k.key)
+	if !ok {
+		err = ErrBadSealedData
+	}
+	return
+}
+
+// save seals the key with the passphrase and writes it to the given directory
+func (k *RestKey) save(dir string, passphrase string) (err error) {
+	if passphrase == "" {
+		err = ErrNoPassphrase
+		return
+	}
+	f := restKeyFile{Salt: make([]byte, 32)}
+	if _, err = rand.Read(f.Salt); err != nil {
+		return
+	}
+	var nonce [24]byte
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return
+	}
+	var pk *[32]byte
+	pk, err = passphraseKey(passphrase, f.Salt)
+	if err != nil {
+		return
+	}
+	f.Nonce = nonce[:]
+	f.Key = secretbox.Seal(nil, k.key[:], &nonce, pk)
+
+	var b []byte
+	b, err = json.Marshal(f)
+	if err != nil {
+		return
+	}
+	// write and rename so a failure never leaves us without a key
+	p := filepath.Join(dir, RestKeyFileName)
+	if err = ioutil.WriteFile(p+".tmp", b, 0600); err != nil {
+		return
+	}
+	err = os.Rename(p+".tmp", p)
+	return
+}
+
+// LoadRestKey loads the key stored in the given directory, returning nil if there isn't one
+func LoadRestKey(dir string, passphrase string) (k *RestKey, err error) {
+	if !FileExists(dir, RestKeyFileName) {
+		return
+	}
+	if passphrase == "" {
+		err = ErrNoPassphrase
+		return
+	}
+	var b []byte
+	b, err = ReadFile(dir, RestKeyFileName)
+	if err != nil {
+		return
+	}
+	var f restKeyFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return
+	}
+	var pk *[32]byte
+	pk, err = passphraseKey(passphrase, f.Salt)
+	if err != nil {
+		return
+	}
+	var nonce [24]byte
+	copy(nonce[:], f.Nonce)
+	key, ok := secretbox.Open(nil, f.Key, &nonce, pk)
+	if !ok || len(key) != 32 {
+		err = ErrBadPassphrase
+		return
+	}
+	k = &RestKey{}
+	copy(k.key[:], key)
+	return
+}
+
+// writeSealedPair writes a header and entry pair sealed with the key and prefixed by its length
+func writeSealedPair(writer io.Writer, k *RestKey, header *Header, entry Entry) (err error) {
+	var b bytes.Buffer
+	if err = writePair(&b, header, entry); err != nil {
+		return
+	}
+	var sealed []byte
+	sealed, err = k.seal(b.Bytes())
+	if err != nil {
+		return
+	}
+	if err = binary.Write(writer, binary.LittleEndian, uint32(len(sealed))); err != nil {
+		return
+	}
+	_, err = writer.Write(sealed)
+	return
+}
+
+// readSealedPair reads a pair written by writeSealedPair
+func readSealedPair(reader io.Reader, k *RestKey) (header *Header, entry Entry, err error) {
+	var l uint32
+	if err = binary.Read(reader, binary.LittleEndian, &l); err != nil {
+		return
+	}
+	sealed := make([]byte, l)
+	if _, err = io.ReadFull(reader, sealed); err != nil {
+		return
+	}
+	var b []byte
+	b, err = k.open(sealed)
+	if err != nil {
+		return
+	}
+	header, entry, err = readPair(ChainMarshalFlagsNone, bytes.NewReader(b))
+	return
+}
+
+// restStore is a DHTStore whose values are encrypted at rest
+type restStore struct {
+	DHTStore
+	key *RestKey
+}
+
+// Put implements DHTStore
+func (s *restStore) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	var sealed []byte
+	sealed, err = s.key.seal(value)
+	if err == nil {
+		err = s.DHTStore.Put(m, entryType, key, src, sealed, status)
+	}
+	return
+}
+
+// Get implements DHTStore
+func (s *restStore) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	data, entryType, sources, status, err = s.DHTStore.Get(key, statusMask, getMask)
+	// if there's an error data may hold the hash of a replacing entry rather than a value
+	if err == nil {
+		data, err = s.key.open(data)
+	}
+	return
+}
+
+// SetValue implements DHTStore
+func (s *restStore) SetValue(key Hash, value []byte) (err error) {
+	var sealed []byte
+	sealed, err = s.key.seal(value)
+	if err == nil {
+		err = s.DHTStore.SetValue(key, sealed)
+	}
+	return
+}
+
+// Iterate implements DHTStore
+func (s *restStore) Iterate(fn func(r DHTRecord) bool) (err error) {
+	var openErr error
+	err = s.DHTStore.Iterate(func(r DHTRecord) bool {
+		r.Value, openErr = s.key.open(r.Value)
+		if openErr != nil {
+			return false
+		}
+		return fn(r)
+	})
+	if err == nil {
+		err = openErr
+	}
+	return
+}
+
+// sealStore encrypts the values of an unencrypted store in place, returning the values
+// as they were so that restoreStore can undo it, even if it fails part way
+func sealStore(store DHTStore, k *RestKey) (values map[string][]byte, err error) {
+	values = make(map[string][]byte)
+	err = store.Iterate(func(r DHTRecord) bool {
+		values[r.Key] = r.Value
+		return true
+	})
+	if err != nil {
+		return
+	}
+	sealed := &restStore{DHTStore: store, key: k}
+	for key, value := range values {
+		var hash Hash
+		if hash, err = NewHash(key); err != nil {
+			return
+		}
+		if err = sealed.SetValue(hash, value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// restoreStore puts back the values a store held before it was sealed
+func restoreStore(store DHTStore, values map[string][]byte) (err error) {
+	for key, value := range values {
+		var hash Hash
+		if hash, err = NewHash(key); err != nil {
+			return
+		}
+		if err = store.SetValue(hash, value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// openChain loads the chain from the db directory along with the key it's encrypted with,
+// and verifies the headers added since it was last verified unless the config says not to.
+// If there's a passphrase for a new chain it will be encrypted from the start, existing
+// chains have to be encrypted with EncryptAtRest.
+func (h *Holochain) openChain() (err error) {
+	h.restKey, err = LoadRestKey(h.DBPath(), h.Config.Passphrase)
+	if err != nil {
+		return
+	}
+	path := filepath.Join(h.DBPath(), StoreFileName)
+	if h.restKey == nil && h.Config.Passphrase != "" {
+		if info, e := os.Stat(path); e == nil && info.Size() > 0 {
+			h.Debugf("chain isn't encrypted at rest, ignoring the passphrase")
+			h.Config.Passphrase = ""
+		} else {
+			if h.restKey, err = newRestKey(); err != nil {
+				return
+			}
+			if err = h.restKey.save(h.DBPath(), h.Config.Passphrase); err != nil {
+				return
+			}
+		}
+	}
+	h.chain, err = NewChainFromFileWithKey(h.hashSpec, path, h.restKey)
+	if err == nil && !h.Config.NoChainVerify {
+		err = h.chain.verifyNew()
+	}
+	return
+}
+
+// EncryptAtRest encrypts the chain and DHT store of a holochain that isn't yet encrypted
+// at rest.  The sealed key is saved before anything is encrypted with it, and if the store
+// or chain can't be encrypted the store is put back as it was and the key removed.  The
+// passphrase isn't saved, it has to be given in HC_PASSPHRASE to load the holochain.
+func (h *Holochain) EncryptAtRest(passphrase string) (err error) {
+	if h.restKey != nil || FileExists(h.DBPath(), RestKeyFileName) {
+		err = ErrAlreadyEncrypted
+		return
+	}
+	if passphrase == "" {
+		err = ErrNoPassphrase
+		return
+	}
+	var k *RestKey
+	if k, err = newRestKey(); err != nil {
+		return
+	}
+	if err = k.save(h.DBPath(), passphrase); err != nil {
+		return
+	}
+
+	var store DHTStore
+	if h.dht != nil {
+		store = h.dht.db
+	} else {
+		store, err = NewDHTStore(h.Config.DHTStoreType, h.DBPath())
+		if err != nil {
+			os.Remove(filepath.Join(h.DBPath(), RestKeyFileName))
+			return
+		}
+		defer store.Close()
+	}
+	var values map[string][]byte
+	values, err = sealStore(store, k)
+	if err == nil {
+		err = h.chain.rewrite(k)
+	}
+	// once the chain's file has been replaced it can only be read with the key
+	if err != nil && h.chain.rest != k {
+		if e := restoreStore(store, values); e != nil {
+			h.Debugf("couldn't restore the DHT store, keeping its key: %v", e)
+			return
+		}
+		os.Remove(filepath.Join(h.DBPath(), RestKeyFileName))
+		return
+	}
+
+	h.restKey = k
+	h.Config.Passphrase = passphrase
+	if h.dht != nil {
+		h.dht.db = &restStore{DHTStore: h.dht.db, key: k}
+		// sealing replaced every value behind the count's back
+		h.dht.recountStored()
+	}
+	return
+}
+
+// RotatePassphrase reseals the key a holochain is encrypted at rest with using a new
+// passphrase, which from then on has to be given in HC_PASSPHRASE to load the holochain
+func (h *Holochain) RotatePassphrase(passphrase string) (err error) {
+	if h.restKey == nil {
+		err = ErrNotEncrypted
+		return
+	}
+	if err = h.restKey.save(h.DBPath(), passphrase); err != nil {
+		return
+	}
+	h.Config.Passphrase = passphrase
+	return
+}
@@ -0,0 +1,130 @@
+package holochain
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestKey(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+
+	Convey("sealed data should open with the same key only", t, func() {
+		k, err := newRestKey()
+		So(err, ShouldBeNil)
+		sealed, err := k.seal([]byte("some data"))
+		So(err, ShouldBeNil)
+		So(bytes.Contains(sealed, []byte("some data")), ShouldBeFalse)
+		data, err := k.open(sealed)
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, "some data")
+
+		k2, _ := newRestKey()
+		_, err = k2.open(sealed)
+		So(err, ShouldEqual, ErrBadSealedData)
+	})
+
+	Convey("keys should only load with the passphrase they were saved with", t, func() {
+		k, err := LoadRestKey(d, "secret")
+		So(err, ShouldBeNil)
+		So(k, ShouldBeNil)
+
+		k, _ = newRestKey()
+		So(k.save(d, ""), ShouldEqual, ErrNoPassphrase)
+		So(k.save(d, "secret"), ShouldBeNil)
+
+		k2, err := LoadRestKey(d, "secret")
+		So(err, ShouldBeNil)
+		So(k2.key, ShouldEqual, k.key)
+
+		_, err = LoadRestKey(d, "wrong")
+		So(err, ShouldEqual, ErrBadPassphrase)
+		_, err = LoadRestKey(d, "")
+		So(err, ShouldEqual, ErrNoPassphrase)
+	})
+}
+
+func TestEncryptAtRest(t *testing.T) {
+	d, s, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	hash := commit(h, "privateData", "my secret")
+	chainFile := filepath.Join(h.DBPath(), StoreFileName)
+
+	Convey("it should not rotate the passphrase of an unencrypted chain", t, func() {
+		So(h.RotatePassphrase("secret"), ShouldEqual, ErrNotEncrypted)
+	})
+
+	Convey("it should leave everything as it was if encrypting fails", t, func() {
+		f := h.chain.s
+		h.chain.s = nil
+		err := h.EncryptAtRest("secret")
+		h.chain.s = f
+		So(err, ShouldNotBeNil)
+		So(FileExists(h.DBPath(), RestKeyFileName), ShouldBeFalse)
+		So(h.restKey, ShouldBeNil)
+		data, _, _, _, err := h.dht.db.Get(h.agentHash, StatusAny, GetMaskEntry)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(data, []byte(h.Agent().Identity())), ShouldBeTrue)
+	})
+
+	Convey("it should encrypt the chain and dht stores", t, func() {
+		err := h.EncryptAtRest("secret")
+		So(err, ShouldBeNil)
+		So(h.Config.Passphrase, ShouldEqual, "secret")
+		So(h.EncryptAtRest("secret"), ShouldEqual, ErrAlreadyEncrypted)
+
+		// the passphrase should never be written to the config
+		b, _ := ReadFile(h.rootPath, ConfigFileName+"."+h.encodingFormat)
+		So(bytes.Contains(b, []byte("Passphrase")), ShouldBeFalse)
+
+		b, _ = ReadFile(chainFile)
+		So(bytes.Contains(b, []byte("my secret")), ShouldBeFalse)
+
+		data, _, _, _, err := h.dht.db.(*restStore).DHTStore.Get(h.agentHash, StatusAny, GetMaskEntry)
+		So(err, ShouldBeNil)
+		So(bytes.Contains(data, []byte(h.Agent().Identity())), ShouldBeFalse)
+		_, _, _, _, err = h.dht.db.Get(h.agentHash, StatusAny, GetMaskEntry)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("new entries should be encrypted too", t, func() {
+		commit(h, "privateData", "my other secret")
+		b, _ := ReadFile(chainFile)
+		So(bytes.Contains(b, []byte("my other secret")), ShouldBeFalse)
+	})
+
+	dump := h.chain.String()
+	h.Close()
+
+	Convey("it should load the encrypted chain with the passphrase from the environment", t, func() {
+		defer os.Unsetenv(PassphraseEnvVar)
+		_, err := s.Load("test")
+		So(err, ShouldEqual, ErrNoPassphrase)
+
+		os.Setenv(PassphraseEnvVar, "secret")
+		h2, err := s.Load("test")
+		So(err, ShouldBeNil)
+		So(h2.chain.String(), ShouldEqual, dump)
+		entry, _, err := h2.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "my secret")
+
+		So(h2.RotatePassphrase("new secret"), ShouldBeNil)
+		h2.Close()
+		_, err = LoadRestKey(h2.DBPath(), "secret")
+		So(err, ShouldEqual, ErrBadPassphrase)
+
+		_, err = s.Load("test")
+		So(err, ShouldEqual, ErrBadPassphrase)
+		os.Setenv(PassphraseEnvVar, "new secret")
+		h2, err = s.Load("test")
+		So(err, ShouldBeNil)
+		So(h2.Config.Passphrase, ShouldEqual, "new secret")
+		So(h2.chain.String(), ShouldEqual, dump)
+		h2.Close()
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// messages are signed by the node that sends them so that receivers can check that the
+// node in Message.From really did, both when a message arrives directly and when it is
+// passed on by gossip
+
+package holochain
+
+import (
+	"errors"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	mh "github.com/multiformats/go-multihash"
+	"reflect"
+)
+
+const (
+	// the protocol version from which nodes sign all their messages
+	SignedMessagesVersion = 2
+)
+
+var ErrSenderMismatch = errors.New("message sender doesn't match remote peer")
+var ErrMessageSignature = errors.New("invalid message signature")
+var ErrMessageUnsigned = errors.New("message not signed")
+
+// signedDigest returns the hash of the canonical encoding of a message without its signature.
+// The wire tree is used rather than the bson of Fingerprint because its protobuf encoding sorts
+// maps, so it's the same however the message got here.
+func (m *Message) signedDigest() (digest []byte, err error) {
+	c := *m
+	c.Sig, c.Key = nil, nil
+	var tree interface{}
+	tree, err = toWireTree(reflect.ValueOf(c))
+	if err != nil {
+		return
+	}
+	var data []byte
+	data, err = pbMarshal(tree)
+	if err != nil {
+		return
+	}
+	digest, err = mh.Sum(data, mh.SHA2_256, -1)
+	return
+}
+
+// Sign signs a message with the private key of the node it's from
+func (m *Message) Sign(priv ic.PrivKey) (err error) {
+	var digest []byte
+	digest, err = m.signedDigest()
+	if err != nil {
+		return
+	}
+	var sig, key []byte
+	sig, err = priv.Sign(digest)
+	if err != nil {
+		return
+	}
+	key, err = ic.MarshalPublicKey(priv.GetPublic())
+	if err != nil {
+		return
+	}
+	m.Sig, m.Key = sig, key
+	return
+}
+
+// Verify checks that a message was signed by the node in its From field
+func (m *Message) Verify() (err error) {
+	if m.Sig == nil {
+		err = ErrMessageUnsigned
+		return
+	}
+	var pub ic.PubKey
+	pub, err = ic.UnmarshalPublicKey(m.Key)
+	if err != nil {
+		err = ErrMessageSignature
+		return
+	}
+	var id peer.ID
+	id, err = peer.IDFromPublicKey(pub)
+	if err != nil || id != m.From {
+		err = ErrMessageSignature
+		return
+	}
+	var digest []byte
+	digest, err = m.signedDigest()
+	if err != nil {
+		return
+	}
+	var ok bool
+	ok, err = pub.Verify(digest, m.Sig)
+	if err != nil || !ok {
+		err = ErrMessageSignature
+	}
+	return
+}
+
+// sign signs a message from this node
+func (node *Node) sign(m *Message) (err error) {
+	priv := node.peerstore.PrivKey(node.HashAddr)
+	if priv == nil {
+		err = errors.New("no private key to sign messages with")
+		return
+	}
+	err = m.Sign(priv)
+	return
+}
+
+// signs returns true if a peer has told us it signs its messages
+func (node *Node) signs(id peer.ID) bool {
+	p, ok := node.peerProtocol(id)
+	return ok && p.Version >= SignedMessagesVersion
+}
+
+// authenticate checks that a message received from a peer was sent by that peer, signed
+// messages must verify and only legacy peers may send unsigned ones
+func (node *Node) authenticate(from peer.ID, m *Message) (err error) {
+	if m.From != from {
+		err = ErrSenderMismatch
+		return
+	}
+	if m.Sig != nil {
+		err = m.Verify()
+	} else if node.signs(from) {
+		err = ErrMessageUnsigned
+	}
+	return
+}
+
+// blockSpoofer blocks a peer caught sending messages that aren't what they claim to be,
+// adding it to the blocked list so that it stays blocked
+func (h *Holochain) blockSpoofer(id peer.ID, reason error) {
+	h.node.log.Logf("blocking %v: %v", id, reason)
+	h.node.Block(id)
+	if h.dht == nil {
+		return
+	}
+	h.dht.DeleteGossiper(id) // ignore error
+	err := h.dht.addToList(nil, PeerList{Type: BlockedList, Records: []PeerRecord{{ID: id, Warrant: reason.Error()}}})
+	if err != nil {
+		h.node.log.Logf("unable to add %v to blocked list: %v", id, err)
+	}
+}
+
+// checkGossipPut decides whether a put received by gossip from a peer should be run.
+// Signed puts must verify, and if they don't the gossiper made them up so it's blocked.
+// Unsigned puts can only be trusted if they are from the gossiper itself.
+func (dht *DHT) checkGossipPut(gossiper peer.ID, p *Put) (ok bool, err error) {
+	if p.M.Sig != nil {
+		if err = p.M.Verify(); err != nil {
+			dht.h.blockSpoofer(gossiper, err)
+			return
+		}
+	} else if p.M.From != gossiper {
+		dht.glog.Logf("ignoring unsigned put %d from %v passed on by %v", p.Idx, p.M.From, gossiper)
+		return
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,128 @@
+package holochain
+
+import (
+	"context"
+	. "github.com/metacurrency/holochain/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestMessageSigning(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+
+	Convey("new messages should be signed by the node", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: hash})
+		So(m.Sig, ShouldNotBeNil)
+		So(m.Verify(), ShouldBeNil)
+	})
+
+	Convey("the signature should not change the fingerprint", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: hash})
+		f, _ := m.Fingerprint()
+		c := *m
+		c.Sig, c.Key = nil, nil
+		f2, _ := c.Fingerprint()
+		So(f2.String(), ShouldEqual, f.String())
+	})
+
+	Convey("changed messages should fail to verify", t, func() {
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: hash})
+		m.Body = PutReq{H: HashFromPeerID(h.nodeID)}
+		So(m.Verify(), ShouldEqual, ErrMessageSignature)
+
+		m = h.node.NewMessage(PUT_REQUEST, PutReq{H: hash})
+		m.From = "some other node"
+		So(m.Verify(), ShouldEqual, ErrMessageSignature)
+
+		m.Sig = nil
+		So(m.Verify(), ShouldEqual, ErrMessageUnsigned)
+	})
+}
+
+func TestAuthenticatedSenders(t *testing.T) {
+	nodesCount := 3
+	mt := setupSimMultiNodeTesting(nodesCount, 42)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	Convey("signed messages should be accepted", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		r, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+		So(r.Verify(), ShouldBeNil)
+	})
+
+	Convey("unsigned messages should be rejected from peers that sign", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		m.Sig, m.Key = nil, nil
+		r, err := h0.node.transport.Request(context.Background(), h1.nodeID, h0.node.protocols[GossipProtocol].ID, m, gobWireCodec)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).Message, ShouldEqual, ErrMessageUnsigned.Error())
+		So(h1.node.IsBlocked(h0.nodeID), ShouldBeFalse)
+	})
+
+	Convey("peers spoofing the sender should be blocked", t, func() {
+		m := h2.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		m.From = h0.nodeID
+		r, err := h2.node.transport.Request(context.Background(), h1.nodeID, h2.node.protocols[GossipProtocol].ID, m, gobWireCodec)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).Message, ShouldEqual, ErrSenderMismatch.Error())
+		So(h1.node.IsBlocked(h2.nodeID), ShouldBeTrue)
+		So(h1.node.IsBlocked(h0.nodeID), ShouldBeFalse)
+
+		list, err := h1.dht.getList(BlockedList)
+		So(err, ShouldBeNil)
+		So(len(list.Records), ShouldEqual, 1)
+		So(list.Records[0].ID, ShouldEqual, h2.nodeID)
+		So(list.Records[0].Warrant, ShouldEqual, ErrSenderMismatch.Error())
+		h1.node.Unblock(h2.nodeID)
+	})
+
+	Convey("peers sending badly signed messages should be blocked", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		m.Body = GossipReq{MyIdx: 100}
+		r, err := h0.node.transport.Request(context.Background(), h2.nodeID, h0.node.protocols[GossipProtocol].ID, m, gobWireCodec)
+		So(err, ShouldBeNil)
+		So(r.Body.(ErrorResponse).Message, ShouldEqual, ErrMessageSignature.Error())
+		So(h2.node.IsBlocked(h0.nodeID), ShouldBeTrue)
+		h2.node.Unblock(h0.nodeID)
+	})
+
+	Convey("gossiped puts should only be run if they can be trusted", t, func() {
+		hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+		p := Put{Idx: 1, M: *h0.node.NewMessage(PUT_REQUEST, PutReq{H: hash})}
+		ok, err := h1.dht.checkGossipPut(h2.nodeID, &p)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+
+		// unsigned puts from the gossiper itself are from a legacy node
+		p.M.Sig, p.M.Key = nil, nil
+		p.M.From = h2.nodeID
+		ok, err = h1.dht.checkGossipPut(h2.nodeID, &p)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+
+		// but unsigned puts passed on from others can't be checked
+		p.M.From = h0.nodeID
+		ok, err = h1.dht.checkGossipPut(h2.nodeID, &p)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		p = Put{Idx: 1, M: *h0.node.NewMessage(PUT_REQUEST, PutReq{H: hash})}
+		p.M.Body = PutReq{H: HashFromPeerID(h0.nodeID)}
+		ok, err = h1.dht.checkGossipPut(h2.nodeID, &p)
+		So(err, ShouldEqual, ErrMessageSignature)
+		So(ok, ShouldBeFalse)
+		So(h1.node.IsBlocked(h2.nodeID), ShouldBeTrue)
+	})
+}
@@ -24,6 +24,26 @@ type WalkerFn func(key *Hash, header *Header, entry Entry) error
 
 var ErrHashNotFound = errors.New("hash not found")
 var ErrIncompleteChain = errors.New("operation not allowed on incomplete chain")
+var ErrHeaderHashMismatch = errors.New("header doesn't match its hash")
+var ErrHeaderLinkMismatch = errors.New("header doesn't link to the previous header")
+var ErrTypeLinkMismatch = errors.New("header doesn't link to the previous header of its type")
+var ErrEntryHashMismatch = errors.New("entry doesn't match its header")
+var ErrHeaderSignature = errors.New("header signature doesn't verify with the agent's key")
+var ErrBadAgentEntry = errors.New("agent entry has no valid public key")
+
+// ChainError locates where a chain failed verification
+type ChainError struct {
+	Index  int   // index of the bad header and entry pair
+	Offset int64 // where the pair starts in the chain's file, -1 if it isn't in one
+	Err    error
+}
+
+func (e *ChainError) Error() string {
+	if e.Offset < 0 {
+		return fmt.Sprintf("chain corrupt at entry %d: %v", e.Index, e.Err)
+	}
+	return fmt.Sprintf("chain corrupt at entry %d (file offset %d): %v", e.Index, e.Offset, e.Err)
+}
 
 const (
 	ChainMarshalFlagsNone            = 0x00
@@ -46,6 +66,13 @@ type Chain struct {
 	//---
 
 	s        *os.File // if this stream is not nil, new entries will get marshaled to it
+	r        *os.File // entries that aren't in memory are read from the file with this
+	path     string
+	rest     *RestKey // if not nil the file is encrypted with this key
+	offsets  []int64  // where each pair starts in the file
+	size     int64    // length of the file
+	indexed  int      // number of pairs covered by the last checkpoint
+	verified int      // number of pairs whose headers are known to verify
 	hashSpec HashSpec
 	lk       sync.RWMutex
 }
@@ -68,70 +95,46 @@ func NewChain(hashSpec HashSpec) (chain *Chain) {
 // NewChainFromFile creates a chain from a file, loading any data there,
 // and setting it to be persisted to. If no file exists it will be created.
 func NewChainFromFile(spec HashSpec, path string) (c *Chain, err error) {
+	return NewChainFromFileWithKey(spec, path, nil)
+}
+
+// NewChainFromFileWithKey creates a chain from a file that is encrypted at rest with the
+// given key, or isn't if the key is nil
+func NewChainFromFileWithKey(spec HashSpec, path string, key *RestKey) (c *Chain, err error) {
 	defer func() {
 		if err != nil {
 			Debugf("error loading chain :%s", err.Error())
 		}
 	}()
 	c = NewChain(spec)
+	c.rest = key
+	c.path = path
 
-	var f *os.File
-	if FileExists(path) {
-		f, err = os.Open(path)
+	if !FileExists(path) {
+		var f *os.File
+		f, err = os.Create(path)
 		if err != nil {
 			return
 		}
-		var i int
-		for {
-			var header *Header
-			var e Entry
-			header, e, err = readPair(ChainMarshalFlagsNone, f)
-			if err != nil && err.Error() == "EOF" {
-				err = nil
-				break
-			}
-			if err != nil {
-				Debugf("error reading pair:%s", err.Error())
-				return
-			}
-			c.addPair(header, e, i)
-			i++
-		}
 		f.Close()
-		i--
-		// if we read anything then we have to calculate the final hash and add it
-		if i >= 0 {
-			hd := c.Headers[i]
-			var hash Hash
-
-			// hash the header
-			hash, _, err = hd.Sum(spec)
-			if err != nil {
-				return
-			}
-
-			c.Hashes = append(c.Hashes, hash)
-			c.Hmap[hash.String()] = i
-
-			// finally validate that it all hashes out correctly
-			/*			err = c.Validate(h)
-						if err != nil {
-							return
-						}
-			*/
-		}
+	}
+	c.r, err = os.Open(path)
+	if err != nil {
+		return
+	}
+	if err = c.load(); err != nil {
+		c.r.Close()
+		return
+	}
 
-		f, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
-		if err != nil {
-			return
-		}
-	} else {
-		f, err = os.Create(path)
-		if err != nil {
-			return
-		}
+	c.s, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		c.r.Close()
+		return
+	}
+	if len(c.Headers) > c.indexed {
+		err = c.checkpoint()
 	}
-	c.s = f
 	return
 }
 
@@ -151,6 +154,18 @@ func (c *Chain) Nth(n int) (header *Header) {
 	return
 }
 
+// Entry returns the i'th entry, reading it from the chain's file if it isn't in memory
+func (c *Chain) Entry(i int) (entry Entry, err error) {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+	if i < 0 || i >= len(c.Entries) {
+		err = ErrIncompleteChain
+		return
+	}
+	entry, err = c.entry(i)
+	return
+}
+
 // TopType returns the latest header of a given type
 func (c *Chain) TopType(entryType string) (hash *Hash, header *Header) {
 	c.lk.RLock()
@@ -230,14 +245,81 @@ func (c *Chain) addEntry(entryIdx int, hash Hash, header *Header, e Entry) (err
 	c.TypeTops[header.Type] = entryIdx
 	c.Emap[header.EntryLink.String()] = entryIdx
 	c.Hmap[hash.String()] = entryIdx
+	// pairs we add are as good as the chain they're added to
+	if c.verified == entryIdx {
+		c.verified++
+	}
 
 	if c.s != nil {
-		err = writePair(c.s, header, &g)
+		var b bytes.Buffer
+		if err = writeStoredPair(&b, c.rest, header, &g); err != nil {
+			return
+		}
+		if _, err = c.s.Write(b.Bytes()); err != nil {
+			return
+		}
+		c.offsets = append(c.offsets, c.size)
+		c.size += int64(b.Len())
+		if len(c.Headers)-c.indexed >= ChainCheckpointInterval {
+			err = c.checkpoint()
+		}
 	}
 
 	return
 }
 
+// rewrite replaces the chain's file with one encrypted with the given key
+func (c *Chain) rewrite(key *RestKey) (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.s == nil {
+		err = errors.New("chain has no file to rewrite")
+		return
+	}
+	tmp := c.path + ".tmp"
+	var f *os.File
+	f, err = os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	offsets := make([]int64, len(c.Headers))
+	var size int64
+	for i := range c.Headers {
+		var e Entry
+		if e, err = c.entry(i); err != nil {
+			break
+		}
+		var b bytes.Buffer
+		if err = writeStoredPair(&b, key, c.Headers[i], e); err != nil {
+			break
+		}
+		if _, err = f.Write(b.Bytes()); err != nil {
+			break
+		}
+		offsets[i] = size
+		size += int64(b.Len())
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmp, c.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return
+	}
+	c.rest, c.offsets, c.size = key, offsets, size
+	c.r.Close()
+	c.s.Close()
+	if c.r, err = os.Open(c.path); err != nil {
+		return
+	}
+	if c.s, err = os.OpenFile(c.path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return
+	}
+	err = c.checkpoint()
+	return
+}
+
 // Get returns the header of a given hash
 func (c *Chain) Get(h Hash) (header *Header, err error) {
 	c.lk.RLock()
@@ -257,7 +339,7 @@ func (c *Chain) GetEntry(h Hash) (entry Entry, entryType string, err error) {
 	defer c.lk.RUnlock()
 	i, ok := c.Emap[h.String()]
 	if ok {
-		entry = c.Entries[i]
+		entry, err = c.entry(i)
 		entryType = c.Headers[i].Type
 	} else {
 		err = ErrHashNotFound
@@ -355,7 +437,10 @@ func (c *Chain) MarshalChain(writer io.Writer, flags int64, whitelistTypes []str
 		var e Entry
 
 		if i == 0 || filterPass(i, hdr, whitelistTypes, empty) {
-			e = c.Entries[i]
+			e, err = c.entry(i)
+			if err != nil {
+				return
+			}
 
 			if (i == 0) && ((flags & ChainMarshalFlagsOmitDNA) != 0) {
 				e = &GobEntry{C: ""}
@@ -463,7 +548,11 @@ func UnmarshalChain(hashSpec HashSpec, reader io.Reader) (flags int64, c *Chain,
 func (c *Chain) Walk(fn WalkerFn) (err error) {
 	l := len(c.Headers)
 	for i := l - 1; i >= 0; i-- {
-		err = fn(&c.Hashes[i], c.Headers[i], c.Entries[i])
+		var e Entry
+		if e, err = c.entry(i); err != nil {
+			return
+		}
+		err = fn(&c.Hashes[i], c.Headers[i], e)
 		if err != nil {
 			return
 		}
@@ -501,8 +590,12 @@ func (c *Chain) Validate(skipEntries bool) (err error) {
 		}
 
 		if !skipEntries {
+			var e Entry
+			if e, err = c.entry(i); err != nil {
+				return
+			}
 			var b []byte
-			b, err = c.Entries[i].Marshal()
+			b, err = e.Marshal()
 			if err != nil {
 				return
 			}
@@ -520,6 +613,133 @@ func (c *Chain) Validate(skipEntries bool) (err error) {
 	return
 }
 
+// chainErr returns a ChainError locating the i'th pair
+func (c *Chain) chainErr(i int, err error) error {
+	offset := int64(-1)
+	if i < len(c.offsets) {
+		offset = c.offsets[i]
+	}
+	return &ChainError{Index: i, Offset: offset, Err: err}
+}
+
+// agentKey returns the public key in the agent entry at index i
+func (c *Chain) agentKey(i int) (pub ic.PubKey, err error) {
+	var e Entry
+	if e, err = c.entry(i); err != nil {
+		return
+	}
+	ae, ok := e.Content().(AgentEntry)
+	if !ok {
+		err = ErrBadAgentEntry
+		return
+	}
+	if pub, err = ic.UnmarshalPublicKey(ae.PublicKey); err != nil {
+		err = ErrBadAgentEntry
+	}
+	return
+}
+
+// Verify checks the hash, links and signature of every header, and unless skipEntries is
+// set that the entries match their headers, returning a ChainError for the first that fails.
+// Headers are signed with the key of the latest agent entry, and the DNA with the first's.
+func (c *Chain) Verify(skipEntries bool) (err error) {
+	c.lk.RLock()
+	defer c.lk.RUnlock()
+	err = c.verifyFrom(0, skipEntries)
+	return
+}
+
+// verifyNew checks the headers of the pairs added since the chain was last verified, and
+// saves a checkpoint so that they aren't checked again the next time it's loaded
+func (c *Chain) verifyNew() (err error) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.verified == len(c.Headers) {
+		return
+	}
+	if err = c.verifyFrom(c.verified, true); err != nil {
+		return
+	}
+	c.verified = len(c.Headers)
+	err = c.checkpoint()
+	return
+}
+
+// verifyFrom checks the pairs from index start on as Verify does, trusting the ones before
+// it.  Callers must hold the chain's lock.
+func (c *Chain) verifyFrom(start int, skipEntries bool) (err error) {
+	var pub ic.PubKey
+	typeTops := make(map[string]Hash)
+	for i := 0; i < start; i++ {
+		typeTops[c.Headers[i].Type] = c.Hashes[i]
+	}
+	for j := start - 1; j >= 0; j-- {
+		if c.Headers[j].Type == AgentEntryType {
+			if pub, err = c.agentKey(j); err != nil {
+				return c.chainErr(j, err)
+			}
+			break
+		}
+	}
+	for i := start; i < len(c.Headers); i++ {
+		hd := c.Headers[i]
+		var hash Hash
+		if hash, _, err = hd.Sum(c.hashSpec); err != nil {
+			return c.chainErr(i, err)
+		}
+		if !hash.Equal(&c.Hashes[i]) {
+			return c.chainErr(i, ErrHeaderHashMismatch)
+		}
+		prev := NullHash()
+		if i > 0 {
+			prev = c.Hashes[i-1]
+		}
+		if !hd.HeaderLink.Equal(&prev) {
+			return c.chainErr(i, ErrHeaderLinkMismatch)
+		}
+		prevType, ok := typeTops[hd.Type]
+		if !ok {
+			prevType = NullHash()
+		}
+		if !hd.TypeLink.Equal(&prevType) {
+			return c.chainErr(i, ErrTypeLinkMismatch)
+		}
+		typeTops[hd.Type] = hash
+
+		if hd.Type == AgentEntryType || (i == start && pub == nil) {
+			j := i
+			for j < len(c.Headers) && c.Headers[j].Type != AgentEntryType {
+				j++
+			}
+			if j < len(c.Headers) {
+				if pub, err = c.agentKey(j); err != nil {
+					return c.chainErr(j, err)
+				}
+			}
+		}
+		if pub != nil {
+			ok, e := pub.Verify(hd.EntryLink.H, hd.Sig.S)
+			if e != nil || !ok {
+				return c.chainErr(i, ErrHeaderSignature)
+			}
+		}
+
+		if !skipEntries {
+			var e Entry
+			if e, err = c.entry(i); err != nil {
+				return c.chainErr(i, err)
+			}
+			if hash, err = e.Sum(c.hashSpec); err != nil {
+				return c.chainErr(i, err)
+			}
+			if !hash.Equal(&hd.EntryLink) {
+				return c.chainErr(i, ErrEntryHashMismatch)
+			}
+		}
+	}
+	return
+}
+
 // String converts a chain to a textual dump of the headers and entries
 func (c *Chain) String() string {
 	c.lk.RLock()
@@ -533,7 +753,11 @@ func (c *Chain) String() string {
 		r += fmt.Sprintf("    Next Header: %v\n", hdr.HeaderLink)
 		r += fmt.Sprintf("    Next %s: %v\n", hdr.Type, hdr.TypeLink)
 		r += fmt.Sprintf("    Entry: %v\n", hdr.EntryLink)
-		e := c.Entries[i]
+		e, err := c.entry(i)
+		if err != nil {
+			r += fmt.Sprintf("       %v\n\n", err)
+			continue
+		}
 		switch hdr.Type {
 		case KeyEntryType:
 			r += fmt.Sprintf("       %v\n", e.(*GobEntry).C)
@@ -554,8 +778,19 @@ func (c *Chain) Length() int {
 	return len(c.Headers)
 }
 
-// Close the chain's file
+// Close the chain's file saving a last checkpoint
 func (c *Chain) Close() {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	if c.s != nil && len(c.Headers) > c.indexed {
+		if err := c.checkpoint(); err != nil {
+			Debugf("error saving chain index: %v", err)
+		}
+	}
 	c.s.Close()
 	c.s = nil
+	if c.r != nil {
+		c.r.Close()
+		c.r = nil
+	}
 }
@@ -0,0 +1,214 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// exporting chains for backup, migration and inspection, and importing them again.  The
+// binary format is what MarshalChain writes.  The JSON format holds each header and entry
+// in readable form along with the pair as stored in the chain's file, which is what gets
+// imported, as entry contents don't survive a trip through JSON.  The readable fields have
+// to match the pair so that what's read is what's imported.
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/metacurrency/holochain/hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	ExportFormatJSON   = "json"
+	ExportFormatBinary = "binary"
+)
+
+var ErrUnknownExportFormat = errors.New("unknown chain export format, use json or binary")
+var ErrExportMismatch = errors.New("exported fields don't match the exported pair")
+
+// ChainExport is the JSON form of an exported chain
+type ChainExport struct {
+	Pairs []ExportPair
+}
+
+// ExportPair is the JSON form of a header and entry pair
+type ExportPair struct {
+	Hash       string // of the header
+	Type       string
+	Time       time.Time
+	HeaderLink string
+	EntryLink  string
+	TypeLink   string
+	Change     string      `json:",omitempty"`
+	Content    interface{} // the entry's content for reading
+	Pair       []byte      // the header and entry as marshaled in the chain's file
+}
+
+// Export writes the whole chain to writer in the given format, unencrypted
+func (c *Chain) Export(writer io.Writer, format string) (err error) {
+	switch format {
+	case ExportFormatBinary:
+		err = c.MarshalChain(writer, ChainMarshalFlagsNone, nil, nil)
+	case ExportFormatJSON:
+		c.lk.RLock()
+		defer c.lk.RUnlock()
+		export := ChainExport{Pairs: make([]ExportPair, len(c.Headers))}
+		for i, header := range c.Headers {
+			var e Entry
+			if e, err = c.entry(i); err != nil {
+				return
+			}
+			var b bytes.Buffer
+			if err = writePair(&b, header, e); err != nil {
+				return
+			}
+			export.Pairs[i] = ExportPair{
+				Hash:       c.Hashes[i].String(),
+				Type:       header.Type,
+				Time:       header.Time,
+				HeaderLink: header.HeaderLink.String(),
+				EntryLink:  header.EntryLink.String(),
+				TypeLink:   header.TypeLink.String(),
+				Change:     header.Change.Action,
+				Content:    e.Content(),
+				Pair:       b.Bytes(),
+			}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(&export)
+	default:
+		err = ErrUnknownExportFormat
+	}
+	return
+}
+
+// matches returns true if the readable fields of an exported pair are those of the header
+// and entry it holds
+func (p *ExportPair) matches(header *Header, e Entry) bool {
+	if p.Type != header.Type || !p.Time.Equal(header.Time) || p.Change != header.Change.Action ||
+		p.HeaderLink != header.HeaderLink.String() || p.EntryLink != header.EntryLink.String() ||
+		p.TypeLink != header.TypeLink.String() {
+		return false
+	}
+	// the content is compared as JSON as that's the form it was read in
+	b, err := json.Marshal(e.Content())
+	if err != nil {
+		return false
+	}
+	var content interface{}
+	if json.Unmarshal(b, &content) != nil {
+		return false
+	}
+	b, _ = json.Marshal(content)
+	pb, err := json.Marshal(p.Content)
+	return err == nil && bytes.Equal(b, pb)
+}
+
+// ImportChain reads a chain exported in the given format into memory, it's up to the
+// caller to verify it
+func ImportChain(spec HashSpec, reader io.Reader, format string) (c *Chain, err error) {
+	switch format {
+	case ExportFormatBinary:
+		var flags int64
+		flags, c, err = UnmarshalChain(spec, reader)
+		if err == nil && flags != ChainMarshalFlagsNone {
+			err = ErrIncompleteChain
+		}
+	case ExportFormatJSON:
+		var export ChainExport
+		if err = json.NewDecoder(reader).Decode(&export); err != nil {
+			return
+		}
+		c = NewChain(spec)
+		for i, p := range export.Pairs {
+			var header *Header
+			var e Entry
+			header, e, err = readPair(ChainMarshalFlagsNone, bytes.NewReader(p.Pair))
+			if err != nil {
+				return
+			}
+			var hash Hash
+			if hash, _, err = header.Sum(spec); err != nil {
+				return
+			}
+			if hash.String() != p.Hash {
+				err = c.chainErr(i, ErrHeaderHashMismatch)
+				return
+			}
+			if !p.matches(header, e) {
+				err = c.chainErr(i, ErrExportMismatch)
+				return
+			}
+			if err = c.addEntry(i, hash, header, e); err != nil {
+				return
+			}
+		}
+	default:
+		err = ErrUnknownExportFormat
+	}
+	return
+}
+
+// ImportChain replaces this holochain's chain with one exported in the given format, after
+// checking it as VerifyChain does
+func (h *Holochain) ImportChain(reader io.Reader, format string) (err error) {
+	var c *Chain
+	if c, err = ImportChain(h.hashSpec, reader, format); err != nil {
+		return
+	}
+	if err = h.verifyChain(c); err != nil {
+		return
+	}
+
+	// write the new chain's file alongside the old one and then swap them
+	path := filepath.Join(h.DBPath(), StoreFileName)
+	tmp := path + ".import"
+	os.Remove(tmp)
+	os.Remove(indexPath(tmp))
+	var f *Chain
+	if f, err = NewChainFromFileWithKey(h.hashSpec, tmp, h.restKey); err != nil {
+		return
+	}
+	for i := range c.Headers {
+		if err = f.addEntry(i, c.Hashes[i], c.Headers[i], c.Entries[i]); err != nil {
+			break
+		}
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		os.Remove(indexPath(tmp))
+		return
+	}
+	wasOpen := h.chain != nil
+	if wasOpen {
+		h.chain.Close()
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		os.Remove(indexPath(tmp))
+		// the old chain is still in place so carry on with it
+		if wasOpen {
+			if e := h.openChain(); e != nil {
+				h.Debugf("error reopening chain after failed import: %v", e)
+			}
+		}
+		return
+	}
+	if e := os.Rename(indexPath(tmp), indexPath(path)); e != nil {
+		// the old index doesn't fit the new chain so it's loaded without one
+		os.Remove(indexPath(path))
+	}
+	if err = h.openChain(); err != nil {
+		return
+	}
+	h.dnaHash = h.chain.Headers[0].EntryLink.Clone()
+	h.agentHash = h.chain.Headers[1].EntryLink
+	_, topHeader := h.chain.TopType(AgentEntryType)
+	h.agentTopHash = topHeader.EntryLink
+	return
+}
@@ -0,0 +1,81 @@
+package holochain
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestChainExport(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	commit(h, "oddNumbers", "3")
+	commit(h, "evenNumbers", "2")
+	dump := h.chain.String()
+
+	for _, format := range []string{ExportFormatJSON, ExportFormatBinary} {
+		Convey("it should export and import chains as "+format, t, func() {
+			var b bytes.Buffer
+			err := h.chain.Export(&b, format)
+			So(err, ShouldBeNil)
+			exported := b.Bytes()
+
+			c, err := ImportChain(h.hashSpec, bytes.NewReader(exported), format)
+			So(err, ShouldBeNil)
+			So(c.String(), ShouldEqual, dump)
+
+			err = h.ImportChain(bytes.NewReader(exported), format)
+			So(err, ShouldBeNil)
+			So(h.chain.String(), ShouldEqual, dump)
+			So(h.VerifyChain(), ShouldBeNil)
+		})
+	}
+
+	Convey("exported JSON should be readable", t, func() {
+		var b bytes.Buffer
+		h.chain.Export(&b, ExportFormatJSON)
+		var export ChainExport
+		err := json.Unmarshal(b.Bytes(), &export)
+		So(err, ShouldBeNil)
+		l := len(export.Pairs)
+		So(l, ShouldEqual, h.chain.Length())
+		So(export.Pairs[l-1].Type, ShouldEqual, "evenNumbers")
+		So(export.Pairs[l-1].Content, ShouldEqual, "2")
+		So(export.Pairs[l-1].Hash, ShouldEqual, h.chain.Hashes[l-1].String())
+
+		// changing the readable fields should fail too
+		export.Pairs[l-1].Content = "4"
+		j, _ := json.Marshal(&export)
+		err = h.ImportChain(bytes.NewReader(j), ExportFormatJSON)
+		So(err.(*ChainError).Index, ShouldEqual, l-1)
+		So(err.(*ChainError).Err, ShouldEqual, ErrExportMismatch)
+		export.Pairs[l-1].Content = "2"
+		export.Pairs[1].Type = "evenNumbers"
+		j, _ = json.Marshal(&export)
+		err = h.ImportChain(bytes.NewReader(j), ExportFormatJSON)
+		So(err.(*ChainError).Index, ShouldEqual, 1)
+		So(err.(*ChainError).Err, ShouldEqual, ErrExportMismatch)
+		export.Pairs[1].Type = h.chain.Headers[1].Type
+
+		// changing the chain should fail verification
+		export.Pairs[l-1].Hash = export.Pairs[0].Hash
+		j, _ = json.Marshal(&export)
+		err = h.ImportChain(bytes.NewReader(j), ExportFormatJSON)
+		So(err.(*ChainError).Index, ShouldEqual, l-1)
+		So(err.(*ChainError).Err, ShouldEqual, ErrHeaderHashMismatch)
+
+		export.Pairs = export.Pairs[1:]
+		j, _ = json.Marshal(&export)
+		err = h.ImportChain(bytes.NewReader(j), ExportFormatJSON)
+		So(err.(*ChainError).Err, ShouldEqual, ErrHeaderLinkMismatch)
+		So(h.chain.String(), ShouldEqual, dump)
+	})
+
+	Convey("it should reject unknown formats", t, func() {
+		So(h.chain.Export(&bytes.Buffer{}, "xml"), ShouldEqual, ErrUnknownExportFormat)
+		_, err := ImportChain(h.hashSpec, &bytes.Buffer{}, "xml")
+		So(err, ShouldEqual, ErrUnknownExportFormat)
+	})
+}
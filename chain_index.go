@@ -0,0 +1,244 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// the index of a chain's file which is saved at checkpoints so that loading the chain only
+// has to read and hash the headers it covers rather than their entries too.  Entries are
+// read from the file when they're needed rather than all being held in memory.
+
+package holochain
+
+import (
+	"bufio"
+	"errors"
+	. "github.com/metacurrency/holochain/hash"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ChainCheckpointInterval is how many entries are added to a chain between saves of its index
+var ChainCheckpointInterval = 100
+
+var errBadChainIndex = errors.New("chain index doesn't match the chain")
+
+// chainIndex is what's saved in a chain's index file
+type chainIndex struct {
+	Size     int64          // length of the chain file the index covers
+	Offsets  []int64        // where each header and entry pair starts in the file
+	Hashes   []string       // the hash of each header
+	TypeTops map[string]int // index of the top entry of each type
+	Verified int            // how many of the pairs had been verified when it was saved
+}
+
+// indexPath returns the path of the index of the chain file at path
+func indexPath(path string) string {
+	return path + ".idx"
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += int64(n)
+	return
+}
+
+// readStoredPair reads a pair from a chain's file, decrypting it if there's a key
+func readStoredPair(reader io.Reader, key *RestKey) (header *Header, entry Entry, err error) {
+	if key == nil {
+		header, entry, err = readPair(ChainMarshalFlagsNone, reader)
+	} else {
+		header, entry, err = readSealedPair(reader, key)
+	}
+	return
+}
+
+// writeStoredPair writes a pair to a chain's file, encrypting it if there's a key
+func writeStoredPair(writer io.Writer, key *RestKey, header *Header, entry Entry) (err error) {
+	if key == nil {
+		err = writePair(writer, header, entry)
+	} else {
+		err = writeSealedPair(writer, key, header, entry)
+	}
+	return
+}
+
+// pairReader returns a reader of the i'th of the pairs at offsets in a file of the given size
+func pairReader(f *os.File, offsets []int64, size int64, i int) *io.SectionReader {
+	end := size
+	if i+1 < len(offsets) {
+		end = offsets[i+1]
+	}
+	return io.NewSectionReader(f, offsets[i], end-offsets[i])
+}
+
+// entry returns the i'th entry, reading it from the chain's file if it isn't in memory.
+// Callers must hold the chain's lock.
+func (c *Chain) entry(i int) (entry Entry, err error) {
+	if entry = c.Entries[i]; entry != nil {
+		return
+	}
+	if c.r == nil || i >= len(c.offsets) {
+		err = ErrIncompleteChain
+		return
+	}
+	_, entry, err = readStoredPair(pairReader(c.r, c.offsets, c.size, i), c.rest)
+	return
+}
+
+// appendHeader adds a header read from the chain's file leaving its entry to be loaded
+func (c *Chain) appendHeader(offset int64, hash Hash, header *Header) {
+	i := len(c.Headers)
+	c.Headers = append(c.Headers, header)
+	c.Entries = append(c.Entries, nil)
+	c.Hashes = append(c.Hashes, hash)
+	c.offsets = append(c.offsets, offset)
+	c.Emap[header.EntryLink.String()] = i
+	c.Hmap[hash.String()] = i
+}
+
+// reset empties the chain so it can be loaded again
+func (c *Chain) reset() {
+	n := NewChain(c.hashSpec)
+	c.Headers, c.Entries, c.Hashes = n.Headers, n.Entries, n.Hashes
+	c.TypeTops, c.Hmap, c.Emap = n.TypeTops, n.Hmap, n.Emap
+	c.offsets = nil
+	c.verified = 0
+}
+
+// load reads the headers from the chain's file, using the index for the pairs it covers
+// and reading and hashing any that were added after it was saved
+func (c *Chain) load() (err error) {
+	var info os.FileInfo
+	if info, err = c.r.Stat(); err != nil {
+		return
+	}
+	size := info.Size()
+
+	var start int64
+	if idx, e := c.readIndex(); e == nil && idx.Size <= size {
+		if e = c.loadIndexed(idx); e == nil {
+			start = idx.Size
+			c.indexed = len(c.Headers)
+		} else {
+			Debugf("ignoring chain index: %v", e)
+			c.reset()
+		}
+	}
+
+	reader := &countingReader{r: bufio.NewReader(io.NewSectionReader(c.r, start, size-start))}
+	for offset := start; offset < size; offset = start + reader.n {
+		var header *Header
+		header, _, err = readStoredPair(reader, c.rest)
+		if err != nil {
+			Debugf("error reading pair:%s", err.Error())
+			return
+		}
+		var hash Hash
+		hash, _, err = header.Sum(c.hashSpec)
+		if err != nil {
+			return
+		}
+		c.TypeTops[header.Type] = len(c.Headers)
+		c.appendHeader(offset, hash, header)
+	}
+	c.size = size
+	return
+}
+
+// loadIndexed reads the headers covered by an index, checking that each hashes to the hash
+// the index has for it and links to the one before
+func (c *Chain) loadIndexed(idx *chainIndex) (err error) {
+	if len(idx.Offsets) != len(idx.Hashes) {
+		err = errBadChainIndex
+		return
+	}
+	for i := range idx.Offsets {
+		var header *Header
+		r := pairReader(c.r, idx.Offsets, idx.Size, i)
+		if c.rest == nil {
+			header, _, err = readPair(ChainMarshalFlagsNoEntries, r)
+		} else {
+			header, _, err = readSealedPair(r, c.rest)
+		}
+		if err != nil {
+			return
+		}
+		var hash Hash
+		if hash, _, err = header.Sum(c.hashSpec); err != nil {
+			return
+		}
+		if hash.String() != idx.Hashes[i] {
+			err = errBadChainIndex
+			return
+		}
+		if i > 0 && !header.HeaderLink.Equal(&c.Hashes[i-1]) {
+			err = errBadChainIndex
+			return
+		}
+		c.appendHeader(idx.Offsets[i], hash, header)
+	}
+	for t, i := range idx.TypeTops {
+		c.TypeTops[t] = i
+	}
+	if idx.Verified <= len(c.Headers) {
+		c.verified = idx.Verified
+	}
+	return
+}
+
+// readIndex reads the chain's index file
+func (c *Chain) readIndex() (idx *chainIndex, err error) {
+	var b []byte
+	b, err = ioutil.ReadFile(indexPath(c.path))
+	if err != nil {
+		return
+	}
+	if c.rest != nil {
+		if b, err = c.rest.open(b); err != nil {
+			return
+		}
+	}
+	idx = &chainIndex{}
+	err = ByteDecoder(b, idx)
+	return
+}
+
+// checkpoint saves the chain's index and lets go of the entries it covers, which can
+// then be read from the file when they're needed.  Callers must hold the chain's lock.
+func (c *Chain) checkpoint() (err error) {
+	if c.s == nil {
+		return
+	}
+	idx := chainIndex{Size: c.size, Offsets: c.offsets, Hashes: make([]string, len(c.Hashes)), TypeTops: c.TypeTops, Verified: c.verified}
+	for i, hash := range c.Hashes {
+		idx.Hashes[i] = hash.String()
+	}
+	var b []byte
+	b, err = ByteEncoder(&idx)
+	if err != nil {
+		return
+	}
+	if c.rest != nil {
+		if b, err = c.rest.seal(b); err != nil {
+			return
+		}
+	}
+	p := indexPath(c.path)
+	if err = ioutil.WriteFile(p+".tmp", b, 0600); err != nil {
+		return
+	}
+	if err = os.Rename(p+".tmp", p); err != nil {
+		return
+	}
+	c.indexed = len(c.Headers)
+	for i := range c.Entries {
+		c.Entries[i] = nil
+	}
+	return
+}
@@ -6,6 +6,7 @@ import (
 	ic "github.com/libp2p/go-libp2p-crypto"
 	. "github.com/metacurrency/holochain/hash"
 	. "github.com/smartystreets/goconvey/convey"
+	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
@@ -61,6 +62,103 @@ func TestNewChainFromFile(t *testing.T) {
 	})
 }
 
+func TestChainIndex(t *testing.T) {
+	d := SetupTestDir()
+	defer CleanupTestDir(d)
+	hashSpec, key, now := chainTestSetup()
+
+	defer func(n int) { ChainCheckpointInterval = n }(ChainCheckpointInterval)
+	ChainCheckpointInterval = 2
+
+	path := filepath.Join(d, "chain.dat")
+	c, _ := NewChainFromFile(hashSpec, path)
+	for i := 0; i < 5; i++ {
+		c.AddEntry(now, "entryTypeFoo", &GobEntry{C: fmt.Sprintf("data %d", i)}, key)
+	}
+
+	Convey("checkpoints should save the index and let go of the entries", t, func() {
+		So(FileExists(indexPath(path)), ShouldBeTrue)
+		So(c.indexed, ShouldEqual, 4)
+		So(c.Entries[0], ShouldBeNil)
+		So(c.Entries[4], ShouldNotBeNil)
+		entry, _, err := c.GetEntry(c.Headers[1].EntryLink)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "data 1")
+		entry, err = c.Entry(0)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "data 0")
+		_, err = c.Entry(5)
+		So(err, ShouldEqual, ErrIncompleteChain)
+	})
+
+	dump := c.String()
+	c.Close()
+
+	Convey("the chain should load from its index", t, func() {
+		c, err := NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.indexed, ShouldEqual, 5)
+		So(c.String(), ShouldEqual, dump)
+		So(c.TypeTops["entryTypeFoo"], ShouldEqual, 4)
+		entry, _, err := c.GetEntry(c.Headers[4].EntryLink)
+		So(err, ShouldBeNil)
+		So(entry.Content(), ShouldEqual, "data 4")
+
+		// only the pairs after those the index says were verified are checked
+		So(c.verified, ShouldEqual, 5)
+		c.verified = 3
+		link := c.Headers[4].TypeLink
+		c.Headers[4].TypeLink = NullHash() // tweak
+		err = c.verifyNew()
+		So(err.(*ChainError).Index, ShouldEqual, 4)
+		So(c.verified, ShouldEqual, 3)
+		c.Headers[4].TypeLink = link // restore
+		So(c.verifyNew(), ShouldBeNil)
+		So(c.verified, ShouldEqual, 5)
+		c.Close()
+	})
+
+	Convey("an index that doesn't match the chain should be ignored", t, func() {
+		c, _ := NewChainFromFile(hashSpec, path)
+		c.Hashes[2] = c.Hashes[3]
+		c.checkpoint()
+		c.Close()
+		c, err := NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		c.Close()
+	})
+
+	Convey("a header changed in the file should be caught even if it's indexed", t, func() {
+		c, _ := NewChainFromFile(hashSpec, path)
+		c.Headers[1].Time = c.Headers[1].Time.Add(time.Second) // tweak, leaving the index's hash
+		So(c.rewrite(nil), ShouldBeNil)
+		c.Close()
+		c, err := NewChainFromFile(hashSpec, path)
+		So(err, ShouldBeNil)
+		So(c.verified, ShouldEqual, 0)
+		err = c.verifyNew()
+		So(err.(*ChainError).Index, ShouldEqual, 2)
+		So(err.(*ChainError).Err, ShouldEqual, ErrHeaderLinkMismatch)
+		c.Close()
+	})
+
+	Convey("encrypted chains should be indexed and lazy loaded too", t, func() {
+		k, _ := newRestKey()
+		path := filepath.Join(d, "sealed.dat")
+		c, _ := NewChainFromFileWithKey(hashSpec, path, k)
+		for i := 0; i < 3; i++ {
+			c.AddEntry(now, "entryTypeFoo", &GobEntry{C: fmt.Sprintf("data %d", i)}, key)
+		}
+		dump := c.String()
+		c.Close()
+		c, err := NewChainFromFileWithKey(hashSpec, path, k)
+		So(err, ShouldBeNil)
+		So(c.String(), ShouldEqual, dump)
+		c.Close()
+	})
+}
+
 func TestTop(t *testing.T) {
 	hashSpec, key, now := chainTestSetup()
 	c := NewChain(hashSpec)
@@ -469,3 +567,64 @@ func chainTestSetup() (hs HashSpec, key ic.PrivKey, now time.Time) {
 	hs = hP.hashSpec
 	return
 }
+
+func TestVerifyChain(t *testing.T) {
+	d, s, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	commit(h, "oddNumbers", "3")
+	commit(h, "evenNumbers", "2")
+	c := h.chain
+
+	Convey("it should verify a good chain", t, func() {
+		So(c.Verify(false), ShouldBeNil)
+		So(h.VerifyChain(), ShouldBeNil)
+	})
+
+	Convey("it should locate broken links", t, func() {
+		top := len(c.Headers) - 1
+		link := c.Headers[top].TypeLink
+		c.Headers[top].TypeLink = NullHash() // tweak
+		err := c.Verify(true)
+		So(err.(*ChainError).Index, ShouldEqual, top)
+		So(err.(*ChainError).Err, ShouldEqual, ErrHeaderHashMismatch)
+
+		c.Hashes[top], _, _ = c.Headers[top].Sum(c.hashSpec)
+		err = c.Verify(true)
+		So(err.(*ChainError).Err, ShouldEqual, ErrTypeLinkMismatch)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("chain corrupt at entry %d (file offset %d): %v", top, c.offsets[top], ErrTypeLinkMismatch))
+
+		c.Headers[top].TypeLink = link // restore
+		c.Hashes[top], _, _ = c.Headers[top].Sum(c.hashSpec)
+		So(c.Verify(false), ShouldBeNil)
+	})
+
+	Convey("it should check the DNA hash", t, func() {
+		err := WriteFile([]byte("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2"), h.rootPath, DNAHashFileName)
+		So(err, ShouldBeNil)
+		err = h.VerifyChain()
+		So(err.(*ChainError).Index, ShouldEqual, 0)
+	})
+
+	Convey("it should find headers not signed by the agent", t, func() {
+		a, _ := NewAgent(LibP2P, "someone else", MakeTestSeed("someone else"))
+		top := len(c.Headers) - 1
+		sig := c.Headers[top].Sig
+		c.Headers[top].Sig.S, _ = a.PrivKey().Sign(c.Headers[top].EntryLink.H) // tweak
+		c.Hashes[top], _, _ = c.Headers[top].Sum(c.hashSpec)
+		err := c.Verify(true)
+		So(err.(*ChainError).Index, ShouldEqual, top)
+		So(err.(*ChainError).Err, ShouldEqual, ErrHeaderSignature)
+
+		// and refuse to load a chain file holding them
+		So(c.rewrite(nil), ShouldBeNil)
+		h.Close()
+		os.Remove(indexPath(c.path)) // without an index the whole chain is verified
+		_, err = s.Load("test")
+		So(err.(*ChainError).Err, ShouldEqual, ErrHeaderSignature)
+
+		c.Headers[top].Sig = sig // restore
+		c.Hashes[top], _, _ = c.Headers[top].Sum(c.hashSpec)
+		So(c.Verify(true), ShouldBeNil)
+	})
+}
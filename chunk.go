@@ -0,0 +1,150 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// large entries are split into chunks that are committed and put to the DHT as entries of
+// their own, so no single chain entry or message has to carry all of the data.  What gets
+// committed under the app's entry type is a manifest of the chunks, which commit and get
+// assemble back into the entry so that apps never see it.
+
+package holochain
+
+import (
+	"errors"
+	. "github.com/metacurrency/holochain/hash"
+)
+
+// ChunkSize is the size above which public entries are chunked and the size of the chunks
+var ChunkSize = 256 * 1024
+
+var ErrChunkMismatch = errors.New("chunked entry doesn't match its manifest")
+
+// ChunkManifest is committed in place of an entry that has been split into chunks
+type ChunkManifest struct {
+	Size   int    // length of the entry's content
+	Binary bool   // true if the content is bytes rather than a string
+	Chunks []Hash // hashes of the chunk entries in order
+}
+
+// chunkable returns the content of an entry if it's large enough to be chunked.
+// Only public entries are chunked as the others never leave the chain.
+func chunkable(def *EntryDef, entry Entry) (content []byte, ok bool) {
+	if def.IsSysEntry() || def.DataFormat == DataFormatLinks || def.Sharing != Public {
+		return
+	}
+	switch c := entry.Content().(type) {
+	case string:
+		content = []byte(c)
+	case []byte:
+		content = c
+	}
+	ok = len(content) > ChunkSize
+	return
+}
+
+// splitEntry splits content into chunk entries of at most ChunkSize bytes
+func splitEntry(content []byte) (chunks []Entry) {
+	for len(content) > 0 {
+		n := ChunkSize
+		if n > len(content) {
+			n = len(content)
+		}
+		chunks = append(chunks, &GobEntry{C: content[:n]})
+		content = content[n:]
+	}
+	return
+}
+
+// commitChunks commits content as chunks and returns the manifest entry listing them
+func (h *Holochain) commitChunks(content []byte, binary bool) (manifest Entry, err error) {
+	m := ChunkManifest{Size: len(content), Binary: binary}
+	for _, chunk := range splitEntry(content) {
+		var r interface{}
+		r, err = NewCommitAction(ChunkEntryType, chunk).Do(h)
+		if err != nil {
+			return
+		}
+		m.Chunks = append(m.Chunks, r.(Hash))
+	}
+	h.Debugf("committed %d bytes as %d chunks", m.Size, len(m.Chunks))
+	manifest = &GobEntry{C: m}
+	return
+}
+
+// getChunk returns the data of a chunk from the local chain or failing that the DHT
+func (h *Holochain) getChunk(hash Hash) (data []byte, err error) {
+	var entry Entry
+	var entryType string
+	entry, entryType, err = h.chain.GetEntry(hash)
+	if err == ErrHashNotFound {
+		options := GetOptions{StatusMask: StatusLive, GetMask: GetMaskEntry | GetMaskEntryType}
+		req := GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+		var r interface{}
+		r, err = NewGetAction(req, &options).Do(h)
+		if err != nil {
+			return
+		}
+		resp := r.(GetResp)
+		entry, entryType = &resp.Entry, resp.EntryType
+	} else if err != nil {
+		return
+	}
+
+	// chunks are content addressed so whoever we got it from can't change it
+	var sum Hash
+	sum, err = entry.Sum(h.hashSpec)
+	if err != nil {
+		return
+	}
+	data, ok := entry.Content().([]byte)
+	if !ok || entryType != ChunkEntryType || !sum.Equal(&hash) {
+		err = ErrChunkMismatch
+	}
+	return
+}
+
+// missingChunk returns true if an entry is a chunk manifest one of whose chunks can't be found
+func (h *Holochain) missingChunk(entry Entry) bool {
+	m, ok := entry.Content().(ChunkManifest)
+	if !ok {
+		return false
+	}
+	for _, hash := range m.Chunks {
+		if _, err := h.getChunk(hash); err == ErrHashNotFound {
+			return true
+		}
+	}
+	return false
+}
+
+// assembleEntry returns the entry a chunk manifest stands for, or the entry itself
+// if it isn't one
+func (h *Holochain) assembleEntry(entry Entry) (assembled Entry, err error) {
+	assembled = entry
+	if entry == nil {
+		return
+	}
+	m, ok := entry.Content().(ChunkManifest)
+	if !ok {
+		return
+	}
+	b := make([]byte, 0, m.Size)
+	for _, hash := range m.Chunks {
+		var chunk []byte
+		chunk, err = h.getChunk(hash)
+		if err != nil {
+			return
+		}
+		b = append(b, chunk...)
+	}
+	if len(b) != m.Size {
+		err = ErrChunkMismatch
+		return
+	}
+	if m.Binary {
+		assembled = &GobEntry{C: b}
+	} else {
+		assembled = &GobEntry{C: string(b)}
+	}
+	return
+}
@@ -0,0 +1,146 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/metacurrency/holochain/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+)
+
+func TestChunkedEntries(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	defer func(size int) { ChunkSize = size }(ChunkSize)
+	ChunkSize = 16
+
+	profile := fmt.Sprintf(`{"firstName":"%s","lastName":"Smith"}`, strings.Repeat("Jo", 20))
+	headers := len(h.chain.Headers)
+	hash := commit(h, "profile", profile)
+
+	Convey("large entries should be committed as chunks and a manifest", t, func() {
+		chunks := (len(profile) + ChunkSize - 1) / ChunkSize
+		So(len(h.chain.Headers), ShouldEqual, headers+chunks+1)
+		So(h.chain.Headers[headers].Type, ShouldEqual, ChunkEntryType)
+
+		entry, entryType, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "profile")
+		m := entry.Content().(ChunkManifest)
+		So(m.Size, ShouldEqual, len(profile))
+		So(len(m.Chunks), ShouldEqual, chunks)
+		data, err := h.getChunk(m.Chunks[0])
+		So(err, ShouldBeNil)
+		So(string(data), ShouldEqual, profile[:ChunkSize])
+	})
+
+	Convey("binary entries should be chunked too", t, func() {
+		image := []byte(strings.Repeat("\x00\xff", 20))
+		r, err := NewCommitAction("image", &GobEntry{C: image}).Do(h)
+		So(err, ShouldBeNil)
+		entry, _, _ := h.chain.GetEntry(r.(Hash))
+		So(entry.Content().(ChunkManifest).Binary, ShouldBeTrue)
+		assembled, err := h.assembleEntry(entry)
+		So(err, ShouldBeNil)
+		So(assembled.Content(), ShouldResemble, image)
+
+		// and updates of them validate as the assembled entry
+		image = []byte(strings.Repeat("\x01\xfe", 20))
+		r, err = NewModAction("image", &GobEntry{C: image}, r.(Hash)).Do(h)
+		So(err, ShouldBeNil)
+		entry, _, _ = h.chain.GetEntry(r.(Hash))
+		So(entry.Content().(ChunkManifest).Binary, ShouldBeTrue)
+	})
+
+	Convey("small and private entries should not be chunked", t, func() {
+		headers := len(h.chain.Headers)
+		commit(h, "profile", `{"firstName":"Jo","lastName":"Smith"}`)
+		commit(h, "privateData", strings.Repeat("x", 100))
+		So(len(h.chain.Headers), ShouldEqual, headers+2)
+	})
+
+	Convey("get should assemble chunked entries", t, func() {
+		for _, local := range []bool{true, false} {
+			options := GetOptions{StatusMask: StatusDefault, GetMask: GetMaskEntry, Local: local}
+			req := GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+			r, err := NewGetAction(req, &options).Do(h)
+			So(err, ShouldBeNil)
+			So(r.(GetResp).Entry.C, ShouldEqual, profile)
+		}
+	})
+
+	Convey("query should return assembled entries and not the chunks", t, func() {
+		r, err := h.Query(&QueryOptions{Constrain: QueryConstrain{EntryTypes: []string{"profile"}}})
+		So(err, ShouldBeNil)
+		So(r[len(r)-1].Entry.Content(), ShouldEqual, profile)
+
+		r, err = h.Query(&QueryOptions{Return: QueryReturn{Headers: true}})
+		So(err, ShouldBeNil)
+		for _, qr := range r {
+			So(qr.Header.Type, ShouldNotEqual, ChunkEntryType)
+		}
+	})
+
+	Convey("chunks should be checked against their hashes", t, func() {
+		entry, _, _ := h.chain.GetEntry(hash)
+		m := entry.Content().(ChunkManifest)
+		chunk, _, _ := h.chain.GetEntry(m.Chunks[0])
+		data := chunk.(*GobEntry).C
+		chunk.(*GobEntry).C = []byte("something else")
+		_, err := h.getChunk(m.Chunks[0])
+		So(err, ShouldEqual, ErrChunkMismatch)
+		chunk.(*GobEntry).C = data
+
+		m.Size++
+		_, err = h.assembleEntry(&GobEntry{C: m})
+		So(err, ShouldEqual, ErrChunkMismatch)
+	})
+
+	Convey("only manifests with chunks that can't be found should be missing chunks", t, func() {
+		entry, _, _ := h.chain.GetEntry(hash)
+		So(h.missingChunk(entry), ShouldBeFalse)
+		So(h.missingChunk(&GobEntry{C: "not a manifest"}), ShouldBeFalse)
+
+		m := entry.Content().(ChunkManifest)
+		missing, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		m.Chunks = append([]Hash{missing}, m.Chunks...)
+		So(h.missingChunk(&GobEntry{C: m}), ShouldBeTrue)
+	})
+
+	Convey("ribosomes should commit and get chunked entries transparently", t, func() {
+		r, err := h.Call("jsSampleZome", "addProfile", profile, ZOME_EXPOSURE)
+		So(err, ShouldBeNil)
+		var hash2 Hash
+		hash2, err = NewHash(strings.Trim(r.(string), `"`))
+		So(err, ShouldBeNil)
+		So(hash2.String(), ShouldEqual, hash.String())
+
+		n, _, _ := h.MakeRibosome("jsSampleZome")
+		_, err = n.Run(fmt.Sprintf(`get("%s")`, hash.String()))
+		So(err, ShouldBeNil)
+		So(n.(*JSRibosome).lastResult.String(), ShouldEqual, profile)
+	})
+}
+
+func TestChunkedEntriesMultiNode(t *testing.T) {
+	nodesCount := 3
+	mt := setupSimMultiNodeTesting(nodesCount, 42)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	defer func(size int) { ChunkSize = size }(ChunkSize)
+	ChunkSize = 16
+
+	profile := fmt.Sprintf(`{"firstName":"%s","lastName":"Smith"}`, strings.Repeat("Jo", 20))
+	hash := commit(nodes[0], "profile", profile)
+
+	Convey("other nodes should get the assembled entry", t, func() {
+		options := GetOptions{StatusMask: StatusDefault, GetMask: GetMaskEntry}
+		req := GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+		r, err := NewGetAction(req, &options).Do(nodes[1])
+		So(err, ShouldBeNil)
+		So(r.(GetResp).Entry.C, ShouldEqual, profile)
+	})
+}
@@ -28,6 +28,8 @@ func setupApp() (app *cli.App) {
 	var root string
 	var service *holo.Service
 	var bridgeToAppData, bridgeFromAppData string
+	var passphrase string
+	var format string
 
 	app.Flags = []cli.Flag{
 		cli.BoolFlag{
@@ -210,6 +212,139 @@ func setupApp() (app *cli.App) {
 				return err
 			},
 		},
+		{
+			Name:      "encrypt",
+			ArgsUsage: "holochain-name",
+			Usage:     "encrypts a holochain's chain and dht data at rest with a passphrase",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "passphrase",
+					Usage:       "passphrase to encrypt with, which must be given in HC_PASSPHRASE to load the holochain after",
+					EnvVar:      "HC_PASSPHRASE",
+					Destination: &passphrase,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if passphrase == "" {
+					return errors.New("encrypt: missing required passphrase")
+				}
+				h, err := cmd.GetHolochain(c.Args().First(), service, "encrypt")
+				if err != nil {
+					return err
+				}
+				err = h.EncryptAtRest(passphrase)
+				if err == nil && verbose {
+					fmt.Printf("encrypted %s\n", c.Args().First())
+				}
+				return err
+			},
+		},
+		{
+			Name:      "passphrase",
+			ArgsUsage: "holochain-name",
+			Usage:     "changes the passphrase a holochain's data is encrypted at rest with",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "new-passphrase",
+					Usage:       "the new passphrase, the current one is read from HC_PASSPHRASE",
+					EnvVar:      "HC_NEW_PASSPHRASE",
+					Destination: &passphrase,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				if passphrase == "" {
+					return errors.New("passphrase: missing required new-passphrase")
+				}
+				h, err := cmd.GetHolochain(c.Args().First(), service, "passphrase")
+				if err != nil {
+					return err
+				}
+				err = h.RotatePassphrase(passphrase)
+				if err == nil && verbose {
+					fmt.Printf("changed passphrase for %s\n", c.Args().First())
+				}
+				return err
+			},
+		},
+		{
+			Name:      "verify",
+			ArgsUsage: "holochain-name",
+			Usage:     "checks a holochain's chain file, its DNA hash and agent, and its entries against the DNA",
+			Action: func(c *cli.Context) error {
+				h, err := cmd.GetHolochain(c.Args().First(), service, "verify")
+				if err != nil {
+					return err
+				}
+				if err = h.VerifyChain(); err != nil {
+					return err
+				}
+				fmt.Printf("%s: chain of %d entries verified\n", c.Args().First(), h.Chain().Length())
+				return nil
+			},
+		},
+		{
+			Name:      "export",
+			ArgsUsage: "holochain-name [file]",
+			Usage:     "exports a holochain's chain, to stdout if no file is given",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "json or binary",
+					Value:       holo.ExportFormatJSON,
+					Destination: &format,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				h, err := cmd.GetHolochain(c.Args().First(), service, "export")
+				if err != nil {
+					return err
+				}
+				out := os.Stdout
+				if file := c.Args().Get(1); file != "" {
+					out, err = os.Create(file)
+					if err != nil {
+						return err
+					}
+					defer out.Close()
+				}
+				return h.Chain().Export(out, format)
+			},
+		},
+		{
+			Name:      "import",
+			ArgsUsage: "holochain-name file",
+			Usage:     "replaces a holochain's chain with an exported one after verifying it",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:        "format",
+					Usage:       "json or binary",
+					Value:       holo.ExportFormatJSON,
+					Destination: &format,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				file := c.Args().Get(1)
+				if file == "" {
+					return errors.New("import: missing required file argument")
+				}
+				h, err := cmd.GetHolochain(c.Args().First(), service, "import")
+				if err != nil {
+					return err
+				}
+				in, err := os.Open(file)
+				if err != nil {
+					return err
+				}
+				defer in.Close()
+				if err = h.ImportChain(in, format); err != nil {
+					return err
+				}
+				if verbose {
+					fmt.Printf("imported chain of %d entries to %s\n", h.Chain().Length(), c.Args().First())
+				}
+				return nil
+			},
+		},
 		{
 			Name:      "status",
 			Aliases:   []string{"s"},
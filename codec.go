@@ -0,0 +1,562 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// codecs encode messages for the wire.  Gob is the default and what older nodes speak; the
+// others first convert a message into a "wire tree" of plain values (nil, bool, int64, uint64,
+// float64, string, []byte, []interface{} and map[interface{}]interface{}) so that tools not
+// written in Go can read and write them.  In the tree structs are maps keyed by field name,
+// times are RFC3339 strings, and values held in interface fields are maps with the
+// registered name of the value's type in "_t" and the value in "_v".
+
+package holochain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/ugorji/go/codec"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	// constants for the codecs that can be set in the Config
+
+	GobCodec      = "gob"
+	CBORCodec     = "cbor"
+	ProtobufCodec = "protobuf"
+
+	// the largest non-gob message we will read off the wire
+	MaxWireMessageSize = 64 * 1024 * 1024
+
+	// wire tree keys of a value held in an interface
+	wireTypeKey  = "_t"
+	wireValueKey = "_v"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec")
+
+// Codec converts messages to and from bytes
+type Codec interface {
+	Name() string
+	Marshal(m *Message) (data []byte, err error)
+	Unmarshal(data []byte, m *Message) (err error)
+}
+
+// treeCodec is a codec that encodes a message's wire tree
+type treeCodec struct {
+	name      string
+	id        byte
+	marshal   func(tree interface{}) ([]byte, error)
+	unmarshal func(data []byte) (interface{}, error)
+}
+
+// gobCodec is the gob codec
+type gobCodec struct{}
+
+var gobWireCodec = gobCodec{}
+
+var cborHandle codec.CborHandle
+
+var wireCodecs = map[string]Codec{
+	GobCodec: gobWireCodec,
+	CBORCodec: &treeCodec{name: CBORCodec, id: 1,
+		marshal: func(tree interface{}) (data []byte, err error) {
+			err = codec.NewEncoderBytes(&data, &cborHandle).Encode(tree)
+			return
+		},
+		unmarshal: func(data []byte) (tree interface{}, err error) {
+			err = codec.NewDecoderBytes(data, &cborHandle).Decode(&tree)
+			return
+		},
+	},
+	ProtobufCodec: &treeCodec{name: ProtobufCodec, id: 2, marshal: pbMarshal, unmarshal: pbUnmarshal},
+}
+
+// GetCodec returns the codec with the given name
+func GetCodec(name string) (c Codec, err error) {
+	c, ok := wireCodecs[name]
+	if !ok {
+		err = ErrUnknownCodec
+	}
+	return
+}
+
+// codecByID returns the tree codec with the given framing id
+func codecByID(id byte) (c *treeCodec, err error) {
+	for _, wc := range wireCodecs {
+		if tc, ok := wc.(*treeCodec); ok && tc.id == id {
+			c = tc
+			return
+		}
+	}
+	err = fmt.Errorf("unknown codec id %d", id)
+	return
+}
+
+func (c gobCodec) Name() string {
+	return GobCodec
+}
+
+func (c gobCodec) Marshal(m *Message) (data []byte, err error) {
+	data, err = ByteEncoder(m)
+	return
+}
+
+func (c gobCodec) Unmarshal(data []byte, m *Message) (err error) {
+	err = ByteDecoder(data, m)
+	return
+}
+
+func (c *treeCodec) Name() string {
+	return c.name
+}
+
+func (c *treeCodec) Marshal(m *Message) (data []byte, err error) {
+	var tree interface{}
+	tree, err = toWireTree(reflect.ValueOf(m).Elem())
+	if err != nil {
+		return
+	}
+	data, err = c.marshal(tree)
+	return
+}
+
+func (c *treeCodec) Unmarshal(data []byte, m *Message) (err error) {
+	var tree interface{}
+	tree, err = c.unmarshal(data)
+	if err != nil {
+		return
+	}
+	err = fromWireTree(tree, reflect.ValueOf(m).Elem())
+	return
+}
+
+// EncodeMessage encodes a message for the wire with the given codec.  Gob messages are
+// sent as is so older nodes can read them.  Other codecs' messages are framed with a zero
+// byte, which never starts a gob stream, the codec's id and the length of the message.
+func EncodeMessage(m *Message, c Codec) (data []byte, err error) {
+	if c == nil {
+		c = gobWireCodec
+	}
+	tc, ok := c.(*treeCodec)
+	if !ok {
+		data, err = c.Marshal(m)
+		return
+	}
+	var payload []byte
+	payload, err = tc.Marshal(m)
+	if err != nil {
+		return
+	}
+	var l [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(l[:], uint64(len(payload)))
+	data = make([]byte, 0, 2+n+len(payload))
+	data = append(data, 0, tc.id)
+	data = append(data, l[:n]...)
+	data = append(data, payload...)
+	return
+}
+
+// DecodeMessage reads a message encoded by EncodeMessage returning the codec it was encoded with
+func DecodeMessage(r io.Reader) (m Message, c Codec, err error) {
+	c = gobWireCodec
+	br := bufio.NewReader(r)
+	var b []byte
+	b, err = br.Peek(1)
+	if err != nil {
+		return
+	}
+	if b[0] != 0 {
+		err = gob.NewDecoder(br).Decode(&m)
+		return
+	}
+	br.ReadByte()
+	var id byte
+	id, err = br.ReadByte()
+	if err != nil {
+		return
+	}
+	var tc *treeCodec
+	tc, err = codecByID(id)
+	if err != nil {
+		return
+	}
+	c = tc
+	var l uint64
+	l, err = binary.ReadUvarint(br)
+	if err != nil {
+		return
+	}
+	if l > MaxWireMessageSize {
+		err = fmt.Errorf("message of %d bytes too large", l)
+		return
+	}
+	payload := make([]byte, l)
+	if _, err = io.ReadFull(br, payload); err != nil {
+		return
+	}
+	err = tc.Unmarshal(payload, &m)
+	return
+}
+
+// wireTypes maps the names of types that can be held in interfaces to their types
+var wireTypes = make(map[string]reflect.Type)
+
+// RegisterWireType registers a type that may be held in an interface field of a message body,
+// it also registers it with gob
+func RegisterWireType(value interface{}) {
+	gob.Register(value)
+	t := reflect.TypeOf(value)
+	wireTypes[t.String()] = t
+}
+
+func init() {
+	for _, v := range []interface{}{false, "", int(0), int64(0), uint64(0), float64(0), []byte{},
+		[]interface{}{}, map[string]interface{}{}} {
+		t := reflect.TypeOf(v)
+		wireTypes[t.String()] = t
+	}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// toWireTree converts a value into its wire tree
+func toWireTree(v reflect.Value) (tree interface{}, err error) {
+	if !v.IsValid() {
+		return
+	}
+	if v.Type() == timeType {
+		tree = v.Interface().(time.Time).Format(time.RFC3339Nano)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		e := v.Elem()
+		name := e.Type().String()
+		if _, ok := wireTypes[name]; !ok {
+			err = fmt.Errorf("type %s not registered for the wire", name)
+			return
+		}
+		var value interface{}
+		value, err = toWireTree(e)
+		if err != nil {
+			return
+		}
+		tree = map[interface{}]interface{}{wireTypeKey: name, wireValueKey: value}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			tree, err = toWireTree(v.Elem())
+		}
+	case reflect.Struct:
+		fields := make(map[interface{}]interface{})
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue // unexported
+			}
+			var value interface{}
+			value, err = toWireTree(v.Field(i))
+			if err != nil {
+				return
+			}
+			if value != nil {
+				fields[f.Name] = value
+			}
+		}
+		tree = fields
+	case reflect.Slice, reflect.Array:
+		// like gob, empty slices go over the wire as nil
+		if v.Len() == 0 {
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			tree = b
+			return
+		}
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i], err = toWireTree(v.Index(i))
+			if err != nil {
+				return
+			}
+		}
+		tree = list
+	case reflect.Map:
+		if v.Len() == 0 {
+			return
+		}
+		m := make(map[interface{}]interface{})
+		for _, k := range v.MapKeys() {
+			var key, value interface{}
+			if key, err = toWireTree(k); err != nil {
+				return
+			}
+			if _, ok := key.([]byte); ok {
+				err = fmt.Errorf("can't use %s as a wire map key", k.Type())
+				return
+			}
+			if value, err = toWireTree(v.MapIndex(k)); err != nil {
+				return
+			}
+			m[key] = value
+		}
+		tree = m
+	case reflect.Bool:
+		tree = v.Bool()
+	case reflect.String:
+		tree = v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		tree = v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		tree = v.Uint()
+	case reflect.Float32, reflect.Float64:
+		tree = v.Float()
+	default:
+		err = fmt.Errorf("can't send %s over the wire", v.Type())
+	}
+	return
+}
+
+// wireMap returns a wire tree value as a map
+func wireMap(tree interface{}) (m map[interface{}]interface{}, ok bool) {
+	switch t := tree.(type) {
+	case map[interface{}]interface{}:
+		m, ok = t, true
+	case map[string]interface{}:
+		m, ok = make(map[interface{}]interface{}, len(t)), true
+		for k, v := range t {
+			m[k] = v
+		}
+	}
+	return
+}
+
+// fromWireTree sets a value from its wire tree
+func fromWireTree(tree interface{}, v reflect.Value) (err error) {
+	if tree == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	mismatch := func() error {
+		return fmt.Errorf("can't decode wire value %T into %s", tree, v.Type())
+	}
+	if v.Type() == timeType {
+		s, ok := tree.(string)
+		if !ok {
+			return mismatch()
+		}
+		var t time.Time
+		t, err = time.Parse(time.RFC3339Nano, s)
+		if err == nil {
+			v.Set(reflect.ValueOf(t))
+		}
+		return
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		m, ok := wireMap(tree)
+		if !ok {
+			return mismatch()
+		}
+		name, _ := m[wireTypeKey].(string)
+		t, ok := wireTypes[name]
+		if !ok {
+			err = fmt.Errorf("type %s not registered for the wire", name)
+			return
+		}
+		e := reflect.New(t).Elem()
+		if err = fromWireTree(m[wireValueKey], e); err != nil {
+			return
+		}
+		v.Set(e)
+	case reflect.Ptr:
+		e := reflect.New(v.Type().Elem())
+		if err = fromWireTree(tree, e.Elem()); err != nil {
+			return
+		}
+		v.Set(e)
+	case reflect.Struct:
+		m, ok := wireMap(tree)
+		if !ok {
+			return mismatch()
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			// fields we don't know about are ignored so newer nodes can add them
+			if value, ok := m[f.Name]; ok {
+				if err = fromWireTree(value, v.Field(i)); err != nil {
+					return
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, ok := tree.([]byte)
+			if !ok {
+				return mismatch()
+			}
+			if v.Kind() == reflect.Array {
+				reflect.Copy(v, reflect.ValueOf(b))
+			} else {
+				v.Set(reflect.ValueOf(append([]byte{}, b...)).Convert(v.Type()))
+			}
+			return
+		}
+		list, ok := tree.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(v.Type(), len(list), len(list)))
+		} else if len(list) > v.Len() {
+			return mismatch()
+		}
+		for i, e := range list {
+			if err = fromWireTree(e, v.Index(i)); err != nil {
+				return
+			}
+		}
+	case reflect.Map:
+		m, ok := wireMap(tree)
+		if !ok {
+			return mismatch()
+		}
+		t := v.Type()
+		v.Set(reflect.MakeMap(t))
+		for key, value := range m {
+			k := reflect.New(t.Key()).Elem()
+			if err = fromWireTree(key, k); err != nil {
+				return
+			}
+			e := reflect.New(t.Elem()).Elem()
+			if err = fromWireTree(value, e); err != nil {
+				return
+			}
+			v.SetMapIndex(k, e)
+		}
+	case reflect.Bool:
+		b, ok := tree.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case reflect.String:
+		switch s := tree.(type) {
+		case string:
+			v.SetString(s)
+		case []byte:
+			v.SetString(string(s))
+		default:
+			return mismatch()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch n := tree.(type) {
+		case int64:
+			i = n
+		case uint64:
+			i = int64(n)
+			if i < 0 {
+				return mismatch()
+			}
+		default:
+			return mismatch()
+		}
+		if v.OverflowInt(i) {
+			return mismatch()
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		switch n := tree.(type) {
+		case uint64:
+			u = n
+		case int64:
+			if n < 0 {
+				return mismatch()
+			}
+			u = uint64(n)
+		default:
+			return mismatch()
+		}
+		if v.OverflowUint(u) {
+			return mismatch()
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch n := tree.(type) {
+		case float64:
+			v.SetFloat(n)
+		case float32:
+			v.SetFloat(float64(n))
+		default:
+			return mismatch()
+		}
+	default:
+		err = fmt.Errorf("can't receive %s over the wire", v.Type())
+	}
+	return
+}
+
+// wireProtocolNames maps the protocol names used in codec settings to the protocols
+var wireProtocolNames = map[string]int{
+	"action":   ActionProtocol,
+	"validate": ValidateProtocol,
+	"gossip":   GossipProtocol,
+	"kademlia": KademliaProtocol,
+}
+
+// ConfigureCodecs sets the node's codec preferences from settings of comma separated
+// codec names keyed by protocol name
+func (node *Node) ConfigureCodecs(settings map[string]string) (err error) {
+	for name, codecs := range settings {
+		proto, ok := wireProtocolNames[name]
+		if !ok {
+			err = fmt.Errorf("unknown protocol in codec settings: %s", name)
+			return
+		}
+		names := strings.Split(codecs, ",")
+		for i := range names {
+			names[i] = strings.TrimSpace(names[i])
+		}
+		if err = node.SetCodecs(proto, names); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ParseWireCodecs parses a codec setting of the form "gossip=cbor,gob;action=protobuf"
+// into preference lists of codecs keyed by protocol name
+func ParseWireCodecs(s string) (codecs map[string]string, err error) {
+	codecs = make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			err = fmt.Errorf("bad wire codec setting: %s", part)
+			return
+		}
+		codecs[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return
+}
@@ -0,0 +1,284 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// protobuf encoding of message wire trees with the schema:
+//
+//   message Value {
+//     oneof kind {
+//       bool null = 1;
+//       bool bool = 2;
+//       sint64 int = 3;
+//       uint64 uint = 4;
+//       double float = 5;
+//       string string = 6;
+//       bytes bytes = 7;
+//       List list = 8;
+//       Map map = 9;
+//     }
+//   }
+//   message List { repeated Value values = 1; }
+//   message Map { repeated Entry entries = 1; }
+//   message Entry { Value key = 1; Value value = 2; }
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+
+	pbNull   = 1
+	pbBool   = 2
+	pbInt    = 3
+	pbUint   = 4
+	pbFloat  = 5
+	pbString = 6
+	pbBytesF = 7
+	pbList   = 8
+	pbMap    = 9
+)
+
+var errPBTruncated = errors.New("truncated protobuf")
+
+func pbAppendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+func pbAppendKey(b []byte, field int, wireType int) []byte {
+	return pbAppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func pbAppendBytes(b []byte, field int, data []byte) []byte {
+	b = pbAppendKey(b, field, pbBytes)
+	b = pbAppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// pbAppendValue appends the encoding of a wire tree Value message
+func pbAppendValue(b []byte, tree interface{}) (result []byte, err error) {
+	switch t := tree.(type) {
+	case nil:
+		b = pbAppendKey(b, pbNull, pbVarint)
+		b = pbAppendUvarint(b, 1)
+	case bool:
+		b = pbAppendKey(b, pbBool, pbVarint)
+		if t {
+			b = pbAppendUvarint(b, 1)
+		} else {
+			b = pbAppendUvarint(b, 0)
+		}
+	case int64:
+		b = pbAppendKey(b, pbInt, pbVarint)
+		b = pbAppendUvarint(b, uint64((t<<1)^(t>>63)))
+	case uint64:
+		b = pbAppendKey(b, pbUint, pbVarint)
+		b = pbAppendUvarint(b, t)
+	case float64:
+		b = pbAppendKey(b, pbFloat, pbFixed64)
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(t))
+		b = append(b, buf[:]...)
+	case string:
+		b = pbAppendBytes(b, pbString, []byte(t))
+	case []byte:
+		b = pbAppendBytes(b, pbBytesF, t)
+	case []interface{}:
+		var list []byte
+		for _, e := range t {
+			var value []byte
+			if value, err = pbAppendValue(nil, e); err != nil {
+				return
+			}
+			list = pbAppendBytes(list, 1, value)
+		}
+		b = pbAppendBytes(b, pbList, list)
+	case map[interface{}]interface{}:
+		// entries are sorted by their encoded keys so encoding is deterministic
+		entries := make([][]byte, 0, len(t))
+		for k, v := range t {
+			var key, value []byte
+			if key, err = pbAppendValue(nil, k); err != nil {
+				return
+			}
+			if value, err = pbAppendValue(nil, v); err != nil {
+				return
+			}
+			entry := pbAppendBytes(nil, 1, key)
+			entries = append(entries, pbAppendBytes(entry, 2, value))
+		}
+		sort.Slice(entries, func(i, j int) bool { return bytes.Compare(entries[i], entries[j]) < 0 })
+		var m []byte
+		for _, e := range entries {
+			m = pbAppendBytes(m, 1, e)
+		}
+		b = pbAppendBytes(b, pbMap, m)
+	default:
+		err = fmt.Errorf("can't protobuf encode %T", tree)
+		return
+	}
+	result = b
+	return
+}
+
+// pbReader reads protobuf fields from a buffer
+type pbReader struct {
+	data []byte
+}
+
+func (r *pbReader) uvarint() (v uint64, err error) {
+	v, n := binary.Uvarint(r.data)
+	if n <= 0 {
+		err = errPBTruncated
+		return
+	}
+	r.data = r.data[n:]
+	return
+}
+
+// next reads the next field returning its number and, for varints and fixed64s, its value,
+// or for length delimited fields its bytes
+func (r *pbReader) next() (field int, v uint64, data []byte, err error) {
+	var key uint64
+	if key, err = r.uvarint(); err != nil {
+		return
+	}
+	field = int(key >> 3)
+	switch key & 7 {
+	case pbVarint:
+		v, err = r.uvarint()
+	case pbFixed64:
+		if len(r.data) < 8 {
+			err = errPBTruncated
+			return
+		}
+		v = binary.LittleEndian.Uint64(r.data)
+		r.data = r.data[8:]
+	case pbBytes:
+		var l uint64
+		if l, err = r.uvarint(); err != nil {
+			return
+		}
+		if uint64(len(r.data)) < l {
+			err = errPBTruncated
+			return
+		}
+		data = r.data[:l]
+		r.data = r.data[l:]
+	default:
+		err = fmt.Errorf("unsupported protobuf wire type %d", key&7)
+	}
+	return
+}
+
+// pbRepeated returns the data of each occurrence of a length delimited field
+func pbRepeated(data []byte, field int) (items [][]byte, err error) {
+	r := pbReader{data}
+	for len(r.data) > 0 {
+		var f int
+		var d []byte
+		if f, _, d, err = r.next(); err != nil {
+			return
+		}
+		if f == field {
+			items = append(items, d)
+		}
+	}
+	return
+}
+
+// pbDecodeValue decodes a wire tree Value message
+func pbDecodeValue(data []byte) (tree interface{}, err error) {
+	r := pbReader{data}
+	for len(r.data) > 0 {
+		var field int
+		var v uint64
+		var d []byte
+		if field, v, d, err = r.next(); err != nil {
+			return
+		}
+		switch field {
+		case pbNull:
+			tree = nil
+		case pbBool:
+			tree = v != 0
+		case pbInt:
+			tree = int64(v>>1) ^ -int64(v&1)
+		case pbUint:
+			tree = v
+		case pbFloat:
+			tree = math.Float64frombits(v)
+		case pbString:
+			tree = string(d)
+		case pbBytesF:
+			tree = append([]byte{}, d...)
+		case pbList:
+			var items [][]byte
+			if items, err = pbRepeated(d, 1); err != nil {
+				return
+			}
+			list := make([]interface{}, len(items))
+			for i, item := range items {
+				if list[i], err = pbDecodeValue(item); err != nil {
+					return
+				}
+			}
+			tree = list
+		case pbMap:
+			var entries [][]byte
+			if entries, err = pbRepeated(d, 1); err != nil {
+				return
+			}
+			m := make(map[interface{}]interface{}, len(entries))
+			for _, entry := range entries {
+				var key, value interface{}
+				er := pbReader{entry}
+				for len(er.data) > 0 {
+					var f int
+					var ed []byte
+					if f, _, ed, err = er.next(); err != nil {
+						return
+					}
+					switch f {
+					case 1:
+						key, err = pbDecodeValue(ed)
+					case 2:
+						value, err = pbDecodeValue(ed)
+					}
+					if err != nil {
+						return
+					}
+				}
+				switch key.(type) {
+				case []byte, []interface{}, map[interface{}]interface{}:
+					err = fmt.Errorf("can't use %T as a map key", key)
+					return
+				}
+				m[key] = value
+			}
+			tree = m
+		}
+	}
+	return
+}
+
+func pbMarshal(tree interface{}) (data []byte, err error) {
+	data, err = pbAppendValue(nil, tree)
+	return
+}
+
+func pbUnmarshal(data []byte) (tree interface{}, err error) {
+	tree, err = pbDecodeValue(data)
+	return
+}
@@ -0,0 +1,128 @@
+package holochain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/metacurrency/holochain/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestCodecs(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	put := h.node.NewMessage(PUT_REQUEST, PutReq{H: hash, D: "some data"})
+	m := h.node.NewMessage(OK_RESPONSE, Gossip{Puts: []Put{{Idx: 1, M: *put}, {Idx: 2, M: *h.node.NewMessage(LINK_REQUEST, LinkReq{Base: hash, Links: hash})}}})
+	f, _ := m.Fingerprint()
+
+	Convey("it should fail to get an unknown codec", t, func() {
+		_, err := GetCodec("foo")
+		So(err, ShouldEqual, ErrUnknownCodec)
+	})
+
+	for _, name := range []string{GobCodec, CBORCodec, ProtobufCodec} {
+		c, err := GetCodec(name)
+		if err != nil {
+			panic(err)
+		}
+		Convey(fmt.Sprintf("%s codec should round trip messages", name), t, func() {
+			data, err := EncodeMessage(m, c)
+			So(err, ShouldBeNil)
+			if name == GobCodec {
+				So(data[0], ShouldNotEqual, 0)
+			} else {
+				So(data[0], ShouldEqual, 0)
+			}
+
+			m2, used, err := DecodeMessage(bytes.NewReader(data))
+			So(err, ShouldBeNil)
+			So(used.Name(), ShouldEqual, name)
+			So(m2.Type, ShouldEqual, OK_RESPONSE)
+			So(m2.From, ShouldEqual, h.nodeID)
+			So(m2.Time.Equal(m.Time), ShouldBeTrue)
+			g := m2.Body.(Gossip)
+			So(len(g.Puts), ShouldEqual, 2)
+			So(g.Puts[0].M.Body.(PutReq).D, ShouldEqual, "some data")
+			So(g.Puts[1].M.Body.(LinkReq).Base.String(), ShouldEqual, hash.String())
+
+			// fingerprints must survive the trip for gossip to work
+			f2, err := m2.Fingerprint()
+			So(err, ShouldBeNil)
+			So(f2.String(), ShouldEqual, f.String())
+			pf, _ := put.Fingerprint()
+			f2, _ = g.Puts[0].M.Fingerprint()
+			So(f2.String(), ShouldEqual, pf.String())
+		})
+	}
+
+	Convey("tree codecs should round trip plain values", t, func() {
+		tree := map[interface{}]interface{}{
+			"neg":   int64(-12345678901),
+			"pos":   uint64(1 << 63),
+			"float": 3.25,
+			"bool":  true,
+			"list":  []interface{}{"a", []byte{1, 2}, nil},
+		}
+		data, err := pbMarshal(tree)
+		So(err, ShouldBeNil)
+		tree2, err := pbUnmarshal(data)
+		So(err, ShouldBeNil)
+		So(tree2, ShouldResemble, tree)
+	})
+
+	Convey("it should fail to encode unregistered types", t, func() {
+		type unregistered struct{ X int }
+		c, _ := GetCodec(CBORCodec)
+		_, err := EncodeMessage(h.node.NewMessage(APP_MESSAGE, unregistered{}), c)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("it should parse codec settings", t, func() {
+		settings, err := ParseWireCodecs("gossip=cbor,gob; action=protobuf")
+		So(err, ShouldBeNil)
+		So(settings, ShouldResemble, map[string]string{"gossip": "cbor,gob", "action": "protobuf"})
+		_, err = ParseWireCodecs("gossip")
+		So(err, ShouldNotBeNil)
+		So(h.node.ConfigureCodecs(map[string]string{"fish": "gob"}).Error(), ShouldEqual, "unknown protocol in codec settings: fish")
+		So(h.node.ConfigureCodecs(map[string]string{"gossip": "gob,foo"}).Error(), ShouldEqual, "unknown codec: foo")
+	})
+}
+
+func TestCodecNegotiation(t *testing.T) {
+	nodesCount := 3
+	mt := setupSimMultiNodeTesting(nodesCount, 42)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	h0.node.ConfigureCodecs(map[string]string{"gossip": "cbor,gob"})
+	h1.node.ConfigureCodecs(map[string]string{"gossip": "protobuf,cbor"})
+
+	Convey("nodes should use the first of their codecs their peer also uses", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		r, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+		So(fmt.Sprintf("%T", r.Body), ShouldEqual, "holochain.Gossip")
+
+		p, _ := h0.node.peerProtocol(h1.nodeID)
+		So(h0.node.peerCodec(GossipProtocol, p).Name(), ShouldEqual, CBORCodec)
+		So(h0.node.peerCodec(ActionProtocol, p).Name(), ShouldEqual, GobCodec)
+		p, _ = h1.node.peerProtocol(h0.nodeID)
+		So(h1.node.peerCodec(GossipProtocol, p).Name(), ShouldEqual, CBORCodec)
+	})
+
+	Convey("nodes should fall back to gob", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := h0.node.Send(context.Background(), GossipProtocol, h2.nodeID, m)
+		So(err, ShouldBeNil)
+		p, _ := h0.node.peerProtocol(h2.nodeID)
+		So(h0.node.peerCodec(GossipProtocol, p).Name(), ShouldEqual, GobCodec)
+	})
+}
@@ -14,11 +14,11 @@ import (
 	ic "github.com/libp2p/go-libp2p-crypto"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/metacurrency/holochain/hash"
-	"github.com/tidwall/buntdb"
-	"path/filepath"
-	"strconv"
+	queue "github.com/metacurrency/holochain/peerqueue"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Holds the dht configuration options
@@ -31,7 +31,11 @@ type DHTConfig struct {
 
 	// ShardingMethod : Identifier for sharding method (none, XOR, hashmask, other nearness algorithms?, etc.)
 
-	// MaxLinkSets : (integer) Maximum number of results to return on a GetLinks query to keep computation and traffic to a reasonable size. You need to break these result sets into multiple "pages" of results retrieve more.
+	// TombstoneRetention : (integer) Time period in seconds that the DHT holds on to deleted and modified entries and deleted links before purging them. ZERO means they are kept for ever.
+	TombstoneRetention int
+
+	// MaxLinkSets : (integer) Maximum number of results to return on a GetLinks query to keep computation and traffic to a reasonable size. You need to break these result sets into multiple "pages" of results retrieve more. ZERO means no maximum.
+	MaxLinkSets int
 
 	// ValidationTimeout : (integer) Time period in seconds, until data that needs to be validated against a source remains "alive" to keep trying to get validation from that source. If someone commits something and then goes offline, how long do they have to come back online before DHT sync requests consider that data invalid?
 
@@ -41,7 +45,8 @@ type DHTConfig struct {
 
 	// DataEncryption : What are the options for encrypting data at rest in the dht.db that don't break db functionality? Is there really a point to trying to do this?
 
-	// MaxEntrySize : Sets the maximum allowable size of entries for this holochain
+	// MaxEntrySize : (integer) Sets the maximum allowable size in bytes of entries for this holochain. ZERO means no maximum.
+	MaxEntrySize int
 }
 
 type gossipWithReq struct {
@@ -51,27 +56,45 @@ type gossipWithReq struct {
 // DHT struct holds the data necessary to run the distributed hash table
 type DHT struct {
 	h          *Holochain // pointer to the holochain this DHT is part of
-	db         *buntdb.DB
-	retryQueue chan *retry
+	db         DHTStore
 	gossipPuts chan Put
 	glog       *Logger // the gossip logger
 	dlog       *Logger // the dht logger
 	gchan      chan gossipWithReq
 	config     *DHTConfig
 	glk        sync.RWMutex
+	gstats     map[peer.ID]queue.GossipStats // outcomes of gossiping used to pick gossip partners
+	gslk       sync.RWMutex
+	stored     int64 // bytes of values in the store, -1 when it needs counting
+	slk        sync.Mutex
+	subs       map[int]*subscription // subscriptions to changes in the store
+	nextSub    int
+	sublk      sync.RWMutex
+	gcTimes    *changeTimes // the changes in the put index read by garbage collection
+	gclk       sync.Mutex
+	ignored    map[string]time.Time // fingerprints range gossip offered that we don't hold
+	iglk       sync.Mutex
 	//	sources      map[peer.ID]bool
 	//	fingerprints map[string]bool
 }
 
-type retry struct {
-	msg     Message
-	retries int
+// Retry is a DHT change waiting to be tried again because the entry it depends on hasn't
+// arrived yet.  Retries are kept in the DHTStore so they survive restarts.
+type Retry struct {
+	Msg     Message
+	Retries int       // how many more times the change will be tried
+	Next    time.Time // when the change is next due to be tried
 }
 
 const (
 	MaxRetries = 10
 )
 
+// RetryBackoff is how long to wait before first retrying a change, the wait doubles with each
+// retry up to MaxRetryBackoff
+var RetryBackoff = DefaultRetryInterval
+var MaxRetryBackoff = 5 * time.Minute
+
 // Meta holds data that can be associated with a hash
 // @todo, we should also be storing the meta-data source
 type Meta struct {
@@ -127,9 +150,12 @@ const (
 
 // PutReq holds the data of a put request
 type PutReq struct {
-	H Hash
-	S int
-	D interface{}
+	H         Hash
+	S         int
+	D         interface{}
+	Header    *Header // of the entry being put, so holders can spot authors forking their chains
+	Overflow  bool    // sent to a spare outside the neighborhood because a holder was full
+	Successor bool    // only announces Header to the neighborhood of the header it follows
 }
 
 // GetReq holds the data of a get request
@@ -147,6 +173,26 @@ type GetResp struct {
 	FollowHash string // hash of new entry if the entry was modified and needs following
 }
 
+// GetManyReq holds the get requests of a get many request
+type GetManyReq struct {
+	Reqs []GetReq
+}
+
+// GetManyResp holds the responses to a get many request, with the error for each request
+// that failed in Errs
+type GetManyResp struct {
+	Resps []GetResp
+	Errs  []ErrorResponse
+}
+
+// itemErr returns the error the i'th request failed with, nil if it didn't
+func (r *GetManyResp) itemErr(i int) error {
+	if e := r.Errs[i]; e.Code != ErrUnknownCode || e.Message != "" {
+		return e.DecodeResponseError()
+	}
+	return nil
+}
+
 // DelReq holds the data of a del request
 type DelReq struct {
 	H  Hash // hash to be deleted
@@ -170,10 +216,32 @@ type LinkQuery struct {
 	Base       Hash
 	T          string
 	StatusMask int
+	Limit      int      // maximum number of links to return, 0 for as many as the DHT allows
+	Cursor     string   // return links after this cursor, from the Next of a previous LinkQueryResp
+	TagPrefix  string   // if T is empty, return the links whose tags start with this
+	Tags       []string // if T is empty, return the links with any of these tags
 	// order
 	// filter, etc
 }
 
+// tagQuery returns true if the query is for the links matching a tag prefix or set of tags
+func (q *LinkQuery) tagQuery() bool {
+	return q.T == "" && (q.TagPrefix != "" || len(q.Tags) > 0)
+}
+
+// matchTag returns true if a tag matches the query's tag prefix or set of tags
+func (q *LinkQuery) matchTag(tag string) bool {
+	if q.TagPrefix != "" && strings.HasPrefix(tag, q.TagPrefix) {
+		return true
+	}
+	for _, t := range q.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // GetOptions options to holochain level Get functions
 type GetOptions struct {
 	StatusMask int  // mask of which status of entries to return
@@ -183,8 +251,17 @@ type GetOptions struct {
 
 // GetLinksOptions options to holochain level GetLinks functions
 type GetLinksOptions struct {
-	Load       bool // indicates whether GetLinks should retrieve the entries of all links
-	StatusMask int  // mask of which status of links to return
+	Load       bool   // indicates whether GetLinks should retrieve the entries of all links
+	StatusMask int    // mask of which status of links to return
+	PageSize   int    // maximum number of links to return in one page
+	Cursor     string // the cursor returned with the previous page
+}
+
+// QueryLinksOptions options to holochain level QueryLinks functions
+type QueryLinksOptions struct {
+	GetLinksOptions
+	TagPrefix string   // return the links whose tags start with this
+	Tags      []string // return the links with any of these tags
 }
 
 // TaggedHash holds associated entries for the LinkQueryResponse
@@ -199,6 +276,7 @@ type TaggedHash struct {
 // LinkQueryResp holds response to getLinks query
 type LinkQueryResp struct {
 	Links []TaggedHash
+	Next  string // cursor for the next page of links, empty if there are no more
 }
 
 type ListAddReq struct {
@@ -223,10 +301,16 @@ var ErrHashDeleted = errors.New("hash deleted")
 var ErrHashModified = errors.New("hash modified")
 var ErrHashRejected = errors.New("hash rejected")
 var ErrEntryTypeMismatch = errors.New("entry type mismatch")
+var ErrEntryTooLarge = errors.New("entry too large")
+var ErrStorageQuota = errors.New("storage quota exceeded")
+var ErrGetManyTooLarge = errors.New("get many request has too many gets")
 
 var KValue int = 10
 var AlphaValue int = 3
 
+// MaxGetManyBatch is the most gets a get many request may hold
+var MaxGetManyBatch int = 100
+
 const (
 	GossipWithQueueSize = 10
 	GossipPutQueueSize  = 1000
@@ -240,23 +324,23 @@ func NewDHT(h *Holochain) *DHT {
 		dlog:   &h.Config.Loggers.DHT,
 		config: &h.Nucleus().DNA().DHTConfig,
 	}
-	db, err := buntdb.Open(filepath.Join(h.DBPath(), DHTStoreFileName))
+	db, err := NewDHTStore(h.Config.DHTStoreType, h.DBPath())
 	if err != nil {
 		panic(err)
 	}
-	db.CreateIndex("link", "link:*", buntdb.IndexString)
-	db.CreateIndex("idx", "idx:*", buntdb.IndexInt)
-	db.CreateIndex("peer", "peer:*", buntdb.IndexString)
-	db.CreateIndex("list", "list:*", buntdb.IndexString)
-	db.CreateIndex("entry", "entry:*", buntdb.IndexString)
+	if h.restKey != nil {
+		db = &restStore{DHTStore: db, key: h.restKey}
+	}
 
 	dht.db = db
-	dht.retryQueue = make(chan *retry, 100)
 
 	//	dht.sources = make(map[peer.ID]bool)
 	//	dht.fingerprints = make(map[string]bool)
 	dht.gchan = make(chan gossipWithReq, GossipWithQueueSize)
 	dht.gossipPuts = make(chan Put, GossipPutQueueSize)
+	dht.gstats = make(map[peer.ID]queue.GossipStats)
+	dht.ignored = make(map[string]time.Time)
+	dht.stored = -1
 
 	return &dht
 }
@@ -328,153 +412,120 @@ func (dht *DHT) SetupDHT() (err error) {
 // put stores a value to the DHT store
 // N.B. This call assumes that the value has already been validated
 func (dht *DHT) put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
-	k := key.String()
-	dht.dlog.Logf("put %s=>%s", k, string(value))
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		_, err := incIdx(tx, m)
-		if err != nil {
-			return err
-		}
-		_, _, err = tx.Set("entry:"+k, string(value), nil)
-		if err != nil {
-			return err
-		}
-		_, _, err = tx.Set("type:"+k, entryType, nil)
-		if err != nil {
-			return err
-		}
-		_, _, err = tx.Set("src:"+k, peer.IDB58Encode(src), nil)
-		if err != nil {
-			return err
-		}
-		_, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", status), nil)
-		if err != nil {
-			return err
-		}
-		return err
-	})
+	dht.dlog.Logf("put %s=>%s", key.String(), string(value))
+	if err = dht.checkEntrySize(len(value)); err != nil {
+		return
+	}
+	// a value put again replaces the one stored so only the difference counts
+	size := int64(len(value))
+	if old, _, _, _, e := dht.db.Get(key, StatusAny, GetMaskEntry); e == nil {
+		size -= int64(len(old))
+	}
+	err = dht.db.Put(m, entryType, key, src, value, status)
+	if err == nil {
+		dht.addStored(size)
+		dht.notify(DHTEvent{
+			Type:      DHTEventPut,
+			Hash:      key.String(),
+			EntryType: entryType,
+			LinkEvent: LinkEvent{Status: status, Source: peer.IDB58Encode(src)},
+		})
+	}
 	return
 }
 
-func _setStatus(tx *buntdb.Tx, m *Message, key string, status int) (err error) {
+// checkEntrySize returns ErrEntryTooLarge if an entry is bigger than the DNA allows
+func (dht *DHT) checkEntrySize(size int) (err error) {
+	if dht.config.MaxEntrySize > 0 && size > dht.config.MaxEntrySize {
+		err = ErrEntryTooLarge
+	}
+	return
+}
 
-	_, err = tx.Get("entry:" + key)
-	if err != nil {
-		if err == buntdb.ErrNotFound {
-			err = ErrHashNotFound
+// storedSize returns the number of bytes of values in the store
+func (dht *DHT) storedSize() (size int64, err error) {
+	dht.slk.Lock()
+	defer dht.slk.Unlock()
+	if dht.stored < 0 {
+		var total int64
+		err = dht.db.Iterate(func(r DHTRecord) bool {
+			total += int64(len(r.Value))
+			return true
+		})
+		if err != nil {
+			return
 		}
-		return
+		dht.stored = total
 	}
+	size = dht.stored
+	return
+}
 
-	_, err = incIdx(tx, m)
-	if err != nil {
-		return
+// addStored adds to the count of bytes in the store if it has been counted
+func (dht *DHT) addStored(size int64) {
+	dht.slk.Lock()
+	defer dht.slk.Unlock()
+	if dht.stored >= 0 {
+		dht.stored += size
 	}
+}
 
-	_, _, err = tx.Set("status:"+key, fmt.Sprintf("%d", status), nil)
-	if err != nil {
+// recountStored has the bytes in the store counted again the next time they're needed
+func (dht *DHT) recountStored() {
+	dht.slk.Lock()
+	defer dht.slk.Unlock()
+	dht.stored = -1
+}
+
+// checkQuota returns ErrStorageQuota if the node can't take on holding a new value because
+// the store has reached the quota in the Config
+func (dht *DHT) checkQuota(key Hash) (err error) {
+	quota := dht.h.Config.StorageQuota
+	if quota <= 0 {
+		return
+	}
+	if dht.exists(key, StatusAny) != ErrHashNotFound {
 		return
 	}
+	var size int64
+	size, err = dht.storedSize()
+	if err == nil && size >= quota {
+		err = ErrStorageQuota
+	}
 	return
 }
 
 // del moves the given hash to the StatusDeleted status
 // N.B. this functions assumes that the validity of this action has been confirmed
 func (dht *DHT) del(m *Message, key Hash) (err error) {
-	k := key.String()
-	dht.dlog.Logf("del %s", k)
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		err = _setStatus(tx, m, k, StatusDeleted)
-		return err
-	})
+	dht.dlog.Logf("del %s", key.String())
+	err = dht.db.SetStatus(m, key, StatusDeleted)
+	if err == nil {
+		dht.notifyChange(DHTEventDel, key, "", m.From, StatusDeleted)
+	}
 	return
 }
 
 // mod moves the given hash to the StatusModified status
 // N.B. this functions assumes that the validity of this action has been confirmed
 func (dht *DHT) mod(m *Message, key Hash, newkey Hash) (err error) {
-	k := key.String()
-	dht.dlog.Logf("mod %s", k)
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		err = _setStatus(tx, m, k, StatusModified)
-		if err == nil {
-			link := newkey.String()
-			err = _link(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newkey)
-			if err == nil {
-				_, _, err = tx.Set("replacedBy:"+k, link, nil)
-				if err != nil {
-					return err
-				}
-			}
-		}
-		return err
-	})
-	return
-}
-
-func _get(tx *buntdb.Tx, k string, statusMask int) (string, error) {
-	val, err := tx.Get("entry:" + k)
-	if err == buntdb.ErrNotFound {
-		err = ErrHashNotFound
-		return val, err
-	}
-	var statusVal string
-	statusVal, err = tx.Get("status:" + k)
+	dht.dlog.Logf("mod %s", key.String())
+	err = dht.db.Mod(m, key, newkey)
 	if err == nil {
-
-		if statusMask == StatusDefault {
-			// if the status mask is not given (i.e. Default) then
-			// we return information about the status if it's other than live
-			switch statusVal {
-			case StatusDeletedVal:
-				err = ErrHashDeleted
-			case StatusModifiedVal:
-				val, err = tx.Get("replacedBy:" + k)
-				if err != nil {
-					panic("missing expected replacedBy record")
-				}
-				err = ErrHashModified
-			case StatusRejectedVal:
-				err = ErrHashRejected
-			case StatusLiveVal:
-			default:
-				panic("unknown status!")
-			}
-		} else {
-			// otherwise we return the value only if the status is in the mask
-			var status int
-			status, err = strconv.Atoi(statusVal)
-			if err == nil {
-				if (status & statusMask) == 0 {
-					err = ErrHashNotFound
-				}
-			}
-		}
+		dht.notifyChange(DHTEventMod, key, newkey.String(), m.From, StatusModified)
 	}
-	return val, err
+	return
 }
 
 // exists checks for the existence of the hash in the store
 func (dht *DHT) exists(key Hash, statusMask int) (err error) {
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		_, err := _get(tx, key.String(), statusMask)
-		return err
-	})
+	err = dht.db.Exists(key, statusMask)
 	return
 }
 
 // returns the source of a given hash
 func (dht *DHT) source(key Hash) (id peer.ID, err error) {
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		val, err := tx.Get("src:" + key.String())
-		if err == buntdb.ErrNotFound {
-			err = ErrHashNotFound
-		}
-		if err == nil {
-			id, err = peer.IDB58Decode(val)
-		}
-		return err
-	})
+	id, err = dht.db.Source(key)
 	return
 }
 
@@ -483,121 +534,25 @@ func (dht *DHT) get(key Hash, statusMask int, getMask int) (data []byte, entryTy
 	if getMask == GetMaskDefault {
 		getMask = GetMaskEntry
 	}
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		k := key.String()
-		val, err := _get(tx, k, statusMask)
-		if err != nil {
-			data = []byte(val) // gotta do this because value is valid if ErrHashModified
-			return err
-		}
-		data = []byte(val)
-
-		if (getMask & GetMaskEntryType) != 0 {
-			entryType, err = tx.Get("type:" + k)
-			if err != nil {
-				return err
-			}
-		}
-		if (getMask & GetMaskSources) != 0 {
-			val, err = tx.Get("src:" + k)
-			if err == buntdb.ErrNotFound {
-				err = ErrHashNotFound
-			}
-			if err == nil {
-				sources = append(sources, val)
-			}
-			if err != nil {
-				return err
-			}
-		}
-
-		val, err = tx.Get("status:" + k)
-		if err != nil {
-			return err
-		}
-		status, err = strconv.Atoi(val)
-		if err != nil {
-			return err
-		}
-
-		return err
-	})
+	data, entryType, sources, status, err = dht.db.Get(key, statusMask, getMask)
 	return
 }
 
-// _link is a low level routine to add a link, also used by delLink
-// this ensure monotonic recording of linking attempts
-func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
-	key := "link:" + base + ":" + link + ":" + tag
-	var val string
-	val, err = tx.Get(key)
-	source := peer.IDB58Encode(src)
-	lehStr := linkingEntryHash.String()
-	var records []LinkEvent
+func (dht *DHT) link(m *Message, base string, link string, tag string, status int) (err error) {
+	linksEntry := m.Body.(LinkReq).Links
+	err = dht.db.Link(m, base, link, tag, m.From, status, linksEntry)
 	if err == nil {
-		// load the previous value so we can append to it.
-		json.Unmarshal([]byte(val), &records)
-
-		// TODO: if the link exists, then load the statuses and see
-		// what we should do about this situation
-		/*
-			// search for the source and linking entry in the status
-			for _, s := range records {
-				if s.Source == source && s.LinksEntry == lehStr {
-					if status == StatusLive && s.Status != status {
-						err = ErrPutLinkOverDeleted
-						return
-					}
-					// return silently because this is just a duplicate putLink
-					break
-				}
-			} // fall through and add this linking event.
-		*/
-
-	} else if err == buntdb.ErrNotFound {
-		// when deleting the key must exist
-		if status == StatusDeleted {
-			err = ErrLinkNotFound
-			return
-		}
-		err = nil
-	} else {
-		return
-	}
-	records = append(records, LinkEvent{status, source, lehStr})
-	var b []byte
-	b, err = json.Marshal(records)
-	if err != nil {
-		return
-	}
-	_, _, err = tx.Set(key, string(b), nil)
-	if err != nil {
-		return
+		dht.notify(DHTEvent{
+			Type:      DHTEventLink,
+			Hash:      base,
+			Link:      link,
+			Tag:       tag,
+			LinkEvent: LinkEvent{Status: status, Source: peer.IDB58Encode(m.From), LinksEntry: linksEntry.String()},
+		})
 	}
 	return
 }
 
-func (dht *DHT) link(m *Message, base string, link string, tag string, status int) (err error) {
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		_, err := _get(tx, base, StatusLive)
-		if err != nil {
-			return err
-		}
-		err = _link(tx, base, link, tag, m.From, status, m.Body.(LinkReq).Links)
-		if err != nil {
-			return err
-		}
-
-		//var index string
-		_, err = incIdx(tx, m)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	return
-}
-
 // putLink associates a link with a stored hash
 // N.B. this function assumes that the data associated has been properly retrieved
 // and validated from the cource chain
@@ -627,61 +582,121 @@ func filter(ss []Meta, test func(*Meta) bool) (ret []Meta) {
 // getLinks retrieves meta value associated with a base
 func (dht *DHT) getLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
 	dht.dlog.Logf("getLinks on %v of %s with mask %d", base, tag, statusMask)
-	b := base.String()
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		_, err := _get(tx, b, StatusLive+StatusModified) //only get links on live and modified bases
-		if err != nil {
-			return err
-		}
+	results, err = dht.db.GetLinks(base, tag, statusMask)
+	return
+}
 
-		if statusMask == StatusDefault {
-			statusMask = StatusLive
-		}
+// linkCursor returns the cursor that marks the position of a link in the results of a getLinks
+func linkCursor(th *TaggedHash, tag string) string {
+	if tag == "" {
+		tag = th.T
+	}
+	return th.H + ":" + tag
+}
 
-		results = make([]TaggedHash, 0)
-		err = tx.Ascend("link", func(key, value string) bool {
-			x := strings.Split(key, ":")
-			t := string(x[3])
-			if string(x[1]) == b && (tag == "" || tag == t) {
-				var records []LinkEvent
-				json.Unmarshal([]byte(value), &records)
-				l := len(records)
-				//TODO: this is totally bogus currently simply
-				// looking at the last item we ever got
-				if l > 0 {
-					entry := records[l-1]
-					if err == nil && (entry.Status&statusMask) > 0 {
-						th := TaggedHash{H: string(x[2]), Source: entry.Source}
-						if tag == "" {
-							th.T = t
-						}
-						results = append(results, th)
-					}
-				}
-			}
+// warnLinksCut logs that MaxLinkSets cut short the links returned to a caller that didn't
+// ask for paging, and so has no cursor to get the rest with
+func warnLinksCut(lqr *LinkQueryResp, paging bool) {
+	if !paging && lqr.Next != "" {
+		Infof("Warning: only the first %d links were returned because of MaxLinkSets, page the query to get the rest", len(lqr.Links))
+	}
+}
 
-			return true
-		})
+// pageLinks returns the links after the cursor, at most limit of them and never more than
+// MaxLinkSets, along with the cursor of the next page which is empty if there are no more.
+func (dht *DHT) pageLinks(links []TaggedHash, tag string, cursor string, limit int) (page []TaggedHash, next string) {
+	max := dht.config.MaxLinkSets
+	if max > 0 && (limit <= 0 || limit > max) {
+		limit = max
+	}
+	if limit <= 0 && cursor == "" {
+		page = links
+		return
+	}
+	// stores don't all return links in the same order so sort them by cursor
+	sort.Slice(links, func(i, j int) bool { return linkCursor(&links[i], tag) < linkCursor(&links[j], tag) })
+	start := 0
+	if cursor != "" {
+		start = sort.Search(len(links), func(i int) bool { return linkCursor(&links[i], tag) > cursor })
+	}
+	end := len(links)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+		next = linkCursor(&links[end-1], tag)
+	}
+	page = links[start:end]
+	return
+}
 
-		if len(results) == 0 {
-			err = fmt.Errorf("No links for %s", tag)
+// isInNeighborhood returns true if this node is one of the NeighborhoodSize closest nodes
+// (by XOR distance) to the given hash, of the nodes in the routing table, and thus should hold
+// the data for that hash.  A NeighborhoodSize of 0 or 1 means no sharding so everything is
+// in the neighborhood.
+func (dht *DHT) isInNeighborhood(key Hash) bool {
+	ns := dht.config.NeighborhoodSize
+	if ns <= 1 {
+		return true
+	}
+	node := dht.h.node
+	peers := node.routingTable.NearestPeers(key, ns)
+	if len(peers) < ns {
+		return true
+	}
+	me := HashXORDistance(HashFromPeerID(node.HashAddr), key)
+	farthest := HashXORDistance(HashFromPeerID(peers[len(peers)-1]), key)
+	return me.Cmp(farthest) < 0
+}
+
+// redirectIfNotHolding checks to see if the given hash is outside of this node's neighborhood
+// and if so returns a CloserPeersResp of the peers that should be holding it, otherwise nil
+func (dht *DHT) redirectIfNotHolding(key Hash, from peer.ID) (response interface{}) {
+	if dht.isInNeighborhood(key) {
+		return
+	}
+	dht.dlog.Logf("%v not in my neighborhood, not holding", key)
+	resp := CloserPeersResp{}
+	closest := dht.h.node.betterPeersForHash(&key, from, CloserPeerCount)
+	resp.CloserPeers = dht.h.node.peers2PeerInfos(closest)
+	response = resp
+	return
+}
+
+// changeKey returns the hash on which a DHT change message acts, i.e. the hash whose
+// neighborhood is responsible for holding the change
+func changeKey(m *Message) (key Hash, ok bool) {
+	ok = true
+	switch t := m.Body.(type) {
+	case PutReq:
+		key = t.H
+		if t.Successor && t.Header != nil {
+			key = t.Header.HeaderLink
 		}
-		return err
-	})
+	case DelReq:
+		key = t.H
+	case ModReq:
+		key = t.H
+	case LinkReq:
+		key = t.Base
+	default:
+		ok = false
+	}
 	return
 }
 
 // Change sends DHT change messages to the closest peers to the hash in question
+// If the DHT is sharded only the NeighborhoodSize closest peers receive the change
 func (dht *DHT) Change(key Hash, msgType MsgType, body interface{}) (err error) {
 	dht.h.Debugf("Starting %v Change for %v with body %v", msgType, key, body)
 
 	msg := dht.h.node.NewMessage(msgType, body)
 	// change in our local DHT as well as
-	_, err = dht.send(nil, dht.h.nodeID, msg)
+	if dht.isInNeighborhood(key) {
+		_, err = dht.send(nil, dht.h.nodeID, msg)
 
-	if err != nil {
-		dht.dlog.Logf("DHT send of %v to self failed with error: %s", msgType, err)
-		err = nil
+		if err != nil {
+			dht.dlog.Logf("DHT send of %v to self failed with error: %s", msgType, err)
+			err = nil
+		}
 	}
 	node := dht.h.node
 
@@ -690,17 +705,51 @@ func (dht *DHT) Change(key Hash, msgType MsgType, body interface{}) (err error)
 		return err
 	}
 
-	wg := sync.WaitGroup{}
+	// the peers come back sorted by distance so only the first NeighborhoodSize
+	// of them are responsible for the key when we are sharding, the rest are spares
+	// for when those are full
+	var peers, spares []peer.ID
 	for p := range pchan {
+		peers = append(peers, p)
+	}
+	ns := dht.config.NeighborhoodSize
+	if ns > 1 && len(peers) > ns {
+		peers, spares = peers[:ns], peers[ns:]
+	}
+	// spares aren't in the key's neighborhood so they have to be told to hold the put
+	// anyway, only puts are refused for being over quota
+	var spareMsg *Message
+	if req, ok := body.(PutReq); ok && !req.Successor && len(spares) > 0 {
+		req.Overflow = true
+		spareMsg = dht.h.node.NewMessage(msgType, req)
+	}
+	var slk sync.Mutex
+	wg := sync.WaitGroup{}
+	for _, p := range peers {
 		wg.Add(1)
 		go func(p peer.ID) {
-			ctx, cancel := context.WithCancel(node.ctx)
-			defer cancel()
 			defer wg.Done()
-
-			_, err := dht.send(ctx, p, msg)
-			if err != nil {
-				dht.dlog.Logf("DHT send of %v failed to peer %v with error: %s", msgType, p, err)
+			m := msg
+			for {
+				ctx, cancel := context.WithCancel(node.ctx)
+				_, err := dht.send(ctx, p, m)
+				cancel()
+				if err != ErrStorageQuota {
+					if err != nil {
+						dht.dlog.Logf("DHT send of %v failed to peer %v with error: %s", msgType, p, err)
+					}
+					return
+				}
+				slk.Lock()
+				if len(spares) == 0 || spareMsg == nil {
+					slk.Unlock()
+					dht.dlog.Logf("DHT send of %v refused by full peer %v and no other peers to send to", msgType, p)
+					return
+				}
+				dht.dlog.Logf("DHT send of %v refused by full peer %v, sending to %v instead", msgType, p, spares[0])
+				p, spares = spares[0], spares[1:]
+				m = spareMsg
+				slk.Unlock()
 			}
 		}(p)
 	}
@@ -775,6 +824,157 @@ func (dht *DHT) Query(key Hash, msgType MsgType, body interface{}) (response int
 	return
 }
 
+// QueryLinks sends a link query to the neighborhood of its base rather than stopping at the
+// first node that answers as Query does, and returns the links they hold merged, without
+// duplicates and paged.  Nodes that have nothing on the base answer with closer peers and
+// are ignored.
+func (dht *DHT) QueryLinks(q LinkQuery) (response *LinkQueryResp, err error) {
+	dht.h.Debugf("Starting links query for %v with %v", q.Base, q)
+	node := dht.h.node
+	msg := node.NewMessage(GETLINK_REQUEST, q)
+
+	var peers []peer.ID
+	if dht.isInNeighborhood(q.Base) {
+		peers = append(peers, dht.h.nodeID)
+	}
+	pchan, e := node.GetClosestPeers(node.ctx, q.Base)
+	if e == nil {
+		var closest []peer.ID
+		for p := range pchan {
+			closest = append(closest, p)
+		}
+		// without sharding every node holds everything so a few of them will do
+		ns := dht.config.NeighborhoodSize
+		if ns <= 1 {
+			ns = AlphaValue
+		}
+		if len(closest) > ns {
+			closest = closest[:ns]
+		}
+		peers = append(peers, closest...)
+	} else if e != ErrEmptyRoutingTable {
+		err = e
+		return
+	}
+
+	var lk sync.Mutex
+	var links []TaggedHash
+	var answered, more bool
+	var lastErr error
+	seen := make(map[string]bool)
+	wg := sync.WaitGroup{}
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			r, err := dht.send(nil, p, msg)
+			lk.Lock()
+			defer lk.Unlock()
+			if err != nil {
+				dht.dlog.Logf("links query to %v failed with error: %s", p, err)
+				lastErr = err
+				return
+			}
+			var resp *LinkQueryResp
+			switch t := r.(type) {
+			case *LinkQueryResp:
+				resp = t
+			case LinkQueryResp:
+				resp = &t
+			default:
+				// a node that has nothing on the base sends closer peers
+				return
+			}
+			answered = true
+			if resp.Next != "" {
+				more = true
+			}
+			for _, th := range resp.Links {
+				c := linkCursor(&th, q.T)
+				if !seen[c] {
+					seen[c] = true
+					links = append(links, th)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+	if !answered {
+		err = lastErr
+		if err == nil {
+			err = ErrHashNotFound
+		}
+		return
+	}
+
+	sort.Slice(links, func(i, j int) bool { return linkCursor(&links[i], q.T) < linkCursor(&links[j], q.T) })
+	response = &LinkQueryResp{}
+	response.Links, response.Next = dht.pageLinks(links, q.T, q.Cursor, q.Limit)
+	// nodes that had more than a page of links mean there may be more even if the merged
+	// links just fill the page
+	if more && response.Next == "" && len(response.Links) > 0 {
+		response.Next = linkCursor(&response.Links[len(response.Links)-1], q.T)
+	}
+	return
+}
+
+// GetMany gets many hashes at once, sending the requests for the hashes each peer is
+// responsible for to it in messages of at most MaxGetManyBatch, concurrently.  Errors are
+// returned for each request, including for those the peer didn't hold after all which can
+// then be queried for.
+func (dht *DHT) GetMany(reqs []GetReq) (resps []GetResp, errs []error) {
+	resps = make([]GetResp, len(reqs))
+	errs = make([]error, len(reqs))
+	node := dht.h.node
+	groups := make(map[peer.ID][]int)
+	for i := range reqs {
+		p := dht.h.nodeID
+		if !dht.isInNeighborhood(reqs[i].H) {
+			if closest := node.routingTable.NearestPeers(reqs[i].H, 1); len(closest) > 0 {
+				p = closest[0]
+			}
+		}
+		groups[p] = append(groups[p], i)
+	}
+
+	wg := sync.WaitGroup{}
+	for p, all := range groups {
+		for len(all) > 0 {
+			idx := all
+			if len(idx) > MaxGetManyBatch {
+				idx = idx[:MaxGetManyBatch]
+			}
+			all = all[len(idx):]
+			wg.Add(1)
+			go func(p peer.ID, idx []int) {
+				defer wg.Done()
+				req := GetManyReq{Reqs: make([]GetReq, len(idx))}
+				for j, i := range idx {
+					req.Reqs[j] = reqs[i]
+				}
+				r, err := dht.send(nil, p, node.NewMessage(GETMANY_REQUEST, req))
+				resp, ok := r.(GetManyResp)
+				if err == nil && (!ok || len(resp.Resps) != len(idx) || len(resp.Errs) != len(idx)) {
+					err = fmt.Errorf("unexpected response to GETMANY_REQUEST: %T", r)
+				}
+				if err != nil {
+					dht.dlog.Logf("get many from %v failed with error: %s", p, err)
+					for _, i := range idx {
+						errs[i] = err
+					}
+					return
+				}
+				for j, i := range idx {
+					resps[i] = resp.Resps[j]
+					errs[i] = resp.itemErr(j)
+				}
+			}(p, idx)
+		}
+	}
+	wg.Wait()
+	return
+}
+
 // Send sends a message to the node
 func (dht *DHT) send(ctx context.Context, to peer.ID, msg *Message) (response interface{}, err error) {
 	if ctx == nil {
@@ -807,7 +1007,10 @@ func (dht *DHT) Start() (err error) {
 	if err = dht.h.node.StartProtocol(dht.h, GossipProtocol); err != nil {
 		return
 	}
-	err = dht.h.node.StartProtocol(dht.h, KademliaProtocol)
+	if err = dht.h.node.StartProtocol(dht.h, KademliaProtocol); err != nil {
+		return
+	}
+	err = dht.resumeRetries()
 	return
 }
 
@@ -834,11 +1037,6 @@ func (dht *DHT) DumpIdx(idx int) (str string, err error) {
 	return
 }
 
-func statusValueToString(val string) string {
-	//TODO
-	return val
-}
-
 // String converts a DHT into a human readable string
 func (dht *DHT) String() (result string) {
 	idx, err := dht.GetIdx()
@@ -856,48 +1054,33 @@ func (dht *DHT) String() (result string) {
 	}
 
 	result += fmt.Sprintf("DHT entries:\n")
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("entry", func(key, value string) bool {
-			x := strings.Split(key, ":")
-			k := string(x[1])
-			var status string
-			statusVal, err := tx.Get("status:" + k)
-			if err != nil {
-				status = fmt.Sprintf("<err getting status:%v>", err)
-			} else {
-				status = statusValueToString(statusVal)
-			}
-
-			var sources string
-			sources, err = tx.Get("src:" + k)
-			if err != nil {
-				sources = fmt.Sprintf("<err getting sources:%v>", err)
-			}
-			var links string
-			err = tx.Ascend("link", func(key, value string) bool {
-				x := strings.Split(key, ":")
-				base := x[1]
-				link := x[2]
-				tag := x[3]
-				if base == k {
-					links += fmt.Sprintf("Linked to: %s with tag %s\n", link, tag)
-					links += value + "\n"
-				}
-				return true
-			})
-			result += fmt.Sprintf("Hash--%s (status %s):\nValue: %s\nSources: %s\n%s\n", k, status, value, sources, links)
-			return true
-		})
-		return nil
+	dht.db.Iterate(func(r DHTRecord) bool {
+		var links string
+		for _, l := range r.Links {
+			links += fmt.Sprintf("Linked to: %s with tag %s\n", l.Link, l.Tag)
+			b, _ := json.Marshal(l.Events)
+			links += string(b) + "\n"
+		}
+		result += fmt.Sprintf("Hash--%s (status %d):\nValue: %s\nSources: %s\n%s\n", r.Key, r.Status, string(r.Value), r.Source, links)
+		return true
 	})
 
+	retries, err := dht.getRetries()
+	if err != nil {
+		result += fmt.Sprintf("DHT retries error: %v\n", err)
+		return
+	}
+	result += fmt.Sprintf("DHT retries: %d\n", len(retries))
+	for _, r := range retries {
+		result += fmt.Sprintf("%v\n   retries left: %d next: %v\n", r.Msg, r.Retries, r.Next)
+	}
+
 	return
 }
 
 // Close cleans up the DHT
 func (dht *DHT) Close() {
-	close(dht.retryQueue)
-	dht.retryQueue = nil
+	dht.unsubscribeAll()
 	close(dht.gchan)
 	dht.gchan = nil
 	close(dht.gossipPuts)
@@ -906,16 +1089,93 @@ func (dht *DHT) Close() {
 	dht.db = nil
 }
 
-// Retry starts retry processing
+// retryBackoff returns how long to wait before trying a change that has the given number of
+// retries left
+func retryBackoff(retries int) (wait time.Duration) {
+	wait = RetryBackoff
+	for i := retries; i < MaxRetries && wait < MaxRetryBackoff; i++ {
+		wait *= 2
+	}
+	if wait > MaxRetryBackoff {
+		wait = MaxRetryBackoff
+	}
+	return
+}
+
+// queueRetry records a change to be tried again later
+func (dht *DHT) queueRetry(msg *Message, retries int) (err error) {
+	var f Hash
+	f, err = msg.Fingerprint()
+	if err != nil {
+		return
+	}
+	err = dht.db.PutRetry(f, Retry{Msg: *msg, Retries: retries, Next: time.Now().Add(retryBackoff(retries))})
+	return
+}
+
+// getRetries returns the changes waiting to be retried
+func (dht *DHT) getRetries() (retries []Retry, err error) {
+	retries, err = dht.db.GetRetries()
+	return
+}
+
+// resumeRetries makes the changes left waiting when the node last stopped due now, as what
+// they were waiting for may well have arrived in the meantime
+func (dht *DHT) resumeRetries() (err error) {
+	var retries []Retry
+	retries, err = dht.getRetries()
+	if err != nil || len(retries) == 0 {
+		return
+	}
+	dht.dlog.Logf("resuming %d retries", len(retries))
+	now := time.Now()
+	for _, r := range retries {
+		if r.Next.After(now) {
+			var f Hash
+			f, err = r.Msg.Fingerprint()
+			if err != nil {
+				return
+			}
+			r.Next = now
+			if err = dht.db.PutRetry(f, r); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// RetryTask tries again the changes that are due to be retried
 func RetryTask(h *Holochain) {
 	dht := h.dht
-	if dht != nil && len(dht.retryQueue) > 0 {
-		r := <-dht.retryQueue
-		if r.retries > 0 {
-			resp, err := actionReceiver(dht.h, &r.msg, r.retries-1)
-			dht.dlog.Logf("retry %d of %v, response: %d error: %v", r.retries, r.msg, resp, err)
+	if dht == nil {
+		return
+	}
+	retries, err := dht.getRetries()
+	if err != nil {
+		dht.dlog.Logf("unable to get retries: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, r := range retries {
+		if r.Next.After(now) {
+			break
+		}
+		f, err := r.Msg.Fingerprint()
+		if err != nil {
+			dht.dlog.Logf("error calculating fingerprint for retry of %v: %v", r.Msg, err)
+			continue
+		}
+		// the receiver queues the change again if it still can't be made
+		if err = dht.db.DeleteRetry(f); err != nil {
+			dht.dlog.Logf("unable to delete retry of %v: %v", r.Msg, err)
+			continue
+		}
+		if r.Retries > 0 {
+			resp, err := actionReceiver(dht.h, &r.Msg, r.Retries-1)
+			dht.dlog.Logf("retry %d of %v, response: %d error: %v", r.Retries, r.Msg, resp, err)
 		} else {
-			dht.dlog.Logf("max retries for %v, ignoring", r.msg)
+			dht.dlog.Logf("max retries for %v, ignoring", r.Msg)
 		}
 	}
 }
@@ -0,0 +1,123 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// detection of agents forking their chains, or rolling them back and carrying on from an
+// earlier header.  Committing a shared entry also sends a put request announcing its header
+// to the neighborhood of the header it follows, and the nodes there record which header
+// follows which for each author, so both sides of a fork reach the same nodes.  A second
+// header following the same one is a fork, proven by the two signed put requests, which is
+// warranted to the network in a blocked list addition.  Headers only sign their entry so the
+// proof needs the signed requests rather than just the two headers.
+
+package holochain
+
+import (
+	"errors"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+)
+
+var ErrNoSuccessorHeader = errors.New("successor put request has no header")
+
+// announceSuccessor sends the header of a committed entry to the neighborhood of the header
+// it follows so the nodes there can check that nothing else follows it
+func (dht *DHT) announceSuccessor(entryHash Hash, header *Header) (err error) {
+	if header == nil || header.HeaderLink.IsNullHash() {
+		return
+	}
+	err = dht.Change(header.HeaderLink, PUT_REQUEST, PutReq{H: entryHash, Header: header, Successor: true})
+	return
+}
+
+// receiveSuccessor checks the header announced by a put request against any other recorded
+// as following the same header, redirecting the request if that header isn't held here
+func (dht *DHT) receiveSuccessor(m *Message) (response interface{}, err error) {
+	t := m.Body.(PutReq)
+	if t.Header == nil {
+		err = ErrNoSuccessorHeader
+		return
+	}
+	if response = dht.redirectIfNotHolding(t.Header.HeaderLink, m.From); response != nil {
+		return
+	}
+	if e := dht.checkFork(m); e != nil {
+		dht.dlog.Logf("Put %v fork check failed: %v", t.H, e)
+	}
+	response = DHTChangeOK
+	return
+}
+
+// putHeader returns the header carried by a put request if it's one its sender could have
+// made, that is for the entry being put and signed by the same key as the request
+func putHeader(m *Message) (header *Header, ok bool) {
+	req, isPut := m.Body.(PutReq)
+	if !isPut || req.Header == nil || m.Sig == nil || !req.Header.EntryLink.Equal(&req.H) {
+		return
+	}
+	pub, err := ic.UnmarshalPublicKey(m.Key)
+	if err != nil {
+		return
+	}
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil || id != m.From {
+		return
+	}
+	if ok, err = pub.Verify(req.H.H, req.Header.Sig.S); err != nil || !ok {
+		ok = false
+		return
+	}
+	header = req.Header
+	return
+}
+
+// checkFork records the header carried by a put request as following its previous header
+// in the author's chain, and if a different header was already recorded there warrants
+// the fork, blocking the author here and gossiping the warrant
+func (dht *DHT) checkFork(m *Message) (err error) {
+	header, ok := putHeader(m)
+	if !ok || header.HeaderLink.IsNullHash() || m.From == dht.h.nodeID {
+		return
+	}
+	var prev Message
+	prev, err = dht.db.GetSuccessor(m.From, header.HeaderLink)
+	if err == ErrHashNotFound {
+		err = dht.db.PutSuccessor(m.From, header.HeaderLink, m)
+		return
+	}
+	if err != nil {
+		return
+	}
+	prevHeader, _ := putHeader(&prev)
+	var hash, prevHash Hash
+	if hash, _, err = header.Sum(dht.h.hashSpec); err != nil {
+		return
+	}
+	if prevHash, _, err = prevHeader.Sum(dht.h.hashSpec); err != nil {
+		return
+	}
+	if hash.Equal(&prevHash) {
+		return
+	}
+	dht.dlog.Logf("%v forked its chain after %v", m.From, header.HeaderLink)
+
+	var w *ForkWarrant
+	if w, err = NewForkWarrant(&prev, m); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = w.Encode(); err != nil {
+		return
+	}
+	// receiving the list addition ourselves checks the warrant, blocks the author and
+	// records it in the put index for gossiping
+	req := ListAddReq{
+		ListType:    BlockedList,
+		Peers:       []string{peer.IDB58Encode(m.From)},
+		WarrantType: ForkType,
+		Warrant:     data,
+	}
+	_, err = NewListAddAction(PeerList{}).Receive(dht, dht.h.node.NewMessage(LISTADD_REQUEST, req), 0)
+	return
+}
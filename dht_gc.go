@@ -0,0 +1,185 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// garbage collection of DHT data that has outlived its entry type's TTL and of deleted and
+// modified entries and deleted links kept longer than the DNA's TombstoneRetention.
+// The put index is read from where the last collection left off, and purging an entry
+// prunes the messages that made its changes from the index but leaves their fingerprints
+// so that gossip doesn't bring the data back.  The messages linking on bases whose deleted
+// links are purged stay in the index as they may also have made links that are still live.
+
+package holochain
+
+import (
+	. "github.com/metacurrency/holochain/hash"
+	"time"
+)
+
+const (
+	DefaultGCInterval = time.Minute * 10
+)
+
+// changeTimes holds when the hashes in the DHT were last put, had their status changed
+// and were linked to, and the indexes of the messages that made those changes, as recorded
+// in the put index up to idx
+type changeTimes struct {
+	idx    int
+	put    map[string]time.Time
+	status map[string]time.Time
+	link   map[string]time.Time
+	idxs   map[string][]int
+}
+
+// forget drops what's recorded for a key
+func (times *changeTimes) forget(key string) {
+	delete(times.put, key)
+	delete(times.status, key)
+	delete(times.link, key)
+	delete(times.idxs, key)
+}
+
+// latest records t in times for key if it's later than what's there
+func latest(times map[string]time.Time, key string, t time.Time) {
+	if t.After(times[key]) {
+		times[key] = t
+	}
+}
+
+// getChangeTimes adds the changes made to the put index since the last collection to the
+// times collected so far
+func (dht *DHT) getChangeTimes() (times *changeTimes, err error) {
+	times = dht.gcTimes
+	if times == nil {
+		times = &changeTimes{put: make(map[string]time.Time), status: make(map[string]time.Time), link: make(map[string]time.Time), idxs: make(map[string][]int)}
+		dht.gcTimes = times
+	}
+	var puts []Put
+	puts, err = dht.GetPuts(times.idx + 1)
+	if err != nil {
+		return
+	}
+	for _, p := range puts {
+		if p.Idx > times.idx {
+			times.idx = p.Idx
+		}
+		key, ok := changeKey(&p.M)
+		if !ok {
+			continue
+		}
+		k := key.String()
+		times.idxs[k] = append(times.idxs[k], p.Idx)
+		switch p.M.Type {
+		case PUT_REQUEST:
+			latest(times.put, k, p.M.Time)
+		case DEL_REQUEST, MOD_REQUEST:
+			latest(times.status, k, p.M.Time)
+		case LINK_REQUEST:
+			latest(times.link, k, p.M.Time)
+		}
+	}
+	return
+}
+
+// ttl returns how long entries of a type are held in the DHT, 0 meaning for ever
+func (dht *DHT) ttl(entryType string) time.Duration {
+	_, def, err := dht.h.GetEntryDef(entryType)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(def.TTL) * time.Second
+}
+
+// expired returns true if an entry of the given type put at the given time has outlived its TTL
+func (dht *DHT) expired(entryType string, put time.Time, now time.Time) bool {
+	ttl := dht.ttl(entryType)
+	return ttl > 0 && now.Sub(put) > ttl
+}
+
+// gc purges the data that has expired at the given time returning the number of entries
+// and bases of deleted links purged
+func (dht *DHT) gc(now time.Time) (purged int, err error) {
+	dht.gclk.Lock()
+	defer dht.gclk.Unlock()
+	retention := time.Duration(dht.config.TombstoneRetention) * time.Second
+	var times *changeTimes
+	times, err = dht.getChangeTimes()
+	if err != nil {
+		return
+	}
+
+	// the store can't be changed while iterating so collect what to purge first
+	var entries, links []string
+	err = dht.db.Iterate(func(r DHTRecord) bool {
+		put, ok := times.put[r.Key]
+		if ok && dht.expired(r.EntryType, put, now) {
+			entries = append(entries, r.Key)
+			return true
+		}
+		if retention > 0 && (r.Status == StatusDeleted || r.Status == StatusModified) {
+			if changed, ok := times.status[r.Key]; ok && now.Sub(changed) > retention {
+				entries = append(entries, r.Key)
+				return true
+			}
+		}
+		if retention > 0 {
+			for _, l := range r.Links {
+				if linkDeleted(l.Events) {
+					if linked, ok := times.link[r.Key]; ok && now.Sub(linked) > retention {
+						links = append(links, r.Key)
+					}
+					break
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return
+	}
+
+	if len(entries) > 0 {
+		defer dht.recountStored()
+	}
+	for _, k := range entries {
+		var key Hash
+		if key, err = NewHash(k); err != nil {
+			return
+		}
+		dht.dlog.Logf("GC purging %s", k)
+		if err = dht.db.Purge(key); err != nil {
+			return
+		}
+		if err = dht.db.PrunePuts(times.idxs[k]); err != nil {
+			return
+		}
+		times.forget(k)
+		purged++
+	}
+	for _, k := range links {
+		var key Hash
+		if key, err = NewHash(k); err != nil {
+			return
+		}
+		dht.dlog.Logf("GC purging deleted links on %s", k)
+		if err = dht.db.PurgeDeletedLinks(key); err != nil {
+			return
+		}
+		purged++
+	}
+	return
+}
+
+// GCTask purges expired data from the DHT
+func GCTask(h *Holochain) {
+	dht := h.dht
+	if dht == nil {
+		return
+	}
+	purged, err := dht.gc(time.Now())
+	if err != nil {
+		dht.dlog.Logf("GC error: %v", err)
+	} else if purged > 0 {
+		dht.dlog.Logf("GC purged %d", purged)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// DHTStore defines the interface to the storage backends of the distributed hash table
+
+package holochain
+
+import (
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// constants for the DHT store types that can be set in the Config
+
+	BuntDBDHTStore = "buntdb"
+	MemoryDHTStore = "memory"
+	BoltDHTStore   = "bolt"
+)
+
+// DHTStore is the interface to the storage backend of a DHT node.  It holds the entries
+// and their status, the links on them, the put index used for gossiping, the gossiper
+// records and the peer lists.  Implementations must make each call atomic.
+type DHTStore interface {
+	// Put stores a value, its type, source and status, and records the message in the put index
+	Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) error
+
+	// SetStatus changes the status of a stored value and records the message in the put index
+	SetStatus(m *Message, key Hash, status int) error
+
+	// Mod moves a value to the StatusModified status and records the replacedBy link to newKey
+	Mod(m *Message, key Hash, newKey Hash) error
+
+	// Get retrieves a value and the parts of its record requested by getMask
+	// N.B. if the value has been modified and statusMask is StatusDefault, data holds the
+	// hash of the replacing value and err is ErrHashModified
+	Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error)
+
+	// Exists returns nil if a value with a status in statusMask exists for the key
+	Exists(key Hash, statusMask int) error
+
+	// Source returns the source of a stored value
+	Source(key Hash) (peer.ID, error)
+
+	// Link records a linking event on a live base and records the message in the put index
+	Link(m *Message, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) error
+
+	// GetLinks returns the links on a live or modified base whose status is in statusMask
+	GetLinks(base Hash, tag string, statusMask int) ([]TaggedHash, error)
+
+	// GetIdx returns the current put index
+	GetIdx() (int, error)
+
+	// GetIdxMessage returns the message that caused the change at the given index
+	GetIdxMessage(idx int) (Message, error)
+
+	// GetFingerprint returns the index of the message with the given fingerprint, or -1
+	GetFingerprint(f Hash) (int, error)
+
+	// IterateFingerprints calls fn with each recorded fingerprint and its index until fn returns false
+	IterateFingerprints(fn func(f string, idx int) bool) error
+
+	// GetPuts returns the puts at or after the given index sorted by index
+	GetPuts(since int) ([]Put, error)
+
+	// GetGossiper returns the last known index of a gossiper, 0 if unknown
+	GetGossiper(id peer.ID) (int, error)
+
+	// GetGossipers returns all the known gossipers
+	GetGossipers() ([]peer.ID, error)
+
+	// UpdateGossiper sets the last known index of a gossiper, ignoring values less than the current one
+	UpdateGossiper(id peer.ID, newIdx int) error
+
+	// DeleteGossiper removes a gossiper
+	DeleteGossiper(id peer.ID) error
+
+	// GetList returns the peer list of the given type
+	GetList(listType PeerListType) (PeerList, error)
+
+	// AddToList adds the peers to a list and records the message in the put index
+	AddToList(m *Message, list PeerList) error
+
+	// Purge removes a value and the links on it, leaving the messages that made them in the put index
+	Purge(key Hash) error
+
+	// PurgeDeletedLinks removes the links on a base whose last event is a deletion
+	PurgeDeletedLinks(base Hash) error
+
+	// PrunePuts removes the messages at the given indexes from the put index, leaving their
+	// fingerprints so that gossip doesn't bring back what they changed
+	PrunePuts(idxs []int) error
+
+	// SetValue replaces a stored value leaving the rest of its record and the put index as they are
+	SetValue(key Hash, value []byte) error
+
+	// Iterate calls fn on each stored value until fn returns false
+	Iterate(fn func(r DHTRecord) bool) error
+
+	// PutRetry records a change waiting to be retried, replacing any earlier record for the
+	// message with the given fingerprint
+	PutRetry(f Hash, r Retry) error
+
+	// DeleteRetry removes the record of a change waiting to be retried
+	DeleteRetry(f Hash) error
+
+	// GetRetries returns the changes waiting to be retried sorted by when they are next due
+	GetRetries() ([]Retry, error)
+
+	// PutSuccessor records the put request carrying the header that follows prev in an author's
+	// chain, forgetting the oldest record if there are more than MaxSuccessors
+	PutSuccessor(author peer.ID, prev Hash, m *Message) error
+
+	// GetSuccessor returns the put request recorded for the header following prev in an
+	// author's chain, or ErrHashNotFound if there isn't one
+	GetSuccessor(author peer.ID, prev Hash) (Message, error)
+
+	// Close releases the store
+	Close() error
+}
+
+// DHTRecord holds everything stored for one value, used for iterating over a DHTStore
+type DHTRecord struct {
+	Key       string
+	Value     []byte
+	EntryType string
+	Source    string
+	Status    int
+	Links     []LinkRecord
+}
+
+// LinkRecord holds the linking events stored for one link on a base
+type LinkRecord struct {
+	Link   string
+	Tag    string
+	Events []LinkEvent
+}
+
+// NewDHTStore opens a store of the given type in the given directory, defaulting to buntdb
+func NewDHTStore(storeType string, path string) (store DHTStore, err error) {
+	switch storeType {
+	case "", BuntDBDHTStore:
+		store, err = NewBuntDBStore(filepath.Join(path, DHTStoreFileName))
+	case MemoryDHTStore:
+		store = NewMemoryStore()
+	case BoltDHTStore:
+		store, err = NewBoltStore(filepath.Join(path, DHTBoltStoreFileName))
+	default:
+		err = fmt.Errorf("unknown DHT store type: %s", storeType)
+	}
+	return
+}
+
+// linkKey builds the key under which a link's events are stored
+func linkKey(base string, link string, tag string) string {
+	return base + ":" + link + ":" + tag
+}
+
+// splitLinkKey is the inverse of linkKey
+func splitLinkKey(key string) (base string, link string, tag string) {
+	x := strings.SplitN(key, ":", 3)
+	return x[0], x[1], x[2]
+}
+
+// checkStatus converts a stored status into the error that a get with the given status mask
+// should return.  If the status mask is StatusDefault then statuses other than live return
+// errors, otherwise the value is only found if the status is in the mask.
+func checkStatus(status int, statusMask int) (err error) {
+	if statusMask == StatusDefault {
+		switch status {
+		case StatusDeleted:
+			err = ErrHashDeleted
+		case StatusModified:
+			err = ErrHashModified
+		case StatusRejected:
+			err = ErrHashRejected
+		case StatusLive:
+		default:
+			panic("unknown status!")
+		}
+	} else if (status & statusMask) == 0 {
+		err = ErrHashNotFound
+	}
+	return
+}
+
+// appendLinkEvent adds a linking event to the events already recorded for a link
+// this ensure monotonic recording of linking attempts
+func appendLinkEvent(records []LinkEvent, found bool, src peer.ID, status int, linkingEntryHash Hash) ([]LinkEvent, error) {
+	// TODO: if the link exists, then load the statuses and see
+	// what we should do about this situation
+	if !found && status == StatusDeleted {
+		// when deleting the key must exist
+		return nil, ErrLinkNotFound
+	}
+	return append(records, LinkEvent{status, peer.IDB58Encode(src), linkingEntryHash.String()}), nil
+}
+
+// linkResult builds the TaggedHash for a link if its current status is in the mask
+func linkResult(link string, tag string, records []LinkEvent, queryTag string, statusMask int) (th TaggedHash, ok bool) {
+	l := len(records)
+	//TODO: this is totally bogus currently simply
+	// looking at the last item we ever got
+	if l > 0 {
+		entry := records[l-1]
+		if (entry.Status & statusMask) > 0 {
+			th = TaggedHash{H: link, Source: entry.Source}
+			if queryTag == "" {
+				th.T = tag
+			}
+			ok = true
+		}
+	}
+	return
+}
+
+// linkDeleted returns true if the last event recorded for a link is its deletion
+func linkDeleted(records []LinkEvent) bool {
+	l := len(records)
+	return l > 0 && records[l-1].Status == StatusDeleted
+}
+
+// sortRetries sorts retries by when they are next due
+func sortRetries(retries []Retry) {
+	sort.Slice(retries, func(i, j int) bool { return retries[i].Next.Before(retries[j].Next) })
+}
+
+// noLinksError is the error returned when a get links query finds nothing
+type noLinksError struct {
+	tag string
+}
+
+func (e *noLinksError) Error() string {
+	return fmt.Sprintf("No links for %s", e.tag)
+}
+
+// noLinksErr returns the error for a get links query on tag finding nothing
+func noLinksErr(tag string) error {
+	return &noLinksError{tag: tag}
+}
+
+// isNoLinksErr returns true if err is from a get links query finding nothing
+func isNoLinksErr(err error) bool {
+	_, ok := err.(*noLinksError)
+	return ok
+}
+
+// MaxSuccessors is how many header successors a DHT store records before it forgets the oldest
+var MaxSuccessors = 10000
+
+// successorKey is the key under which the successor of prev in an author's chain is stored
+func successorKey(author peer.ID, prev Hash) string {
+	return peer.IDB58Encode(author) + ":" + prev.String()
+}
@@ -0,0 +1,672 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// bolt implementation of the DHTStore, an on-disk B+tree for DHTs too big to hold in memory
+
+package holochain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/boltdb/bolt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"strconv"
+	"strings"
+)
+
+var (
+	boltEntriesBucket      = []byte("entries")
+	boltLinksBucket        = []byte("links")
+	boltIdxBucket          = []byte("idx")
+	boltFingerprintsBucket = []byte("fingerprints")
+	boltGossipersBucket    = []byte("gossipers")
+	boltListsBucket        = []byte("lists")
+	boltMetaBucket         = []byte("meta")
+	boltRetriesBucket      = []byte("retries")
+	boltSuccessorsBucket   = []byte("successors")
+	boltSuccessorSeqBucket = []byte("successorseq")
+
+	boltIdxKey = []byte("_idx")
+)
+
+// boltEntry is the record stored in the entries bucket
+type boltEntry struct {
+	Value      []byte
+	Type       string
+	Source     string
+	Status     int
+	ReplacedBy string
+}
+
+// BoltStore is a DHTStore backed by a bolt database file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens or creates a bolt DHT store at the given path
+func NewBoltStore(path string) (store *BoltStore, err error) {
+	var db *bolt.DB
+	db, err = bolt.Open(path, 0600, nil)
+	if err != nil {
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{boltEntriesBucket, boltLinksBucket, boltIdxBucket, boltFingerprintsBucket, boltGossipersBucket, boltListsBucket, boltMetaBucket, boltRetriesBucket, boltSuccessorsBucket, boltSuccessorSeqBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return
+	}
+	store = &BoltStore{db: db}
+	return
+}
+
+// idxKey encodes a put index so that the keys sort numerically
+func idxKey(idx int) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(idx))
+	return k
+}
+
+// boltInt returns the integer value at a key, and assumes the value 0 if the key doesn't exist
+func boltInt(b *bolt.Bucket, key []byte) (i int, err error) {
+	v := b.Get(key)
+	if v == nil {
+		return
+	}
+	i, err = strconv.Atoi(string(v))
+	return
+}
+
+func boltPutInt(b *bolt.Bucket, key []byte, i int) error {
+	return b.Put(key, []byte(strconv.Itoa(i)))
+}
+
+// boltIncIdx adds a new index record to the store for gossiping later
+func boltIncIdx(tx *bolt.Tx, m *Message) (err error) {
+	// if message is nil we can't record this for gossiping
+	// this should only be the case for the DNA
+	if m == nil {
+		return
+	}
+	meta := tx.Bucket(boltMetaBucket)
+	var idx int
+	idx, err = boltInt(meta, boltIdxKey)
+	if err != nil {
+		return
+	}
+	idx++
+	if err = boltPutInt(meta, boltIdxKey, idx); err != nil {
+		return
+	}
+	var b []byte
+	b, err = ByteEncoder(m)
+	if err != nil {
+		return
+	}
+	if err = tx.Bucket(boltIdxBucket).Put(idxKey(idx), b); err != nil {
+		return
+	}
+	var f Hash
+	f, err = m.Fingerprint()
+	if err != nil {
+		return
+	}
+	err = boltPutInt(tx.Bucket(boltFingerprintsBucket), []byte(f.String()), idx)
+	return
+}
+
+func boltGetEntry(tx *bolt.Tx, k string) (e *boltEntry, err error) {
+	v := tx.Bucket(boltEntriesBucket).Get([]byte(k))
+	if v == nil {
+		err = ErrHashNotFound
+		return
+	}
+	e = &boltEntry{}
+	err = json.Unmarshal(v, e)
+	return
+}
+
+func boltPutEntry(tx *bolt.Tx, k string, e *boltEntry) (err error) {
+	var b []byte
+	b, err = json.Marshal(e)
+	if err != nil {
+		return
+	}
+	err = tx.Bucket(boltEntriesBucket).Put([]byte(k), b)
+	return
+}
+
+func boltGet(tx *bolt.Tx, k string, statusMask int) (e *boltEntry, err error) {
+	e, err = boltGetEntry(tx, k)
+	if err != nil {
+		return
+	}
+	err = checkStatus(e.Status, statusMask)
+	return
+}
+
+func boltLink(tx *bolt.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+	b := tx.Bucket(boltLinksBucket)
+	key := []byte(linkKey(base, link, tag))
+	var records []LinkEvent
+	v := b.Get(key)
+	if v != nil {
+		json.Unmarshal(v, &records)
+	}
+	records, err = appendLinkEvent(records, v != nil, src, status, linkingEntryHash)
+	if err != nil {
+		return
+	}
+	var j []byte
+	j, err = json.Marshal(records)
+	if err != nil {
+		return
+	}
+	err = b.Put(key, j)
+	return
+}
+
+// Put implements DHTStore
+func (s *BoltStore) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := boltIncIdx(tx, m); err != nil {
+			return err
+		}
+		return boltPutEntry(tx, key.String(), &boltEntry{Value: value, Type: entryType, Source: peer.IDB58Encode(src), Status: status})
+	})
+	return
+}
+
+// SetStatus implements DHTStore
+func (s *BoltStore) SetStatus(m *Message, key Hash, status int) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		e, err := boltGetEntry(tx, k)
+		if err != nil {
+			return err
+		}
+		if err = boltIncIdx(tx, m); err != nil {
+			return err
+		}
+		e.Status = status
+		return boltPutEntry(tx, k, e)
+	})
+	return
+}
+
+// Mod implements DHTStore
+func (s *BoltStore) Mod(m *Message, key Hash, newKey Hash) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		e, err := boltGetEntry(tx, k)
+		if err != nil {
+			return err
+		}
+		if err = boltIncIdx(tx, m); err != nil {
+			return err
+		}
+		link := newKey.String()
+		if err = boltLink(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newKey); err != nil {
+			return err
+		}
+		e.Status = StatusModified
+		e.ReplacedBy = link
+		return boltPutEntry(tx, k, e)
+	})
+	return
+}
+
+// Get implements DHTStore
+func (s *BoltStore) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		e, err := boltGet(tx, key.String(), statusMask)
+		if err == ErrHashModified {
+			data = []byte(e.ReplacedBy)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+		data = e.Value
+		if (getMask & GetMaskEntryType) != 0 {
+			entryType = e.Type
+		}
+		if (getMask & GetMaskSources) != 0 {
+			sources = append(sources, e.Source)
+		}
+		status = e.Status
+		return nil
+	})
+	return
+}
+
+// Exists implements DHTStore
+func (s *BoltStore) Exists(key Hash, statusMask int) (err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		_, err := boltGet(tx, key.String(), statusMask)
+		return err
+	})
+	return
+}
+
+// Source implements DHTStore
+func (s *BoltStore) Source(key Hash) (id peer.ID, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		e, err := boltGetEntry(tx, key.String())
+		if err == nil {
+			id, err = peer.IDB58Decode(e.Source)
+		}
+		return err
+	})
+	return
+}
+
+// Link implements DHTStore
+func (s *BoltStore) Link(m *Message, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := boltGet(tx, base, StatusLive); err != nil {
+			return err
+		}
+		if err := boltLink(tx, base, link, tag, src, status, linkingEntryHash); err != nil {
+			return err
+		}
+		return boltIncIdx(tx, m)
+	})
+	return
+}
+
+// boltLinks calls fn on each link stored on base, in key order
+func boltLinks(tx *bolt.Tx, base string, fn func(link string, tag string, records []LinkEvent) bool) {
+	prefix := []byte(base + ":")
+	c := tx.Bucket(boltLinksBucket).Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		_, link, tag := splitLinkKey(string(k))
+		var records []LinkEvent
+		json.Unmarshal(v, &records)
+		if !fn(link, tag, records) {
+			return
+		}
+	}
+}
+
+// GetLinks implements DHTStore
+func (s *BoltStore) GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
+	b := base.String()
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if _, err := boltGet(tx, b, StatusLive+StatusModified); err != nil { //only get links on live and modified bases
+			return err
+		}
+		if statusMask == StatusDefault {
+			statusMask = StatusLive
+		}
+		results = make([]TaggedHash, 0)
+		boltLinks(tx, b, func(link string, t string, records []LinkEvent) bool {
+			if tag == "" || tag == t {
+				if th, ok := linkResult(link, t, records, tag, statusMask); ok {
+					results = append(results, th)
+				}
+			}
+			return true
+		})
+		if len(results) == 0 {
+			return noLinksErr(tag)
+		}
+		return nil
+	})
+	return
+}
+
+// GetIdx implements DHTStore
+func (s *BoltStore) GetIdx() (idx int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) (e error) {
+		idx, e = boltInt(tx.Bucket(boltMetaBucket), boltIdxKey)
+		return
+	})
+	return
+}
+
+// GetIdxMessage implements DHTStore
+func (s *BoltStore) GetIdxMessage(idx int) (msg Message, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltIdxBucket).Get(idxKey(idx))
+		if v == nil {
+			return ErrNoSuchIdx
+		}
+		return ByteDecoder(v, &msg)
+	})
+	return
+}
+
+// GetFingerprint implements DHTStore
+func (s *BoltStore) GetFingerprint(f Hash) (index int, err error) {
+	index = -1
+	err = s.db.View(func(tx *bolt.Tx) (e error) {
+		b := tx.Bucket(boltFingerprintsBucket)
+		k := []byte(f.String())
+		if b.Get(k) != nil {
+			index, e = boltInt(b, k)
+		}
+		return
+	})
+	return
+}
+
+// IterateFingerprints implements DHTStore
+func (s *BoltStore) IterateFingerprints(fn func(f string, idx int) bool) (err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltFingerprintsBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			idx, err := strconv.Atoi(string(v))
+			if err != nil {
+				return err
+			}
+			if !fn(string(k), idx) {
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// GetPuts implements DHTStore
+func (s *BoltStore) GetPuts(since int) (puts []Put, err error) {
+	puts = make([]Put, 0)
+	if since < 0 {
+		since = 0
+	}
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltIdxBucket).Cursor()
+		for k, v := c.Seek(idxKey(since)); k != nil; k, v = c.Next() {
+			p := Put{Idx: int(binary.BigEndian.Uint64(k))}
+			if err := ByteDecoder(v, &p.M); err != nil {
+				return err
+			}
+			puts = append(puts, p)
+		}
+		return nil
+	})
+	return
+}
+
+// GetGossiper implements DHTStore
+func (s *BoltStore) GetGossiper(id peer.ID) (idx int, err error) {
+	err = s.db.View(func(tx *bolt.Tx) (e error) {
+		idx, e = boltInt(tx.Bucket(boltGossipersBucket), []byte(peer.IDB58Encode(id)))
+		return
+	})
+	return
+}
+
+// GetGossipers implements DHTStore
+func (s *BoltStore) GetGossipers() (glist []peer.ID, err error) {
+	glist = make([]peer.ID, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltGossipersBucket).ForEach(func(k, v []byte) error {
+			id, e := peer.IDB58Decode(string(k))
+			if e != nil {
+				return e
+			}
+			glist = append(glist, id)
+			return nil
+		})
+	})
+	return
+}
+
+// UpdateGossiper implements DHTStore
+func (s *BoltStore) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltGossipersBucket)
+		k := []byte(peer.IDB58Encode(id))
+		idx, e := boltInt(b, k)
+		if e != nil {
+			return e
+		}
+		if newIdx < idx {
+			return nil
+		}
+		return boltPutInt(b, k, newIdx)
+	})
+	return
+}
+
+// DeleteGossiper implements DHTStore
+func (s *BoltStore) DeleteGossiper(id peer.ID) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltGossipersBucket).Delete([]byte(peer.IDB58Encode(id)))
+	})
+	return
+}
+
+// GetList implements DHTStore
+func (s *BoltStore) GetList(listType PeerListType) (result PeerList, err error) {
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	prefix := []byte(string(listType) + ":")
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltListsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			pid, e := peer.IDB58Decode(strings.TrimPrefix(string(k), string(prefix)))
+			if e != nil {
+				return e
+			}
+			result.Records = append(result.Records, PeerRecord{ID: pid, Warrant: string(v)})
+		}
+		return nil
+	})
+	return
+}
+
+// AddToList implements DHTStore
+func (s *BoltStore) AddToList(m *Message, list PeerList) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := boltIncIdx(tx, m); err != nil {
+			return err
+		}
+		b := tx.Bucket(boltListsBucket)
+		for _, r := range list.Records {
+			k := string(list.Type) + ":" + peer.IDB58Encode(r.ID)
+			if err := b.Put([]byte(k), []byte(r.Warrant)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// Iterate implements DHTStore
+func (s *BoltStore) Iterate(fn func(r DHTRecord) bool) (err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltEntriesBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var e boltEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			r := DHTRecord{Key: string(k), Value: e.Value, EntryType: e.Type, Source: e.Source, Status: e.Status}
+			boltLinks(tx, r.Key, func(link string, tag string, records []LinkEvent) bool {
+				r.Links = append(r.Links, LinkRecord{Link: link, Tag: tag, Events: records})
+				return true
+			})
+			if !fn(r) {
+				break
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// boltPurgeLinks removes the links on a base for which fn returns true
+func boltPurgeLinks(tx *bolt.Tx, base string, fn func(records []LinkEvent) bool) (err error) {
+	prefix := []byte(base + ":")
+	b := tx.Bucket(boltLinksBucket)
+	var keys [][]byte
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		var records []LinkEvent
+		json.Unmarshal(v, &records)
+		if fn(records) {
+			keys = append(keys, append([]byte{}, k...))
+		}
+	}
+	for _, k := range keys {
+		if err = b.Delete(k); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Purge implements DHTStore
+func (s *BoltStore) Purge(key Hash) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(boltEntriesBucket).Delete([]byte(k)); err != nil {
+			return err
+		}
+		return boltPurgeLinks(tx, k, func(records []LinkEvent) bool { return true })
+	})
+	return
+}
+
+// PurgeDeletedLinks implements DHTStore
+func (s *BoltStore) PurgeDeletedLinks(base Hash) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return boltPurgeLinks(tx, base.String(), linkDeleted)
+	})
+	return
+}
+
+// PrunePuts implements DHTStore
+func (s *BoltStore) PrunePuts(idxs []int) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltIdxBucket)
+		for _, idx := range idxs {
+			if err := b.Delete(idxKey(idx)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// SetValue implements DHTStore
+func (s *BoltStore) SetValue(key Hash, value []byte) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		e, err := boltGetEntry(tx, k)
+		if err != nil {
+			return err
+		}
+		e.Value = value
+		return boltPutEntry(tx, k, e)
+	})
+	return
+}
+
+// PutRetry implements DHTStore
+func (s *BoltStore) PutRetry(f Hash, r Retry) (err error) {
+	var b []byte
+	b, err = ByteEncoder(r)
+	if err != nil {
+		return
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRetriesBucket).Put([]byte(f.String()), b)
+	})
+	return
+}
+
+// DeleteRetry implements DHTStore
+func (s *BoltStore) DeleteRetry(f Hash) (err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRetriesBucket).Delete([]byte(f.String()))
+	})
+	return
+}
+
+// GetRetries implements DHTStore
+func (s *BoltStore) GetRetries() (retries []Retry, err error) {
+	retries = make([]Retry, 0)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltRetriesBucket).ForEach(func(k, v []byte) error {
+			var r Retry
+			if e := ByteDecoder(v, &r); e != nil {
+				return e
+			}
+			retries = append(retries, r)
+			return nil
+		})
+	})
+	sortRetries(retries)
+	return
+}
+
+// PutSuccessor implements DHTStore
+func (s *BoltStore) PutSuccessor(author peer.ID, prev Hash, m *Message) (err error) {
+	var b []byte
+	b, err = ByteEncoder(m)
+	if err != nil {
+		return
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(boltSuccessorsBucket)
+		k := []byte(successorKey(author, prev))
+		if bkt.Get(k) == nil {
+			// the keys are numbered as they're recorded so the oldest can be forgotten
+			seqs := tx.Bucket(boltSuccessorSeqBucket)
+			n, err := seqs.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err = seqs.Put(idxKey(int(n)), k); err != nil {
+				return err
+			}
+			if int(n) > MaxSuccessors {
+				old := idxKey(int(n) - MaxSuccessors)
+				if ok := seqs.Get(old); ok != nil {
+					if err = bkt.Delete(ok); err != nil {
+						return err
+					}
+					if err = seqs.Delete(old); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return bkt.Put(k, b)
+	})
+	return
+}
+
+// GetSuccessor implements DHTStore
+func (s *BoltStore) GetSuccessor(author peer.ID, prev Hash) (m Message, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltSuccessorsBucket).Get([]byte(successorKey(author, prev)))
+		if v == nil {
+			return ErrHashNotFound
+		}
+		return ByteDecoder(v, &m)
+	})
+	return
+}
+
+// Close implements DHTStore
+func (s *BoltStore) Close() (err error) {
+	err = s.db.Close()
+	s.db = nil
+	return
+}
@@ -0,0 +1,760 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// buntdb implementation of the DHTStore
+
+package holochain
+
+import (
+	"encoding/json"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"github.com/tidwall/buntdb"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BuntDBStore is a DHTStore backed by buntdb
+type BuntDBStore struct {
+	db *buntdb.DB
+}
+
+// NewBuntDBStore opens or creates a buntdb DHT store at the given path
+func NewBuntDBStore(path string) (store *BuntDBStore, err error) {
+	var db *buntdb.DB
+	db, err = buntdb.Open(path)
+	if err != nil {
+		return
+	}
+	db.CreateIndex("link", "link:*", buntdb.IndexString)
+	db.CreateIndex("idx", "idx:*", buntdb.IndexInt)
+	db.CreateIndex("peer", "peer:*", buntdb.IndexString)
+	db.CreateIndex("list", "list:*", buntdb.IndexString)
+	db.CreateIndex("entry", "entry:*", buntdb.IndexString)
+	db.CreateIndex("retry", "retry:*", buntdb.IndexString)
+	store = &BuntDBStore{db: db}
+	return
+}
+
+// Put implements DHTStore
+func (s *BuntDBStore) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, err := incIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("entry:"+k, string(value), nil)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("type:"+k, entryType, nil)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("src:"+k, peer.IDB58Encode(src), nil)
+		if err != nil {
+			return err
+		}
+		_, _, err = tx.Set("status:"+k, fmt.Sprintf("%d", status), nil)
+		if err != nil {
+			return err
+		}
+		return err
+	})
+	return
+}
+
+func _setStatus(tx *buntdb.Tx, m *Message, key string, status int) (err error) {
+
+	_, err = tx.Get("entry:" + key)
+	if err != nil {
+		if err == buntdb.ErrNotFound {
+			err = ErrHashNotFound
+		}
+		return
+	}
+
+	_, err = incIdx(tx, m)
+	if err != nil {
+		return
+	}
+
+	_, _, err = tx.Set("status:"+key, fmt.Sprintf("%d", status), nil)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// SetStatus implements DHTStore
+func (s *BuntDBStore) SetStatus(m *Message, key Hash, status int) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		return _setStatus(tx, m, key.String(), status)
+	})
+	return
+}
+
+// Mod implements DHTStore
+func (s *BuntDBStore) Mod(m *Message, key Hash, newKey Hash) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		err = _setStatus(tx, m, k, StatusModified)
+		if err == nil {
+			link := newKey.String()
+			err = _link(tx, k, link, SysTagReplacedBy, m.From, StatusLive, newKey)
+			if err == nil {
+				_, _, err = tx.Set("replacedBy:"+k, link, nil)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return err
+	})
+	return
+}
+
+func _get(tx *buntdb.Tx, k string, statusMask int) (string, error) {
+	val, err := tx.Get("entry:" + k)
+	if err == buntdb.ErrNotFound {
+		err = ErrHashNotFound
+		return val, err
+	}
+	var statusVal string
+	statusVal, err = tx.Get("status:" + k)
+	if err == nil {
+		var status int
+		status, err = strconv.Atoi(statusVal)
+		if err == nil {
+			err = checkStatus(status, statusMask)
+			if err == ErrHashModified {
+				val, err = tx.Get("replacedBy:" + k)
+				if err != nil {
+					panic("missing expected replacedBy record")
+				}
+				err = ErrHashModified
+			}
+		}
+	}
+	return val, err
+}
+
+// Exists implements DHTStore
+func (s *BuntDBStore) Exists(key Hash, statusMask int) (err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		_, err := _get(tx, key.String(), statusMask)
+		return err
+	})
+	return
+}
+
+// Source implements DHTStore
+func (s *BuntDBStore) Source(key Hash) (id peer.ID, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		val, err := tx.Get("src:" + key.String())
+		if err == buntdb.ErrNotFound {
+			err = ErrHashNotFound
+		}
+		if err == nil {
+			id, err = peer.IDB58Decode(val)
+		}
+		return err
+	})
+	return
+}
+
+// Get implements DHTStore
+func (s *BuntDBStore) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		k := key.String()
+		val, err := _get(tx, k, statusMask)
+		if err != nil {
+			data = []byte(val) // gotta do this because value is valid if ErrHashModified
+			return err
+		}
+		data = []byte(val)
+
+		if (getMask & GetMaskEntryType) != 0 {
+			entryType, err = tx.Get("type:" + k)
+			if err != nil {
+				return err
+			}
+		}
+		if (getMask & GetMaskSources) != 0 {
+			val, err = tx.Get("src:" + k)
+			if err == buntdb.ErrNotFound {
+				err = ErrHashNotFound
+			}
+			if err == nil {
+				sources = append(sources, val)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		val, err = tx.Get("status:" + k)
+		if err != nil {
+			return err
+		}
+		status, err = strconv.Atoi(val)
+		if err != nil {
+			return err
+		}
+
+		return err
+	})
+	return
+}
+
+// _link is a low level routine to add a link, also used by delLink
+func _link(tx *buntdb.Tx, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+	key := "link:" + linkKey(base, link, tag)
+	var val string
+	val, err = tx.Get(key)
+	var records []LinkEvent
+	if err == nil {
+		// load the previous value so we can append to it.
+		json.Unmarshal([]byte(val), &records)
+	} else if err != buntdb.ErrNotFound {
+		return
+	}
+	records, err = appendLinkEvent(records, err == nil, src, status, linkingEntryHash)
+	if err != nil {
+		return
+	}
+	var b []byte
+	b, err = json.Marshal(records)
+	if err != nil {
+		return
+	}
+	_, _, err = tx.Set(key, string(b), nil)
+	if err != nil {
+		return
+	}
+	return
+}
+
+// Link implements DHTStore
+func (s *BuntDBStore) Link(m *Message, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, err := _get(tx, base, StatusLive)
+		if err != nil {
+			return err
+		}
+		err = _link(tx, base, link, tag, src, status, linkingEntryHash)
+		if err != nil {
+			return err
+		}
+
+		//var index string
+		_, err = incIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// GetLinks implements DHTStore
+func (s *BuntDBStore) GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
+	b := base.String()
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		_, err := _get(tx, b, StatusLive+StatusModified) //only get links on live and modified bases
+		if err != nil {
+			return err
+		}
+
+		if statusMask == StatusDefault {
+			statusMask = StatusLive
+		}
+
+		results = make([]TaggedHash, 0)
+		err = tx.Ascend("link", func(key, value string) bool {
+			x := strings.Split(key, ":")
+			t := string(x[3])
+			if string(x[1]) == b && (tag == "" || tag == t) {
+				var records []LinkEvent
+				json.Unmarshal([]byte(value), &records)
+				if th, ok := linkResult(string(x[2]), t, records, tag, statusMask); ok {
+					results = append(results, th)
+				}
+			}
+
+			return true
+		})
+
+		if len(results) == 0 {
+			err = noLinksErr(tag)
+		}
+		return err
+	})
+	return
+}
+
+// incIdx adds a new index record to dht for gossiping later
+func incIdx(tx *buntdb.Tx, m *Message) (index string, err error) {
+	// if message is nil we can't record this for gossiping
+	// this should only be the case for the DNA
+	if m == nil {
+		return
+	}
+
+	var idx int
+	idx, err = getIntVal("_idx", tx)
+	if err != nil {
+		return
+	}
+	idx++
+	index = fmt.Sprintf("%d", idx)
+	_, _, err = tx.Set("_idx", index, nil)
+	if err != nil {
+		return
+	}
+
+	var msg string
+
+	if m != nil {
+		var b []byte
+
+		b, err = ByteEncoder(m)
+		if err != nil {
+			return
+		}
+		msg = string(b)
+
+		var decodedMessage interface{}
+		err = ByteDecoder(b, decodedMessage)
+	}
+	_, _, err = tx.Set("idx:"+index, msg, nil)
+	if err != nil {
+		return
+	}
+
+	f, err := m.Fingerprint()
+	if err != nil {
+		return
+	}
+	_, _, err = tx.Set("f:"+f.String(), index, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// getIntVal returns an integer value at a given key, and assumes the value 0 if the key doesn't exist
+func getIntVal(key string, tx *buntdb.Tx) (idx int, err error) {
+	var val string
+	val, err = tx.Get(key)
+	if err == buntdb.ErrNotFound {
+		err = nil
+	} else if err != nil {
+		return
+	} else {
+		idx, err = strconv.Atoi(val)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetIdx implements DHTStore
+func (s *BuntDBStore) GetIdx() (idx int, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		idx, e = getIntVal("_idx", tx)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// GetIdxMessage implements DHTStore
+func (s *BuntDBStore) GetIdxMessage(idx int) (msg Message, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		msgStr, e := tx.Get(fmt.Sprintf("idx:%d", idx))
+		if e == buntdb.ErrNotFound {
+			return ErrNoSuchIdx
+		}
+		if e != nil {
+			return e
+		}
+		e = ByteDecoder([]byte(msgStr), &msg)
+		if err != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// GetFingerprint implements DHTStore
+func (s *BuntDBStore) GetFingerprint(f Hash) (index int, err error) {
+	index = -1
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		idxStr, e := tx.Get("f:" + f.String())
+		if e == buntdb.ErrNotFound {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		index, e = strconv.Atoi(idxStr)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// IterateFingerprints implements DHTStore
+func (s *BuntDBStore) IterateFingerprints(fn func(f string, idx int) bool) (err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendKeys("f:*", func(key, value string) bool {
+			idx, _ := strconv.Atoi(value)
+			return fn(strings.TrimPrefix(key, "f:"), idx)
+		})
+	})
+	return
+}
+
+// GetPuts implements DHTStore
+func (s *BuntDBStore) GetPuts(since int) (puts []Put, err error) {
+	puts = make([]Put, 0)
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		err = tx.AscendGreaterOrEqual("idx", string(since), func(key, value string) bool {
+			x := strings.Split(key, ":")
+			idx, _ := strconv.Atoi(x[1])
+			if idx >= since {
+				p := Put{Idx: idx}
+				if value != "" {
+					err := ByteDecoder([]byte(value), &p.M)
+					if err != nil {
+						return false
+					}
+				}
+				puts = append(puts, p)
+			}
+			return true
+		})
+		sort.Slice(puts, func(i, j int) bool { return puts[i].Idx < puts[j].Idx })
+		return err
+	})
+	return
+}
+
+// GetGossiper implements DHTStore
+func (s *BuntDBStore) GetGossiper(id peer.ID) (idx int, err error) {
+	key := "peer:" + peer.IDB58Encode(id)
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		idx, e = getIntVal(key, tx)
+		if e != nil {
+			return e
+		}
+		return nil
+	})
+	return
+}
+
+// GetGossipers implements DHTStore
+func (s *BuntDBStore) GetGossipers() (glist []peer.ID, err error) {
+	glist = make([]peer.ID, 0)
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		err = tx.Ascend("peer", func(key, value string) bool {
+			x := strings.Split(key, ":")
+			id, e := peer.IDB58Decode(x[1])
+			if e != nil {
+				return false
+			}
+			glist = append(glist, id)
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// UpdateGossiper implements DHTStore
+func (s *BuntDBStore) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		key := "peer:" + peer.IDB58Encode(id)
+		idx, e := getIntVal(key, tx)
+		if e != nil {
+			return e
+		}
+		if newIdx < idx {
+			return nil
+		}
+		sidx := fmt.Sprintf("%d", newIdx)
+		_, _, err = tx.Set(key, sidx, nil)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	return
+}
+
+// DeleteGossiper implements DHTStore
+func (s *BuntDBStore) DeleteGossiper(id peer.ID) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		key := "peer:" + peer.IDB58Encode(id)
+		_, e := tx.Delete(key)
+		return e
+	})
+	return
+}
+
+// GetList implements DHTStore
+func (s *BuntDBStore) GetList(listType PeerListType) (result PeerList, err error) {
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		err = tx.Ascend("list", func(key, value string) bool {
+			x := strings.Split(key, ":")
+
+			if x[1] == string(listType) {
+				pid, e := peer.IDB58Decode(x[2])
+				if e != nil {
+					return false
+				}
+				r := PeerRecord{ID: pid, Warrant: value}
+				result.Records = append(result.Records, r)
+			}
+			return true
+		})
+		return nil
+	})
+	return
+}
+
+// AddToList implements DHTStore
+func (s *BuntDBStore) AddToList(m *Message, list PeerList) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, err = incIdx(tx, m)
+		if err != nil {
+			return err
+		}
+		for _, r := range list.Records {
+			k := peer.IDB58Encode(r.ID)
+			_, _, err = tx.Set("list:"+string(list.Type)+":"+k, r.Warrant, nil)
+			if err != nil {
+				return err
+			}
+		}
+		return err
+	})
+	return
+}
+
+// Iterate implements DHTStore
+func (s *BuntDBStore) Iterate(fn func(r DHTRecord) bool) (err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend("entry", func(key, value string) bool {
+			k := strings.TrimPrefix(key, "entry:")
+			r := DHTRecord{Key: k, Value: []byte(value)}
+			r.EntryType, _ = tx.Get("type:" + k)
+			r.Source, _ = tx.Get("src:" + k)
+			if statusVal, e := tx.Get("status:" + k); e == nil {
+				r.Status, _ = strconv.Atoi(statusVal)
+			}
+			tx.AscendKeys("link:"+k+":*", func(key, value string) bool {
+				_, link, tag := splitLinkKey(strings.TrimPrefix(key, "link:"))
+				lr := LinkRecord{Link: link, Tag: tag}
+				json.Unmarshal([]byte(value), &lr.Events)
+				r.Links = append(r.Links, lr)
+				return true
+			})
+			return fn(r)
+		})
+	})
+	return
+}
+
+// purgeLinks removes the links on a base for which fn returns true
+func purgeLinks(tx *buntdb.Tx, base string, fn func(records []LinkEvent) bool) (err error) {
+	var keys []string
+	tx.AscendKeys("link:"+base+":*", func(key, value string) bool {
+		var records []LinkEvent
+		json.Unmarshal([]byte(value), &records)
+		if fn(records) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, k := range keys {
+		if _, err = tx.Delete(k); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Purge implements DHTStore
+func (s *BuntDBStore) Purge(key Hash) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		for _, prefix := range []string{"entry:", "type:", "src:", "status:", "replacedBy:"} {
+			if _, err := tx.Delete(prefix + k); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return purgeLinks(tx, k, func(records []LinkEvent) bool { return true })
+	})
+	return
+}
+
+// PurgeDeletedLinks implements DHTStore
+func (s *BuntDBStore) PurgeDeletedLinks(base Hash) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		return purgeLinks(tx, base.String(), linkDeleted)
+	})
+	return
+}
+
+// PrunePuts implements DHTStore
+func (s *BuntDBStore) PrunePuts(idxs []int) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		for _, idx := range idxs {
+			if _, err := tx.Delete(fmt.Sprintf("idx:%d", idx)); err != nil && err != buntdb.ErrNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	return
+}
+
+// SetValue implements DHTStore
+func (s *BuntDBStore) SetValue(key Hash, value []byte) (err error) {
+	k := key.String()
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get("entry:" + k); err != nil {
+			if err == buntdb.ErrNotFound {
+				err = ErrHashNotFound
+			}
+			return err
+		}
+		_, _, err := tx.Set("entry:"+k, string(value), nil)
+		return err
+	})
+	return
+}
+
+// PutRetry implements DHTStore
+func (s *BuntDBStore) PutRetry(f Hash, r Retry) (err error) {
+	var b []byte
+	b, err = ByteEncoder(r)
+	if err != nil {
+		return
+	}
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, _, e := tx.Set("retry:"+f.String(), string(b), nil)
+		return e
+	})
+	return
+}
+
+// DeleteRetry implements DHTStore
+func (s *BuntDBStore) DeleteRetry(f Hash) (err error) {
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		_, e := tx.Delete("retry:" + f.String())
+		if e == buntdb.ErrNotFound {
+			e = nil
+		}
+		return e
+	})
+	return
+}
+
+// GetRetries implements DHTStore
+func (s *BuntDBStore) GetRetries() (retries []Retry, err error) {
+	retries = make([]Retry, 0)
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		var e error
+		tx.Ascend("retry", func(key, value string) bool {
+			var r Retry
+			if e = ByteDecoder([]byte(value), &r); e != nil {
+				return false
+			}
+			retries = append(retries, r)
+			return true
+		})
+		return e
+	})
+	sortRetries(retries)
+	return
+}
+
+// PutSuccessor implements DHTStore
+func (s *BuntDBStore) PutSuccessor(author peer.ID, prev Hash, m *Message) (err error) {
+	var b []byte
+	b, err = ByteEncoder(m)
+	if err != nil {
+		return
+	}
+	err = s.db.Update(func(tx *buntdb.Tx) error {
+		k := "succ:" + successorKey(author, prev)
+		_, e := tx.Get(k)
+		if e == buntdb.ErrNotFound {
+			// the keys are numbered as they're recorded so the oldest can be forgotten
+			var n int
+			if n, e = getIntVal("_succ", tx); e != nil {
+				return e
+			}
+			n++
+			if _, _, e = tx.Set("_succ", fmt.Sprintf("%d", n), nil); e != nil {
+				return e
+			}
+			if _, _, e = tx.Set(fmt.Sprintf("succseq:%d", n), k, nil); e != nil {
+				return e
+			}
+			if n > MaxSuccessors {
+				old := fmt.Sprintf("succseq:%d", n-MaxSuccessors)
+				if ok, e := tx.Delete(old); e == nil {
+					if _, e = tx.Delete(ok); e != nil && e != buntdb.ErrNotFound {
+						return e
+					}
+				}
+			}
+		} else if e != nil {
+			return e
+		}
+		_, _, e = tx.Set(k, string(b), nil)
+		return e
+	})
+	return
+}
+
+// GetSuccessor implements DHTStore
+func (s *BuntDBStore) GetSuccessor(author peer.ID, prev Hash) (m Message, err error) {
+	err = s.db.View(func(tx *buntdb.Tx) error {
+		value, e := tx.Get("succ:" + successorKey(author, prev))
+		if e == buntdb.ErrNotFound {
+			return ErrHashNotFound
+		}
+		if e != nil {
+			return e
+		}
+		return ByteDecoder([]byte(value), &m)
+	})
+	return
+}
+
+// Close implements DHTStore
+func (s *BuntDBStore) Close() (err error) {
+	err = s.db.Close()
+	s.db = nil
+	return
+}
@@ -0,0 +1,504 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// in-memory implementation of the DHTStore, nothing is persisted
+
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type memEntry struct {
+	value      []byte
+	entryType  string
+	source     string
+	status     int
+	replacedBy string
+}
+
+// MemoryStore is a DHTStore that holds everything in memory
+type MemoryStore struct {
+	lk           sync.RWMutex
+	entries      map[string]*memEntry
+	links        map[string][]LinkEvent
+	idx          int
+	puts         map[int]Message
+	fingerprints map[string]int
+	gossipers    map[peer.ID]int
+	lists        map[PeerListType]map[peer.ID]string
+	retries      map[string]Retry
+	successors   map[string]Message
+	successorSeq []string // successor keys in the order they were recorded
+}
+
+// NewMemoryStore creates an empty in-memory DHT store
+func NewMemoryStore() (store *MemoryStore) {
+	store = &MemoryStore{
+		entries:      make(map[string]*memEntry),
+		links:        make(map[string][]LinkEvent),
+		puts:         make(map[int]Message),
+		fingerprints: make(map[string]int),
+		gossipers:    make(map[peer.ID]int),
+		lists:        make(map[PeerListType]map[peer.ID]string),
+		retries:      make(map[string]Retry),
+		successors:   make(map[string]Message),
+	}
+	return
+}
+
+// incIdx records the message in the put index, assumes the lock is held
+func (s *MemoryStore) incIdx(m *Message) (err error) {
+	// if message is nil we can't record this for gossiping
+	// this should only be the case for the DNA
+	if m == nil {
+		return
+	}
+	var f Hash
+	f, err = m.Fingerprint()
+	if err != nil {
+		return
+	}
+	s.idx++
+	s.puts[s.idx] = *m
+	s.fingerprints[f.String()] = s.idx
+	return
+}
+
+// get returns the entry for a key if its status matches, assumes the lock is held
+func (s *MemoryStore) get(k string, statusMask int) (e *memEntry, err error) {
+	e, ok := s.entries[k]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	err = checkStatus(e.status, statusMask)
+	return
+}
+
+// Put implements DHTStore
+func (s *MemoryStore) Put(m *Message, entryType string, key Hash, src peer.ID, value []byte, status int) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if err = s.incIdx(m); err != nil {
+		return
+	}
+	v := make([]byte, len(value))
+	copy(v, value)
+	s.entries[key.String()] = &memEntry{value: v, entryType: entryType, source: peer.IDB58Encode(src), status: status}
+	return
+}
+
+// SetStatus implements DHTStore
+func (s *MemoryStore) SetStatus(m *Message, key Hash, status int) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	e, ok := s.entries[key.String()]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	if err = s.incIdx(m); err != nil {
+		return
+	}
+	e.status = status
+	return
+}
+
+// Mod implements DHTStore
+func (s *MemoryStore) Mod(m *Message, key Hash, newKey Hash) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	k := key.String()
+	e, ok := s.entries[k]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	if err = s.incIdx(m); err != nil {
+		return
+	}
+	e.status = StatusModified
+	link := newKey.String()
+	if err = s.link(k, link, SysTagReplacedBy, m.From, StatusLive, newKey); err != nil {
+		return
+	}
+	e.replacedBy = link
+	return
+}
+
+// Get implements DHTStore
+func (s *MemoryStore) Get(key Hash, statusMask int, getMask int) (data []byte, entryType string, sources []string, status int, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	e, err := s.get(key.String(), statusMask)
+	if err == ErrHashModified {
+		data = []byte(e.replacedBy)
+		return
+	}
+	if err != nil {
+		return
+	}
+	data = make([]byte, len(e.value))
+	copy(data, e.value)
+	if (getMask & GetMaskEntryType) != 0 {
+		entryType = e.entryType
+	}
+	if (getMask & GetMaskSources) != 0 {
+		sources = append(sources, e.source)
+	}
+	status = e.status
+	return
+}
+
+// Exists implements DHTStore
+func (s *MemoryStore) Exists(key Hash, statusMask int) (err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	_, err = s.get(key.String(), statusMask)
+	return
+}
+
+// Source implements DHTStore
+func (s *MemoryStore) Source(key Hash) (id peer.ID, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	e, ok := s.entries[key.String()]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	id, err = peer.IDB58Decode(e.source)
+	return
+}
+
+// link adds a linking event, assumes the lock is held
+func (s *MemoryStore) link(base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+	key := linkKey(base, link, tag)
+	records, found := s.links[key]
+	records, err = appendLinkEvent(records, found, src, status, linkingEntryHash)
+	if err != nil {
+		return
+	}
+	s.links[key] = records
+	return
+}
+
+// Link implements DHTStore
+func (s *MemoryStore) Link(m *Message, base string, link string, tag string, src peer.ID, status int, linkingEntryHash Hash) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if _, err = s.get(base, StatusLive); err != nil {
+		return
+	}
+	if err = s.link(base, link, tag, src, status, linkingEntryHash); err != nil {
+		return
+	}
+	err = s.incIdx(m)
+	return
+}
+
+// sortedLinkKeys returns the link keys on a base in the order buntdb would iterate them
+func (s *MemoryStore) sortedLinkKeys(base string) (keys []string) {
+	prefix := base + ":"
+	for k := range s.links {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+// GetLinks implements DHTStore
+func (s *MemoryStore) GetLinks(base Hash, tag string, statusMask int) (results []TaggedHash, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	b := base.String()
+	if _, err = s.get(b, StatusLive+StatusModified); err != nil { //only get links on live and modified bases
+		return
+	}
+	if statusMask == StatusDefault {
+		statusMask = StatusLive
+	}
+	results = make([]TaggedHash, 0)
+	for _, k := range s.sortedLinkKeys(b) {
+		_, link, t := splitLinkKey(k)
+		if tag == "" || tag == t {
+			if th, ok := linkResult(link, t, s.links[k], tag, statusMask); ok {
+				results = append(results, th)
+			}
+		}
+	}
+	if len(results) == 0 {
+		err = noLinksErr(tag)
+	}
+	return
+}
+
+// GetIdx implements DHTStore
+func (s *MemoryStore) GetIdx() (idx int, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	idx = s.idx
+	return
+}
+
+// GetIdxMessage implements DHTStore
+func (s *MemoryStore) GetIdxMessage(idx int) (msg Message, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	msg, ok := s.puts[idx]
+	if !ok {
+		err = ErrNoSuchIdx
+	}
+	return
+}
+
+// GetFingerprint implements DHTStore
+func (s *MemoryStore) GetFingerprint(f Hash) (index int, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	index, ok := s.fingerprints[f.String()]
+	if !ok {
+		index = -1
+	}
+	return
+}
+
+// IterateFingerprints implements DHTStore
+func (s *MemoryStore) IterateFingerprints(fn func(f string, idx int) bool) (err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	for f, idx := range s.fingerprints {
+		if !fn(f, idx) {
+			break
+		}
+	}
+	return
+}
+
+// GetPuts implements DHTStore
+func (s *MemoryStore) GetPuts(since int) (puts []Put, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	puts = make([]Put, 0)
+	if since < 1 {
+		since = 1
+	}
+	for i := since; i <= s.idx; i++ {
+		if m, ok := s.puts[i]; ok {
+			puts = append(puts, Put{Idx: i, M: m})
+		}
+	}
+	return
+}
+
+// GetGossiper implements DHTStore
+func (s *MemoryStore) GetGossiper(id peer.ID) (idx int, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	idx = s.gossipers[id]
+	return
+}
+
+// GetGossipers implements DHTStore
+func (s *MemoryStore) GetGossipers() (glist []peer.ID, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	glist = make([]peer.ID, 0, len(s.gossipers))
+	for id := range s.gossipers {
+		glist = append(glist, id)
+	}
+	sort.Slice(glist, func(i, j int) bool { return peer.IDB58Encode(glist[i]) < peer.IDB58Encode(glist[j]) })
+	return
+}
+
+// UpdateGossiper implements DHTStore
+func (s *MemoryStore) UpdateGossiper(id peer.ID, newIdx int) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if idx, ok := s.gossipers[id]; ok && newIdx < idx {
+		return
+	}
+	s.gossipers[id] = newIdx
+	return
+}
+
+// DeleteGossiper implements DHTStore
+func (s *MemoryStore) DeleteGossiper(id peer.ID) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	delete(s.gossipers, id)
+	return
+}
+
+// GetList implements DHTStore
+func (s *MemoryStore) GetList(listType PeerListType) (result PeerList, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	result.Type = listType
+	result.Records = make([]PeerRecord, 0)
+	for id, warrant := range s.lists[listType] {
+		result.Records = append(result.Records, PeerRecord{ID: id, Warrant: warrant})
+	}
+	return
+}
+
+// AddToList implements DHTStore
+func (s *MemoryStore) AddToList(m *Message, list PeerList) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if err = s.incIdx(m); err != nil {
+		return
+	}
+	l, ok := s.lists[list.Type]
+	if !ok {
+		l = make(map[peer.ID]string)
+		s.lists[list.Type] = l
+	}
+	for _, r := range list.Records {
+		l[r.ID] = r.Warrant
+	}
+	return
+}
+
+// Iterate implements DHTStore
+func (s *MemoryStore) Iterate(fn func(r DHTRecord) bool) (err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	keys := make([]string, 0, len(s.entries))
+	for k := range s.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e := s.entries[k]
+		r := DHTRecord{Key: k, Value: e.value, EntryType: e.entryType, Source: e.source, Status: e.status}
+		for _, lk := range s.sortedLinkKeys(k) {
+			_, link, tag := splitLinkKey(lk)
+			r.Links = append(r.Links, LinkRecord{Link: link, Tag: tag, Events: s.links[lk]})
+		}
+		if !fn(r) {
+			break
+		}
+	}
+	return
+}
+
+// purgeLinks removes the links on a base for which fn returns true, assumes the lock is held
+func (s *MemoryStore) purgeLinks(base string, fn func(records []LinkEvent) bool) {
+	prefix := base + ":"
+	for k, records := range s.links {
+		if strings.HasPrefix(k, prefix) && fn(records) {
+			delete(s.links, k)
+		}
+	}
+}
+
+// Purge implements DHTStore
+func (s *MemoryStore) Purge(key Hash) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	k := key.String()
+	delete(s.entries, k)
+	s.purgeLinks(k, func(records []LinkEvent) bool { return true })
+	return
+}
+
+// PurgeDeletedLinks implements DHTStore
+func (s *MemoryStore) PurgeDeletedLinks(base Hash) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.purgeLinks(base.String(), linkDeleted)
+	return
+}
+
+// PrunePuts implements DHTStore
+func (s *MemoryStore) PrunePuts(idxs []int) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for _, idx := range idxs {
+		delete(s.puts, idx)
+	}
+	return
+}
+
+// SetValue implements DHTStore
+func (s *MemoryStore) SetValue(key Hash, value []byte) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	e, ok := s.entries[key.String()]
+	if !ok {
+		err = ErrHashNotFound
+		return
+	}
+	e.value = make([]byte, len(value))
+	copy(e.value, value)
+	return
+}
+
+// PutRetry implements DHTStore
+func (s *MemoryStore) PutRetry(f Hash, r Retry) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.retries[f.String()] = r
+	return
+}
+
+// DeleteRetry implements DHTStore
+func (s *MemoryStore) DeleteRetry(f Hash) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	delete(s.retries, f.String())
+	return
+}
+
+// GetRetries implements DHTStore
+func (s *MemoryStore) GetRetries() (retries []Retry, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	retries = make([]Retry, 0, len(s.retries))
+	for _, r := range s.retries {
+		retries = append(retries, r)
+	}
+	sortRetries(retries)
+	return
+}
+
+// PutSuccessor implements DHTStore
+func (s *MemoryStore) PutSuccessor(author peer.ID, prev Hash, m *Message) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	k := successorKey(author, prev)
+	if _, ok := s.successors[k]; !ok {
+		s.successorSeq = append(s.successorSeq, k)
+		if len(s.successorSeq) > MaxSuccessors {
+			delete(s.successors, s.successorSeq[0])
+			s.successorSeq = s.successorSeq[1:]
+		}
+	}
+	s.successors[k] = *m
+	return
+}
+
+// GetSuccessor implements DHTStore
+func (s *MemoryStore) GetSuccessor(author peer.ID, prev Hash) (m Message, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	m, ok := s.successors[successorKey(author, prev)]
+	if !ok {
+		err = ErrHashNotFound
+	}
+	return
+}
+
+// Close implements DHTStore
+func (s *MemoryStore) Close() (err error) {
+	return
+}
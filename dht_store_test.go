@@ -0,0 +1,231 @@
+package holochain
+
+import (
+	"fmt"
+	. "github.com/metacurrency/holochain/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestDHTStores(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	Convey("it should fail to make an unknown store type", t, func() {
+		_, err := NewDHTStore("foo", h.DBPath())
+		So(err.Error(), ShouldEqual, "unknown DHT store type: foo")
+	})
+
+	for _, storeType := range []string{BuntDBDHTStore, MemoryDHTStore, BoltDHTStore} {
+		store, err := NewDHTStore(storeType, d)
+		if err != nil {
+			panic(err)
+		}
+
+		base, _ := NewHash("QmZcUPvPhD1Xvk6mwijYF8AfR3mG31S1YsEfHG4khrFPRr")
+		link, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+		mod, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+		id := h.nodeID
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: base})
+
+		Convey(fmt.Sprintf("%s store should put and get values", storeType), t, func() {
+			So(store.Exists(base, StatusDefault), ShouldEqual, ErrHashNotFound)
+
+			err := store.Put(m, "someType", base, id, []byte("some value"), StatusLive)
+			So(err, ShouldBeNil)
+			So(store.Exists(base, StatusDefault), ShouldBeNil)
+
+			data, entryType, sources, status, err := store.Get(base, StatusDefault, GetMaskAll)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "some value")
+			So(entryType, ShouldEqual, "someType")
+			So(sources[0], ShouldEqual, h.nodeIDStr)
+			So(status, ShouldEqual, StatusLive)
+
+			src, err := store.Source(base)
+			So(err, ShouldBeNil)
+			So(src, ShouldEqual, id)
+
+			idx, err := store.GetIdx()
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 1)
+			msg, err := store.GetIdxMessage(1)
+			So(err, ShouldBeNil)
+			So(msg.Type, ShouldEqual, PUT_REQUEST)
+			_, err = store.GetIdxMessage(2)
+			So(err, ShouldEqual, ErrNoSuchIdx)
+
+			f, _ := m.Fingerprint()
+			idx, err = store.GetFingerprint(f)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 1)
+			idx, err = store.GetFingerprint(link)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, -1)
+		})
+
+		Convey(fmt.Sprintf("%s store should link and get links", storeType), t, func() {
+			lm := h.node.NewMessage(LINK_REQUEST, LinkReq{Base: base, Links: link})
+			err := store.Link(lm, base.String(), link.String(), "tag", id, StatusLive, link)
+			So(err, ShouldBeNil)
+
+			links, err := store.GetLinks(base, "tag", StatusDefault)
+			So(err, ShouldBeNil)
+			So(len(links), ShouldEqual, 1)
+			So(links[0].H, ShouldEqual, link.String())
+			So(links[0].Source, ShouldEqual, h.nodeIDStr)
+
+			err = store.Link(lm, base.String(), link.String(), "tag", id, StatusDeleted, link)
+			So(err, ShouldBeNil)
+			_, err = store.GetLinks(base, "tag", StatusDefault)
+			So(err.Error(), ShouldEqual, "No links for tag")
+			So(isNoLinksErr(err), ShouldBeTrue)
+			links, err = store.GetLinks(base, "", StatusDeleted)
+			So(err, ShouldBeNil)
+			So(links[0].T, ShouldEqual, "tag")
+
+			err = store.Link(lm, base.String(), mod.String(), "tag", id, StatusDeleted, link)
+			So(err, ShouldEqual, ErrLinkNotFound)
+			err = store.Link(lm, mod.String(), link.String(), "tag", id, StatusLive, link)
+			So(err, ShouldEqual, ErrHashNotFound)
+		})
+
+		Convey(fmt.Sprintf("%s store should mod and delete values", storeType), t, func() {
+			mm := h.node.NewMessage(MOD_REQUEST, ModReq{H: base, N: mod})
+			err := store.Mod(mm, base, mod)
+			So(err, ShouldBeNil)
+
+			data, _, _, _, err := store.Get(base, StatusDefault, GetMaskEntry)
+			So(err, ShouldEqual, ErrHashModified)
+			So(string(data), ShouldEqual, mod.String())
+			links, err := store.GetLinks(base, SysTagReplacedBy, StatusLive)
+			So(err, ShouldBeNil)
+			So(links[0].H, ShouldEqual, mod.String())
+
+			dm := h.node.NewMessage(DEL_REQUEST, DelReq{H: base})
+			err = store.SetStatus(dm, base, StatusDeleted)
+			So(err, ShouldBeNil)
+			So(store.Exists(base, StatusDefault), ShouldEqual, ErrHashDeleted)
+			So(store.Exists(base, StatusLive), ShouldEqual, ErrHashNotFound)
+			So(store.Exists(base, StatusDeleted), ShouldBeNil)
+			So(store.SetStatus(dm, mod, StatusDeleted), ShouldEqual, ErrHashNotFound)
+
+			puts, err := store.GetPuts(2)
+			So(err, ShouldBeNil)
+			So(len(puts), ShouldEqual, 4)
+			So(puts[0].Idx, ShouldEqual, 2)
+			So(puts[3].M.Type, ShouldEqual, DEL_REQUEST)
+
+			f, _ := puts[0].M.Fingerprint()
+			So(store.PrunePuts([]int{2}), ShouldBeNil)
+			_, err = store.GetIdxMessage(2)
+			So(err, ShouldEqual, ErrNoSuchIdx)
+			idx, err := store.GetFingerprint(f)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 2)
+			puts, err = store.GetPuts(2)
+			So(err, ShouldBeNil)
+			So(len(puts), ShouldEqual, 3)
+		})
+
+		Convey(fmt.Sprintf("%s store should track gossipers and peer lists", storeType), t, func() {
+			pid := h.node.HashAddr
+			err := store.UpdateGossiper(pid, 3)
+			So(err, ShouldBeNil)
+			err = store.UpdateGossiper(pid, 2)
+			So(err, ShouldBeNil)
+			idx, err := store.GetGossiper(pid)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 3)
+			glist, err := store.GetGossipers()
+			So(err, ShouldBeNil)
+			So(len(glist), ShouldEqual, 1)
+			So(store.DeleteGossiper(pid), ShouldBeNil)
+			idx, err = store.GetGossiper(pid)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 0)
+
+			pl := PeerList{Type: BlockedList, Records: []PeerRecord{{ID: pid, Warrant: "bad"}}}
+			err = store.AddToList(nil, pl)
+			So(err, ShouldBeNil)
+			list, err := store.GetList(BlockedList)
+			So(err, ShouldBeNil)
+			So(list.Records[0].ID, ShouldEqual, pid)
+			So(list.Records[0].Warrant, ShouldEqual, "bad")
+		})
+
+		Convey(fmt.Sprintf("%s store should iterate over its values", storeType), t, func() {
+			var records []DHTRecord
+			err := store.Iterate(func(r DHTRecord) bool {
+				records = append(records, r)
+				return true
+			})
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 1)
+			So(records[0].Key, ShouldEqual, base.String())
+			So(records[0].Status, ShouldEqual, StatusDeleted)
+			So(len(records[0].Links), ShouldEqual, 2)
+		})
+
+		Convey(fmt.Sprintf("%s store should replace values", storeType), t, func() {
+			err := store.SetValue(base, []byte("another value"))
+			So(err, ShouldBeNil)
+			data, entryType, _, status, _ := store.Get(base, StatusAny, GetMaskAll)
+			So(string(data), ShouldEqual, "another value")
+			So(entryType, ShouldEqual, "someType")
+			So(status, ShouldEqual, StatusDeleted)
+			So(store.SetValue(link, []byte("x")), ShouldEqual, ErrHashNotFound)
+		})
+
+		Convey(fmt.Sprintf("%s store should purge values and deleted links", storeType), t, func() {
+			err := store.PurgeDeletedLinks(base)
+			So(err, ShouldBeNil)
+			var records []DHTRecord
+			store.Iterate(func(r DHTRecord) bool {
+				records = append(records, r)
+				return true
+			})
+			So(len(records[0].Links), ShouldEqual, 1)
+			So(records[0].Links[0].Tag, ShouldEqual, SysTagReplacedBy)
+
+			err = store.Purge(base)
+			So(err, ShouldBeNil)
+			So(store.Exists(base, StatusAny), ShouldEqual, ErrHashNotFound)
+			records = nil
+			store.Iterate(func(r DHTRecord) bool {
+				records = append(records, r)
+				return true
+			})
+			So(len(records), ShouldEqual, 0)
+
+			// the put index is kept for gossip
+			f, _ := m.Fingerprint()
+			idx, err := store.GetFingerprint(f)
+			So(err, ShouldBeNil)
+			So(idx, ShouldEqual, 1)
+		})
+
+		Convey(fmt.Sprintf("%s store should record header successors", storeType), t, func() {
+			_, err := store.GetSuccessor(id, link)
+			So(err, ShouldEqual, ErrHashNotFound)
+			So(store.PutSuccessor(id, link, m), ShouldBeNil)
+			s, err := store.GetSuccessor(id, link)
+			So(err, ShouldBeNil)
+			So(s.Body.(PutReq).H.String(), ShouldEqual, base.String())
+			So(s.Verify(), ShouldBeNil)
+			_, err = store.GetSuccessor(id, mod)
+			So(err, ShouldEqual, ErrHashNotFound)
+
+			// only the latest MaxSuccessors are kept
+			defer func(n int) { MaxSuccessors = n }(MaxSuccessors)
+			MaxSuccessors = 1
+			So(store.PutSuccessor(id, mod, m), ShouldBeNil)
+			_, err = store.GetSuccessor(id, mod)
+			So(err, ShouldBeNil)
+			_, err = store.GetSuccessor(id, link)
+			So(err, ShouldEqual, ErrHashNotFound)
+		})
+
+		store.Close()
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// subscriptions to changes in the local DHT store, so that a UI can be pushed new links,
+// mods and deletes rather than having to poll for them.  Events are sent as the store is
+// changed, and a subscriber that falls behind loses events rather than holding up the DHT.
+
+package holochain
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+)
+
+const (
+	DHTEventPut  = "put"
+	DHTEventMod  = "mod"
+	DHTEventDel  = "del"
+	DHTEventLink = "link"
+)
+
+// DHTSubscriptionBuffer is how many events a subscription holds for its subscriber before
+// further ones are dropped
+var DHTSubscriptionBuffer = 100
+
+// DHTSubscription selects the events a subscriber is sent
+type DHTSubscription struct {
+	Hash string // of the entry, or of the base for links
+	Tag  string // only links with this tag, or all links if empty
+}
+
+// DHTEvent is a change to the local DHT store.  The embedded LinkEvent holds the new
+// status and its source, and for links the hash of the entry that made them.
+type DHTEvent struct {
+	Type      string // one of DHTEventPut, DHTEventMod, DHTEventDel or DHTEventLink
+	Hash      string // of the entry changed, or of the base linked on
+	NewHash   string `json:",omitempty"` // of the entry a mod replaced it with
+	EntryType string `json:",omitempty"`
+	Link      string `json:",omitempty"`
+	Tag       string `json:",omitempty"`
+	LinkEvent
+}
+
+// subscription is a DHTSubscription along with where its events go
+type subscription struct {
+	DHTSubscription
+	events chan DHTEvent
+}
+
+// matches returns true if an event is one the subscription selects
+func (s *subscription) matches(e *DHTEvent) bool {
+	if e.Hash != s.Hash {
+		return false
+	}
+	return e.Type != DHTEventLink || s.Tag == "" || s.Tag == e.Tag
+}
+
+// Subscribe starts sending the events selected by a subscription to the returned channel,
+// which is closed by Unsubscribe or when the DHT is closed
+func (dht *DHT) Subscribe(sub DHTSubscription) (id int, events <-chan DHTEvent) {
+	dht.sublk.Lock()
+	defer dht.sublk.Unlock()
+	if dht.subs == nil {
+		dht.subs = make(map[int]*subscription)
+	}
+	dht.nextSub++
+	id = dht.nextSub
+	s := &subscription{DHTSubscription: sub, events: make(chan DHTEvent, DHTSubscriptionBuffer)}
+	dht.subs[id] = s
+	events = s.events
+	return
+}
+
+// Unsubscribe stops a subscription and closes its channel
+func (dht *DHT) Unsubscribe(id int) {
+	dht.sublk.Lock()
+	defer dht.sublk.Unlock()
+	if s, ok := dht.subs[id]; ok {
+		close(s.events)
+		delete(dht.subs, id)
+	}
+}
+
+// unsubscribeAll stops all the subscriptions
+func (dht *DHT) unsubscribeAll() {
+	dht.sublk.Lock()
+	defer dht.sublk.Unlock()
+	for id, s := range dht.subs {
+		close(s.events)
+		delete(dht.subs, id)
+	}
+}
+
+// notify sends an event to the subscriptions that select it
+func (dht *DHT) notify(e DHTEvent) {
+	dht.sublk.RLock()
+	defer dht.sublk.RUnlock()
+	for id, s := range dht.subs {
+		if !s.matches(&e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			dht.dlog.Logf("subscription %d is full, dropping %s event on %s", id, e.Type, e.Hash)
+		}
+	}
+}
+
+// notifyChange sends the event for a change to an entry's status
+func (dht *DHT) notifyChange(eventType string, key Hash, newKey string, src peer.ID, status int) {
+	dht.notify(DHTEvent{
+		Type:      eventType,
+		Hash:      key.String(),
+		NewHash:   newKey,
+		LinkEvent: LinkEvent{Status: status, Source: peer.IDB58Encode(src)},
+	})
+}
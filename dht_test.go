@@ -159,6 +159,59 @@ func TestPutGetModDel(t *testing.T) {
 	})
 }
 
+func TestDHTSubscribe(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	dht := h.dht
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	newhash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+	linkHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	linkingEntryHash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+
+	id, events := dht.Subscribe(DHTSubscription{Hash: hash.String(), Tag: "4stars"})
+	_, others := dht.Subscribe(DHTSubscription{Hash: newhash.String()})
+
+	Convey("it should send the events on the subscribed hash", t, func() {
+		err := dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}), "someType", hash, h.nodeID, []byte("some value"), StatusLive)
+		So(err, ShouldBeNil)
+		e := <-events
+		So(e.Type, ShouldEqual, DHTEventPut)
+		So(e.Hash, ShouldEqual, hash.String())
+		So(e.EntryType, ShouldEqual, "someType")
+		So(e.Status, ShouldEqual, StatusLive)
+		So(e.Source, ShouldEqual, h.nodeIDStr)
+
+		m := h.node.NewMessage(LINK_REQUEST, LinkReq{Base: hash, Links: linkingEntryHash})
+		So(dht.putLink(m, hash.String(), linkHash.String(), "3stars"), ShouldBeNil)
+		So(dht.putLink(m, hash.String(), linkHash.String(), "4stars"), ShouldBeNil)
+		e = <-events
+		So(e.Type, ShouldEqual, DHTEventLink)
+		So(e.Link, ShouldEqual, linkHash.String())
+		So(e.Tag, ShouldEqual, "4stars")
+		So(e.LinksEntry, ShouldEqual, linkingEntryHash.String())
+
+		So(dht.mod(h.node.NewMessage(MOD_REQUEST, hash), hash, newhash), ShouldBeNil)
+		e = <-events
+		So(e.Type, ShouldEqual, DHTEventMod)
+		So(e.NewHash, ShouldEqual, newhash.String())
+		So(e.Status, ShouldEqual, StatusModified)
+
+		So(dht.del(h.node.NewMessage(DEL_REQUEST, hash), hash), ShouldBeNil)
+		e = <-events
+		So(e.Type, ShouldEqual, DHTEventDel)
+		So(e.Status, ShouldEqual, StatusDeleted)
+
+		So(len(others), ShouldEqual, 0)
+	})
+
+	Convey("unsubscribe should close the subscription", t, func() {
+		dht.Unsubscribe(id)
+		_, ok := <-events
+		So(ok, ShouldBeFalse)
+	})
+}
+
 func TestLinking(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
@@ -198,7 +251,7 @@ func TestLinking(t *testing.T) {
 	Convey("Low level should add linking events to buntdb", t, func() {
 		err := dht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusLive)
 		So(err, ShouldBeNil)
-		err = dht.db.View(func(tx *buntdb.Tx) error {
+		err = dht.db.(*BuntDBStore).db.View(func(tx *buntdb.Tx) error {
 			err = tx.Ascend("link", func(key, value string) bool {
 				So(key, ShouldEqual, fmt.Sprintf(`link:%s:%s:link test`, baseStr, linkHash1Str))
 				So(value, ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s"}]`, StatusLive, h.nodeIDStr, linkingEntryHashStr))
@@ -209,7 +262,7 @@ func TestLinking(t *testing.T) {
 
 		err = dht.link(fakeMsg, baseStr, linkHash1Str, "link test", StatusDeleted)
 		So(err, ShouldBeNil)
-		err = dht.db.View(func(tx *buntdb.Tx) error {
+		err = dht.db.(*BuntDBStore).db.View(func(tx *buntdb.Tx) error {
 			err = tx.Ascend("link", func(key, value string) bool {
 				So(value, ShouldEqual, fmt.Sprintf(`[{"Status":%d,"Source":"%s","LinksEntry":"%s"},{"Status":%d,"Source":"%s","LinksEntry":"%s"}]`, StatusLive, h.nodeIDStr, linkingEntryHashStr, StatusDeleted, h.nodeIDStr, linkingEntryHashStr))
 				return true
@@ -296,6 +349,42 @@ func TestLinking(t *testing.T) {
 	})
 }
 
+func TestPageLinks(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+	dht := h.dht
+
+	links := []TaggedHash{{H: "QmC", T: "x"}, {H: "QmA", T: "x"}, {H: "QmB", T: "x"}, {H: "QmA", T: "y"}}
+
+	Convey("pageLinks should return everything without a limit or MaxLinkSets", t, func() {
+		page, next := dht.pageLinks(links, "", "", 0)
+		So(len(page), ShouldEqual, 4)
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("pageLinks should return pages in order with a cursor to the next page", t, func() {
+		page, next := dht.pageLinks(links, "", "", 3)
+		So(fmt.Sprintf("%v", page), ShouldEqual, "[{QmA   x } {QmA   y } {QmB   x }]")
+		So(next, ShouldEqual, "QmB:x")
+		page, next = dht.pageLinks(links, "", next, 3)
+		So(fmt.Sprintf("%v", page), ShouldEqual, "[{QmC   x }]")
+		So(next, ShouldEqual, "")
+	})
+
+	Convey("pageLinks should cap pages at MaxLinkSets", t, func() {
+		dht.config.MaxLinkSets = 2
+		defer func() { dht.config.MaxLinkSets = 0 }()
+		page, next := dht.pageLinks(links, "", "", 0)
+		So(len(page), ShouldEqual, 2)
+		So(next, ShouldEqual, "QmA:y")
+		page, next = dht.pageLinks(links, "", "", 10)
+		So(len(page), ShouldEqual, 2)
+		page, next = dht.pageLinks(links, "", "", 1)
+		So(len(page), ShouldEqual, 1)
+		So(next, ShouldEqual, "QmA:x")
+	})
+}
+
 func TestDHTSend(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
@@ -450,6 +539,68 @@ func TestDHTKadPut(t *testing.T) {
 	})
 }
 
+func TestDHTNeighborhood(t *testing.T) {
+	nodesCount := 6
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h := nodes[0]
+
+	starConnect(t, mt.ctx, nodes, nodesCount)
+
+	// calculate which of the other nodes' hashes fall in our neighborhood
+	inNeighborhood := func(key Hash, ns int) bool {
+		ids := []peer.ID{}
+		for _, n := range nodes {
+			ids = append(ids, n.nodeID)
+		}
+		sorted := SortClosestPeers(ids, key)
+		for i := 0; i < ns; i++ {
+			if sorted[i] == h.nodeID {
+				return true
+			}
+		}
+		return false
+	}
+
+	Convey("with a neighborhood size of 0 everything should be in the neighborhood", t, func() {
+		So(h.nucleus.dna.DHTConfig.NeighborhoodSize, ShouldEqual, 0)
+		for i := 1; i < nodesCount; i++ {
+			So(h.dht.isInNeighborhood(HashFromPeerID(nodes[i].nodeID)), ShouldBeTrue)
+		}
+	})
+
+	h.nucleus.dna.DHTConfig.NeighborhoodSize = 2
+	defer func() { h.nucleus.dna.DHTConfig.NeighborhoodSize = 0 }()
+
+	Convey("with sharding only the NeighborhoodSize closest nodes should be in the neighborhood", t, func() {
+		So(h.dht.isInNeighborhood(HashFromPeerID(h.nodeID)), ShouldBeTrue)
+		for i := 1; i < nodesCount; i++ {
+			key := HashFromPeerID(nodes[i].nodeID)
+			So(h.dht.isInNeighborhood(key), ShouldEqual, inNeighborhood(key, 2))
+		}
+	})
+
+	Convey("a PUT_REQUEST outside of the neighborhood should not be held", t, func() {
+		var key Hash
+		for i := 1; i < nodesCount; i++ {
+			k := HashFromPeerID(nodes[i].nodeID)
+			if !inNeighborhood(k, 2) {
+				key = k
+				break
+			}
+		}
+		So(key.H, ShouldNotBeNil)
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: key})
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		resp, ok := r.(CloserPeersResp)
+		So(ok, ShouldBeTrue)
+		So(len(resp.CloserPeers), ShouldBeGreaterThan, 0)
+		So(h.dht.exists(key, StatusAny), ShouldEqual, ErrHashNotFound)
+	})
+}
+
 func TestActionReceiver(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
@@ -549,8 +700,10 @@ func TestActionReceiver(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
-		<-h.dht.retryQueue // unload the queue
+		retries, _ := h.dht.getRetries()
+		So(len(retries), ShouldEqual, 1)
+		f, _ := m.Fingerprint()
+		h.dht.db.DeleteRetry(f) // unload the queue
 	})
 
 	Convey("GETLINK_REQUEST should retrieve link values", t, func() {
@@ -585,6 +738,31 @@ func TestActionReceiver(t *testing.T) {
 		So(l4star.T, ShouldEqual, "4stars")
 	})
 
+	Convey("GETLINK_REQUEST with a tag prefix or tags should retrieve the matching links", t, func() {
+		mq := LinkQuery{Base: hash, TagPrefix: "4"}
+		m := h.node.NewMessage(GETLINK_REQUEST, mq)
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results := r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Links[0].H, ShouldEqual, hd.EntryLink.String())
+		So(results.Links[0].T, ShouldEqual, "4stars")
+
+		mq = LinkQuery{Base: hash, Tags: []string{"3stars", "5stars"}}
+		m = h.node.NewMessage(GETLINK_REQUEST, mq)
+		r, err = ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		results = r.(*LinkQueryResp)
+		So(len(results.Links), ShouldEqual, 1)
+		So(results.Links[0].T, ShouldEqual, "3stars")
+
+		mq = LinkQuery{Base: hash, TagPrefix: "5"}
+		m = h.node.NewMessage(GETLINK_REQUEST, mq)
+		r, err = ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		So(len(r.(*LinkQueryResp).Links), ShouldEqual, 0)
+	})
+
 	Convey("GOSSIP_REQUEST should request and advertise data by idx", t, func() {
 		g := GossipReq{MyIdx: 1, YourIdx: 2}
 		m := h.node.NewMessage(GOSSIP_REQUEST, g)
@@ -626,8 +804,10 @@ func TestActionReceiver(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
-		<-h.dht.retryQueue // unload the queue
+		retries, _ := h.dht.getRetries()
+		So(len(retries), ShouldEqual, 1)
+		f, _ := m.Fingerprint()
+		h.dht.db.DeleteRetry(f) // unload the queue
 	})
 
 	// put a second entry to DHT
@@ -667,7 +847,8 @@ func TestActionReceiver(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
+		retries, _ := h.dht.getRetries()
+		So(len(retries), ShouldEqual, 1)
 	})
 
 	Convey("LISTADD_REQUEST with bad warrant should return error", t, func() {
@@ -776,6 +957,9 @@ func TestDHTDump(t *testing.T) {
 func TestDHTRetry(t *testing.T) {
 	d, _, h := PrepareTestChain("test")
 	defer CleanupTestChain(h, d)
+	backoff := RetryBackoff
+	RetryBackoff = 0
+	defer func() { RetryBackoff = backoff }()
 
 	d1 := `{"firstName":"Zippy","lastName":"Pinhead"}`
 	e := GobEntry{C: d1}
@@ -831,12 +1015,182 @@ func TestDHTRetry(t *testing.T) {
 		r, err := ActionReceiver(h, m)
 		So(err, ShouldBeNil)
 		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
-		So(len(h.dht.retryQueue), ShouldEqual, 1)
+		retries, _ := h.dht.getRetries()
+		So(len(retries), ShouldEqual, 1)
 
 		interval := time.Millisecond * 10
 		h.node.retrying = h.TaskTicker(interval, RetryTask)
 		time.Sleep(interval * (MaxRetries + 2))
-		So(len(h.dht.retryQueue), ShouldEqual, 0)
+		retries, _ = h.dht.getRetries()
+		So(len(retries), ShouldEqual, 0)
+
+		stop := h.node.retrying
+		h.node.retrying = nil
+		stop <- true
+	})
+
+	Convey("retries should back off", t, func() {
+		RetryBackoff = time.Second
+		So(retryBackoff(MaxRetries), ShouldEqual, time.Second)
+		So(retryBackoff(MaxRetries-2), ShouldEqual, 4*time.Second)
+		So(retryBackoff(0), ShouldEqual, MaxRetryBackoff)
+
+		e5 := GobEntry{C: `{"firstName":"Zeppy","lastName":"Pinhead"}`}
+		hash5, _ := e5.Sum(h.hashSpec)
+		m := h.node.NewMessage(LINK_REQUEST, LinkReq{Base: hash5, Links: hash5})
+		start := time.Now()
+		r, err := ActionReceiver(h, m)
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, DHTChangeUnknownHashQueuedForRetry)
+
+		// not due yet so the retry task should leave it alone
+		RetryTask(h)
+		retries, _ := h.dht.getRetries()
+		So(len(retries), ShouldEqual, 1)
+		So(retries[0].Retries, ShouldEqual, MaxRetries)
+		So(retries[0].Next.After(start), ShouldBeTrue)
+		RetryBackoff = 0
+	})
+
+	Convey("retries should survive a restart", t, func() {
+		h.dht.Close()
+		h.dht = NewDHT(h)
+		retries, err := h.dht.getRetries()
+		So(err, ShouldBeNil)
+		So(len(retries), ShouldEqual, 1)
+		So(retries[0].Msg.Type, ShouldEqual, LINK_REQUEST)
+
+		err = h.dht.Start()
+		So(err, ShouldBeNil)
+		retries, _ = h.dht.getRetries()
+		So(retries[0].Next.After(time.Now()), ShouldBeFalse)
+
+		s := h.dht.String()
+		So(s, ShouldContainSubstring, "DHT retries: 1\n")
+	})
+}
+
+func TestDHTGC(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	for _, z := range h.nucleus.dna.Zomes {
+		for i := range z.Entries {
+			if z.Entries[i].Name == "evenNumbers" {
+				z.Entries[i].TTL = 60
+			}
+		}
+	}
+	h.dht.config.TombstoneRetention = 120
+
+	e1 := GobEntry{C: "2"}
+	hash1, _ := e1.Sum(h.hashSpec)
+	e2 := GobEntry{C: `{"firstName":"Zippy","lastName":"Pinhead"}`}
+	hash2, _ := e2.Sum(h.hashSpec)
+	e3 := GobEntry{C: `{"firstName":"Zerbina","lastName":"Pinhead"}`}
+	hash3, _ := e3.Sum(h.hashSpec)
+
+	m1 := h.node.NewMessage(PUT_REQUEST, PutReq{H: hash1})
+	h.dht.put(m1, "evenNumbers", hash1, h.nodeID, []byte("2"), StatusLive)
+	h.dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash2}), "profile", hash2, h.nodeID, []byte(e2.C.(string)), StatusLive)
+	h.dht.del(h.node.NewMessage(DEL_REQUEST, DelReq{H: hash2, By: hash2}), hash2)
+	h.dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash3}), "profile", hash3, h.nodeID, []byte(e3.C.(string)), StatusLive)
+	lm := h.node.NewMessage(LINK_REQUEST, LinkReq{Base: hash3, Links: hash1})
+	h.dht.putLink(lm, hash3.String(), hash1.String(), "tag")
+	h.dht.delLink(lm, hash3.String(), hash1.String(), "tag")
+
+	now := time.Now()
+
+	Convey("nothing should be purged before it expires", t, func() {
+		purged, err := h.dht.gc(now)
+		So(err, ShouldBeNil)
+		So(purged, ShouldEqual, 0)
+	})
+
+	Convey("entries should be purged after their TTL", t, func() {
+		purged, err := h.dht.gc(now.Add(61 * time.Second))
+		So(err, ShouldBeNil)
+		So(purged, ShouldEqual, 1)
+		So(h.dht.exists(hash1, StatusAny), ShouldEqual, ErrHashNotFound)
+		So(h.dht.exists(hash2, StatusDeleted), ShouldBeNil)
+
+		// the put is pruned from the index but its fingerprint stays so gossip won't bring
+		// it back
+		f, _ := m1.Fingerprint()
+		have, err := h.dht.HaveFingerprint(f)
+		So(err, ShouldBeNil)
+		So(have, ShouldBeTrue)
+		idx, _ := h.dht.GetFingerprint(f)
+		_, err = h.dht.GetIdxMessage(idx)
+		So(err, ShouldEqual, ErrNoSuchIdx)
+		So(h.dht.gcTimes.idxs[hash1.String()], ShouldBeNil)
+	})
+
+	Convey("tombstones should be purged after the retention period", t, func() {
+		purged, err := h.dht.gc(now.Add(121 * time.Second))
+		So(err, ShouldBeNil)
+		So(purged, ShouldEqual, 2)
+		So(h.dht.exists(hash2, StatusAny), ShouldEqual, ErrHashNotFound)
+		So(h.dht.exists(hash3, StatusLive), ShouldBeNil)
+		_, err = h.dht.db.GetLinks(hash3, "", StatusAny)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("expired puts should be recognized", t, func() {
+		So(h.dht.expired("evenNumbers", now.Add(-61*time.Second), now), ShouldBeTrue)
+		So(h.dht.expired("evenNumbers", now, now), ShouldBeFalse)
+		So(h.dht.expired("profile", now.Add(-61*time.Second), now), ShouldBeFalse)
+	})
+}
+
+func TestDHTLimits(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	hash, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	hash2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+
+	Convey("it should refuse to store entries larger than MaxEntrySize", t, func() {
+		h.dht.config.MaxEntrySize = 4
+		err := h.dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}), "evenNumbers", hash, h.nodeID, []byte("12345"), StatusLive)
+		So(err, ShouldEqual, ErrEntryTooLarge)
+		h.dht.config.MaxEntrySize = 0
+		err = h.dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash}), "evenNumbers", hash, h.nodeID, []byte("12345"), StatusLive)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("it should count the bytes stored", t, func() {
+		size, err := h.dht.storedSize()
+		So(err, ShouldBeNil)
+		So(size, ShouldBeGreaterThan, 5)
+		h.dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash2}), "evenNumbers", hash2, h.nodeID, []byte("123"), StatusLive)
+		size2, _ := h.dht.storedSize()
+		So(size2, ShouldEqual, size+3)
+
+		// putting a value again only counts the difference
+		h.dht.put(h.node.NewMessage(PUT_REQUEST, PutReq{H: hash2}), "evenNumbers", hash2, h.nodeID, []byte("12345"), StatusLive)
+		size3, _ := h.dht.storedSize()
+		So(size3, ShouldEqual, size+5)
+	})
+
+	Convey("it should refuse new holdings when over quota", t, func() {
+		size, _ := h.dht.storedSize()
+		h.Config.StorageQuota = size
+		So(h.dht.checkQuota(hash), ShouldBeNil)
+		h3, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+		So(h.dht.checkQuota(h3), ShouldEqual, ErrStorageQuota)
+		m := h.node.NewMessage(PUT_REQUEST, PutReq{H: h3})
+		_, err := ActionReceiver(h, m)
+		So(err, ShouldEqual, ErrStorageQuota)
+		h.Config.StorageQuota = size + 1
+		So(h.dht.checkQuota(h3), ShouldBeNil)
+		h.Config.StorageQuota = 0
+	})
+
+	Convey("the limit errors should go over the wire", t, func() {
+		for _, e := range []error{ErrEntryTooLarge, ErrStorageQuota} {
+			So(NewErrorResponse(e).DecodeResponseError(), ShouldEqual, e)
+		}
 	})
 }
 
@@ -896,4 +1250,51 @@ func TestDHTMultiNode(t *testing.T) {
 			}
 		}
 	})
+
+	// link every node's statement onto the first node's key with a tag of its own
+	base := nodes[0].nodeIDStr
+	for i := 0; i < nodesCount; i++ {
+		commit(nodes[i], "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"statement-%d"}]}`, base, hashes[i].String(), i))
+	}
+
+	Convey("each node should be able to query the links on a base by tag across the neighborhood", t, func() {
+		for j := 0; j < nodesCount; j++ {
+			options := QueryLinksOptions{TagPrefix: "statement-"}
+			response, err := NewQueryLinksAction(HashFromPeerID(nodes[0].nodeID), &options).Do(nodes[j])
+			So(err, ShouldBeNil)
+			links := response.(*LinkQueryResp).Links
+			So(len(links), ShouldEqual, nodesCount)
+			tags := make(map[string]bool)
+			for _, l := range links {
+				tags[l.T] = true
+			}
+			So(len(tags), ShouldEqual, nodesCount)
+		}
+
+		options := QueryLinksOptions{Tags: []string{"statement-1", "statement-3"}}
+		options.Load = true
+		response, err := NewQueryLinksAction(HashFromPeerID(nodes[0].nodeID), &options).Do(nodes[2])
+		So(err, ShouldBeNil)
+		links := response.(*LinkQueryResp).Links
+		So(len(links), ShouldEqual, 2)
+		So(links[0].EntryType, ShouldEqual, "review")
+	})
+
+	Convey("links queries should page through the merged links", t, func() {
+		options := QueryLinksOptions{TagPrefix: "statement-"}
+		options.PageSize = 4
+		seen := make(map[string]bool)
+		for page := 0; page < 3; page++ {
+			response, err := NewQueryLinksAction(HashFromPeerID(nodes[0].nodeID), &options).Do(nodes[1])
+			So(err, ShouldBeNil)
+			r := response.(*LinkQueryResp)
+			for _, l := range r.Links {
+				So(seen[l.T], ShouldBeFalse)
+				seen[l.T] = true
+			}
+			options.Cursor = r.Next
+		}
+		So(len(seen), ShouldEqual, nodesCount)
+		So(options.Cursor, ShouldEqual, "")
+	})
 }
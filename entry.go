@@ -23,6 +23,7 @@ const (
 
 	DNAEntryType   = SysEntryTypePrefix + "dna"
 	AgentEntryType = SysEntryTypePrefix + "agent"
+	ChunkEntryType = SysEntryTypePrefix + "chunk"
 	KeyEntryType   = VirtualEntryTypePrefix + "key" // virtual entry type, not actually on the chain
 
 	// Entry type formats
@@ -32,9 +33,11 @@ const (
 	DataFormatString   = "string"
 	DataFormatRawJS    = "js"
 	DataFormatRawZygo  = "zygo"
+	DataFormatBinary   = "binary"
 	DataFormatSysDNA   = "_DNA"
 	DataFormatSysAgent = "_agent"
 	DataFormatSysKey   = "_key"
+	DataFormatSysChunk = "_chunk"
 
 	// Entry sharing types
 
@@ -75,12 +78,15 @@ type EntryDef struct {
 	DataFormat string
 	Sharing    string
 	Schema     string
+	TTL        int      // seconds entries of this type are held in the DHT after being put, 0 for ever
+	Indexes    []string // JSON fields the local chain is indexed on for queries
 	validator  SchemaValidator
 }
 
 var DNAEntryDef = &EntryDef{Name: DNAEntryType, DataFormat: DataFormatSysDNA}
 var AgentEntryDef = &EntryDef{Name: AgentEntryType, DataFormat: DataFormatSysAgent}
 var KeyEntryDef = &EntryDef{Name: KeyEntryType, DataFormat: DataFormatSysKey}
+var ChunkEntryDef = &EntryDef{Name: ChunkEntryType, DataFormat: DataFormatSysChunk, Sharing: Public}
 
 // Entry describes serialization and deserialziation of entry data
 type Entry interface {
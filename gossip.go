@@ -11,11 +11,7 @@ import (
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/metacurrency/holochain/hash"
-	"github.com/tidwall/buntdb"
-	"math/rand"
-	"sort"
-	"strconv"
-	"strings"
+	queue "github.com/metacurrency/holochain/peerqueue"
 	"time"
 )
 
@@ -57,103 +53,15 @@ var ErrDHTErrNoGossipersAvailable error = errors.New("no gossipers available")
 var ErrDHTExpectedGossipReqInBody error = errors.New("expected gossip request")
 var ErrNoSuchIdx error = errors.New("no such change index")
 
-// incIdx adds a new index record to dht for gossiping later
-func incIdx(tx *buntdb.Tx, m *Message) (index string, err error) {
-	// if message is nil we can't record this for gossiping
-	// this should only be the case for the DNA
-	if m == nil {
-		return
-	}
-
-	var idx int
-	idx, err = getIntVal("_idx", tx)
-	if err != nil {
-		return
-	}
-	idx++
-	index = fmt.Sprintf("%d", idx)
-	_, _, err = tx.Set("_idx", index, nil)
-	if err != nil {
-		return
-	}
-
-	var msg string
-
-	if m != nil {
-		var b []byte
-
-		b, err = ByteEncoder(m)
-		if err != nil {
-			return
-		}
-		msg = string(b)
-
-		var decodedMessage interface{}
-		err = ByteDecoder(b, decodedMessage)
-	}
-	_, _, err = tx.Set("idx:"+index, msg, nil)
-	if err != nil {
-		return
-	}
-
-	f, err := m.Fingerprint()
-	if err != nil {
-		return
-	}
-	_, _, err = tx.Set("f:"+f.String(), index, nil)
-	if err != nil {
-		return
-	}
-
-	return
-}
-
-// getIntVal returns an integer value at a given key, and assumes the value 0 if the key doesn't exist
-func getIntVal(key string, tx *buntdb.Tx) (idx int, err error) {
-	var val string
-	val, err = tx.Get(key)
-	if err == buntdb.ErrNotFound {
-		err = nil
-	} else if err != nil {
-		return
-	} else {
-		idx, err = strconv.Atoi(val)
-		if err != nil {
-			return
-		}
-	}
-	return
-}
-
 // GetIdx returns the current put index for gossip
 func (dht *DHT) GetIdx() (idx int, err error) {
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		var e error
-		idx, e = getIntVal("_idx", tx)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	idx, err = dht.db.GetIdx()
 	return
 }
 
 // GetIdxMessage returns the messages that causes the change at a given index
 func (dht *DHT) GetIdxMessage(idx int) (msg Message, err error) {
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		msgStr, e := tx.Get(fmt.Sprintf("idx:%d", idx))
-		if e == buntdb.ErrNotFound {
-			return ErrNoSuchIdx
-		}
-		if e != nil {
-			return e
-		}
-		e = ByteDecoder([]byte(msgStr), &msg)
-		if err != nil {
-			return e
-		}
-		return nil
-	})
+	msg, err = dht.db.GetIdxMessage(idx)
 	return
 }
 
@@ -168,79 +76,27 @@ func (dht *DHT) HaveFingerprint(f Hash) (result bool, err error) {
 
 // GetFingerprint returns the index that of the message that made a change or -1 if we don't have it
 func (dht *DHT) GetFingerprint(f Hash) (index int, err error) {
-	index = -1
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		idxStr, e := tx.Get("f:" + f.String())
-		if e == buntdb.ErrNotFound {
-			return nil
-		}
-		if e != nil {
-			return e
-		}
-		index, e = strconv.Atoi(idxStr)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	index, err = dht.db.GetFingerprint(f)
 	return
 }
 
 // GetPuts returns a list of puts after the given index
 func (dht *DHT) GetPuts(since int) (puts []Put, err error) {
-	puts = make([]Put, 0)
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		err = tx.AscendGreaterOrEqual("idx", string(since), func(key, value string) bool {
-			x := strings.Split(key, ":")
-			idx, _ := strconv.Atoi(x[1])
-			if idx >= since {
-				p := Put{Idx: idx}
-				if value != "" {
-					err := ByteDecoder([]byte(value), &p.M)
-					if err != nil {
-						return false
-					}
-				}
-				puts = append(puts, p)
-			}
-			return true
-		})
-		sort.Slice(puts, func(i, j int) bool { return puts[i].Idx < puts[j].Idx })
-		return err
-	})
+	puts, err = dht.db.GetPuts(since)
 	return
 }
 
 // GetGossiper loads returns last known index of the gossiper, and adds them if not didn't exist before
 func (dht *DHT) GetGossiper(id peer.ID) (idx int, err error) {
-	key := "peer:" + peer.IDB58Encode(id)
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		var e error
-		idx, e = getIntVal(key, tx)
-		if e != nil {
-			return e
-		}
-		return nil
-	})
+	idx, err = dht.db.GetGossiper(id)
 	return
 }
 
 func (dht *DHT) getGossipers() (glist []peer.ID, err error) {
-	glist = make([]peer.ID, 0)
-
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("peer", func(key, value string) bool {
-			x := strings.Split(key, ":")
-			id, e := peer.IDB58Decode(x[1])
-			if e != nil {
-				return false
-			}
-			//			idx, _ := strconv.Atoi(value)
-			glist = append(glist, id)
-			return true
-		})
-		return nil
-	})
+	glist, err = dht.db.GetGossipers()
+	if err != nil {
+		return
+	}
 	ns := dht.config.NeighborhoodSize
 	if ns > 1 {
 		size := len(glist)
@@ -261,7 +117,8 @@ func (dht *DHT) getGossipers() (glist []peer.ID, err error) {
 	return
 }
 
-// FindGossiper picks a random DHT node to gossip with
+// FindGossiper picks the best DHT node to gossip with, favoring nodes close to us that we
+// haven't gossiped with for a while and that haven't been failing
 func (dht *DHT) FindGossiper() (g peer.ID, err error) {
 	var glist []peer.ID
 	glist, err = dht.getGossipers()
@@ -271,11 +128,37 @@ func (dht *DHT) FindGossiper() (g peer.ID, err error) {
 	if len(glist) == 0 {
 		err = ErrDHTErrNoGossipersAvailable
 	} else {
-		g = glist[rand.Intn(len(glist))]
+		pq := queue.NewGossipPQ(HashFromPeerID(dht.h.nodeID), dht.gossipStats)
+		for _, id := range glist {
+			pq.Enqueue(id)
+		}
+		g = pq.Dequeue()
 	}
 	return
 }
 
+// gossipStats returns what we know about our past gossiping with a peer
+func (dht *DHT) gossipStats(id peer.ID) (stats queue.GossipStats) {
+	dht.gslk.RLock()
+	defer dht.gslk.RUnlock()
+	stats = dht.gstats[id]
+	return
+}
+
+// recordGossip records the outcome of gossiping with a peer for choosing gossip partners
+func (dht *DHT) recordGossip(id peer.ID, err error) {
+	dht.gslk.Lock()
+	defer dht.gslk.Unlock()
+	stats := dht.gstats[id]
+	stats.LastGossip = time.Now()
+	if err != nil {
+		stats.Failures++
+	} else {
+		stats.Failures = 0
+	}
+	dht.gstats[id] = stats
+}
+
 // AddGossiper adds a new gossiper to the gossiper store
 func (dht *DHT) AddGossiper(id peer.ID) (err error) {
 	// never add ourselves as a gossiper
@@ -288,22 +171,7 @@ func (dht *DHT) AddGossiper(id peer.ID) (err error) {
 
 // internal update gossiper function, assumes all checks have been made
 func (dht *DHT) updateGossiper(id peer.ID, newIdx int) (err error) {
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		key := "peer:" + peer.IDB58Encode(id)
-		idx, e := getIntVal(key, tx)
-		if e != nil {
-			return e
-		}
-		if newIdx < idx {
-			return nil
-		}
-		sidx := fmt.Sprintf("%d", newIdx)
-		_, _, err = tx.Set(key, sidx, nil)
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+	err = dht.db.UpdateGossiper(id, newIdx)
 	return
 }
 
@@ -322,11 +190,10 @@ func (dht *DHT) UpdateGossiper(id peer.ID, newIdx int) (err error) {
 // DeleteGossiper removes a gossiper from the database
 func (dht *DHT) DeleteGossiper(id peer.ID) (err error) {
 	dht.glog.Logf("deleting %v", id)
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		key := "peer:" + peer.IDB58Encode(id)
-		_, e := tx.Delete(key)
-		return e
-	})
+	dht.gslk.Lock()
+	delete(dht.gstats, id)
+	dht.gslk.Unlock()
+	err = dht.db.DeleteGossiper(id)
 	return
 }
 
@@ -356,7 +223,7 @@ func GossipReceiver(h *Holochain, m *Message) (response interface{}, err error)
 			if e == nil && idx < t.MyIdx {
 				dht.glog.Logf("we only have %d of %d from %v so gossiping back", idx, t.MyIdx, m.From)
 
-				pi := h.node.host.Peerstore().PeerInfo(m.From)
+				pi := h.node.peerstore.PeerInfo(m.From)
 				if len(pi.Addrs) == 0 {
 					dht.glog.Logf("NO ADDRESSES FOR PEER:%v", pi)
 				}
@@ -375,6 +242,12 @@ func GossipReceiver(h *Holochain, m *Message) (response interface{}, err error)
 				}()
 			}
 
+		case GossipRangeReq:
+			dht.glog.Logf("%v wants to compare %d fingerprint ranges", m.From, len(t.Ranges))
+			response, err = dht.gossipRangeReceive(m.From, t)
+		case GossipFetchReq:
+			dht.glog.Logf("%v wants the puts for %d fingerprints", m.From, len(t.Fingerprints))
+			response, err = dht.gossipFetchReceive(t)
 		default:
 			err = ErrDHTExpectedGossipReqInBody
 		}
@@ -393,8 +266,22 @@ func (dht *DHT) gossipWith(id peer.ID) (err error) {
 	dht.glog.Logf("starting gossipWith %v", id)
 	defer func() {
 		dht.glog.Logf("finish gossipWith %v, err=%v", id, err)
+		dht.recordGossip(id, err)
 	}()
 
+	if dht.h.Config.GossipMode == GossipModeRange {
+		var v int
+		v, err = dht.h.node.PeerSchema(dht.h.node.ctx, GossipProtocol, id, GOSSIP_REQUEST)
+		if err != nil {
+			return
+		}
+		if v >= GossipRangeSchemaVersion {
+			err = dht.gossipRangeWith(id)
+			return
+		}
+		dht.glog.Logf("%v can't range gossip so using index gossip", id)
+	}
+
 	var myIdx, yourIdx int
 	myIdx, err = dht.GetIdx()
 	if err != nil {
@@ -424,8 +311,15 @@ func (dht *DHT) gossipWith(id peer.ID) (err error) {
 		var idx int
 		for i, p := range puts {
 			idx = i + yourIdx + 1
-			// put the message into the gossip put handling queue so we can return quickly
-			dht.gossipPuts <- p
+			var ok bool
+			ok, err = dht.checkGossipPut(id, &p)
+			if err != nil {
+				return
+			}
+			if ok {
+				// put the message into the gossip put handling queue so we can return quickly
+				dht.gossipPuts <- p
+			}
 		}
 		err = dht.UpdateGossiper(id, idx)
 	} else {
@@ -441,6 +335,11 @@ func (dht *DHT) gossipPut(p Put) (err error) {
 		// dht.sources[p.M.From] = true
 		// dht.fingerprints[f.String()[2:4]] = true
 		dht.glog.Logf("PUT--%d (fingerprint: %v)", p.Idx, f)
+		key, ok := changeKey(&p.M)
+		if ok && !dht.isInNeighborhood(key) {
+			dht.glog.Logf("PUT--%d for %v not in my neighborhood, ignoring", p.Idx, key)
+			return
+		}
 		exists, e := dht.HaveFingerprint(f)
 		if !exists && e == nil {
 			dht.glog.Logf("PUT--%d calling ActionReceiver", p.Idx)
@@ -535,43 +434,13 @@ func (dht *DHT) HandleGossipPuts() (err error) {
 
 // getList returns the peer list of the given type
 func (dht *DHT) getList(listType PeerListType) (result PeerList, err error) {
-	result.Type = listType
-	result.Records = make([]PeerRecord, 0)
-	err = dht.db.View(func(tx *buntdb.Tx) error {
-		err = tx.Ascend("list", func(key, value string) bool {
-			x := strings.Split(key, ":")
-
-			if x[1] == string(listType) {
-				pid, e := peer.IDB58Decode(x[2])
-				if e != nil {
-					return false
-				}
-				r := PeerRecord{ID: pid, Warrant: value}
-				result.Records = append(result.Records, r)
-			}
-			return true
-		})
-		return nil
-	})
+	result, err = dht.db.GetList(listType)
 	return
 }
 
 // addToList adds the peers to a list
 func (dht *DHT) addToList(m *Message, list PeerList) (err error) {
 	dht.dlog.Logf("addToList %s=>%v", list.Type, list.Records)
-	err = dht.db.Update(func(tx *buntdb.Tx) error {
-		_, err = incIdx(tx, m)
-		if err != nil {
-			return err
-		}
-		for _, r := range list.Records {
-			k := peer.IDB58Encode(r.ID)
-			_, _, err = tx.Set("list:"+string(list.Type)+":"+k, r.Warrant, nil)
-			if err != nil {
-				return err
-			}
-		}
-		return err
-	})
+	err = dht.db.AddToList(m, list)
 	return
 }
@@ -0,0 +1,329 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// range gossip reconciles two nodes' DHTs by comparing hashes of ranges of the fingerprints
+// they hold, splitting ranges that differ until the differing fingerprints are found.  Unlike
+// the put index replay it doesn't depend on both nodes agreeing on each other's indexes.
+// Fingerprints we're offered but don't hold, because their changes aren't in our
+// neighborhood or the peer's garbage collection pruned them, are counted in our ranges for
+// GossipIgnoreTTL so that they aren't fetched again every round.
+
+package holochain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// constants for the gossip modes that can be set in the Config
+
+	GossipModeIndex = "index"
+	GossipModeRange = "range"
+
+	// ranges holding no more than this many fingerprints are listed rather than split
+	GossipRangeListMax = 32
+
+	// maximum number of puts to fetch in one request
+	GossipFetchMax = 100
+
+	// how long a fingerprint we were offered and don't hold is counted as if we did
+	GossipIgnoreTTL = time.Hour
+
+	hexDigits = "0123456789abcdef"
+)
+
+// FingerprintRange summarizes the fingerprints whose digests start with the hex Prefix
+type FingerprintRange struct {
+	Prefix string
+	Count  int
+	Hash   []byte
+}
+
+// GossipRangeReq asks a peer to compare its fingerprint ranges with ours
+type GossipRangeReq struct {
+	Ranges []FingerprintRange
+}
+
+// GossipRangeResp holds the prefixes of the ranges that differ and are big enough to be
+// split further, and the fingerprints from the differing ranges small enough to list
+type GossipRangeResp struct {
+	Split        []string
+	Fingerprints []string
+}
+
+// GossipFetchReq asks a peer for the puts that made the changes with the given fingerprints
+type GossipFetchReq struct {
+	Fingerprints []string
+}
+
+// fingerprintSet holds the fingerprints of a DHT sorted by the hex encoding of their digests
+type fingerprintSet struct {
+	digests      []string
+	fingerprints map[string]string // digest to fingerprint
+}
+
+// fingerprintDigest returns the hex encoded digest part of a b58 encoded fingerprint
+func fingerprintDigest(f string) (digest string, err error) {
+	var h Hash
+	h, err = NewHash(f)
+	if err != nil {
+		return
+	}
+	// skip the multihash code and length
+	digest = hex.EncodeToString(h.H[2:])
+	return
+}
+
+// getFingerprintSet loads the fingerprints of all the changes we have
+func (dht *DHT) getFingerprintSet() (set *fingerprintSet, err error) {
+	set = &fingerprintSet{fingerprints: make(map[string]string)}
+	err = dht.db.IterateFingerprints(func(f string, idx int) bool {
+		digest, e := fingerprintDigest(f)
+		if e != nil {
+			dht.glog.Logf("bad fingerprint %s: %v", f, e)
+			return true
+		}
+		set.digests = append(set.digests, digest)
+		set.fingerprints[digest] = f
+		return true
+	})
+	if err != nil {
+		return
+	}
+	dht.iglk.Lock()
+	now := time.Now()
+	for f, t := range dht.ignored {
+		if now.Sub(t) > GossipIgnoreTTL {
+			delete(dht.ignored, f)
+			continue
+		}
+		if digest, e := fingerprintDigest(f); e == nil && set.fingerprints[digest] == "" {
+			set.digests = append(set.digests, digest)
+			set.fingerprints[digest] = f
+		}
+	}
+	dht.iglk.Unlock()
+	sort.Strings(set.digests)
+	return
+}
+
+// ignoreFingerprints counts fingerprints we don't hold in our ranges for GossipIgnoreTTL
+func (dht *DHT) ignoreFingerprints(fingerprints []string) {
+	if len(fingerprints) == 0 {
+		return
+	}
+	dht.glog.Logf("ignoring %d fingerprints we won't hold", len(fingerprints))
+	dht.iglk.Lock()
+	defer dht.iglk.Unlock()
+	now := time.Now()
+	for _, f := range fingerprints {
+		dht.ignored[f] = now
+	}
+}
+
+// prefixed returns the digests that start with the prefix
+func (set *fingerprintSet) prefixed(prefix string) []string {
+	lo := sort.SearchStrings(set.digests, prefix)
+	hi := lo + sort.Search(len(set.digests)-lo, func(i int) bool { return !strings.HasPrefix(set.digests[lo+i], prefix) })
+	return set.digests[lo:hi]
+}
+
+// summarize builds the FingerprintRange for the prefix
+func (set *fingerprintSet) summarize(prefix string) (r FingerprintRange) {
+	digests := set.prefixed(prefix)
+	h := sha256.New()
+	for _, d := range digests {
+		h.Write([]byte(d))
+	}
+	r = FingerprintRange{Prefix: prefix, Count: len(digests), Hash: h.Sum(nil)}
+	return
+}
+
+// compare returns the response to a range request given our fingerprints, and whether any ranges differ
+func (set *fingerprintSet) compare(ranges []FingerprintRange) (resp GossipRangeResp, differ bool) {
+	resp.Split = make([]string, 0)
+	resp.Fingerprints = make([]string, 0)
+	for _, r := range ranges {
+		mine := set.summarize(r.Prefix)
+		if mine.Count == r.Count && string(mine.Hash) == string(r.Hash) {
+			continue
+		}
+		differ = true
+		digests := set.prefixed(r.Prefix)
+		if len(digests) <= GossipRangeListMax {
+			for _, d := range digests {
+				resp.Fingerprints = append(resp.Fingerprints, set.fingerprints[d])
+			}
+		} else {
+			resp.Split = append(resp.Split, r.Prefix)
+		}
+	}
+	return
+}
+
+// gossipRangeReceive handles a range gossip request
+func (dht *DHT) gossipRangeReceive(from peer.ID, req GossipRangeReq) (response interface{}, err error) {
+	var set *fingerprintSet
+	set, err = dht.getFingerprintSet()
+	if err != nil {
+		return
+	}
+	resp, differ := set.compare(req.Ranges)
+	response = resp
+
+	// if the requester has more than we do then it has things we don't, so gossip back
+	if differ && len(req.Ranges) == 1 && req.Ranges[0].Prefix == "" && req.Ranges[0].Count > len(set.digests) {
+		dht.glog.Logf("%v has %d fingerprints to our %d so gossiping back", from, req.Ranges[0].Count, len(set.digests))
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					// ignore writes past close
+				}
+			}()
+			// give them a chance to finish this exchange first
+			time.Sleep(GossipBackPutDelay * time.Duration(len(resp.Fingerprints)+len(resp.Split)))
+			dht.gchan <- gossipWithReq{from}
+		}()
+	}
+	return
+}
+
+// gossipFetchReceive handles a request for puts by fingerprint
+func (dht *DHT) gossipFetchReceive(req GossipFetchReq) (response interface{}, err error) {
+	puts := make([]Put, 0)
+	for _, fs := range req.Fingerprints {
+		var f Hash
+		f, err = NewHash(fs)
+		if err != nil {
+			return
+		}
+		var idx int
+		idx, err = dht.GetFingerprint(f)
+		if err != nil {
+			return
+		}
+		if idx < 0 {
+			continue
+		}
+		var msg Message
+		msg, err = dht.GetIdxMessage(idx)
+		if err == ErrNoSuchIdx {
+			// garbage collection pruned it, only the fingerprint is left
+			err = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		puts = append(puts, Put{Idx: idx, M: msg})
+	}
+	response = Gossip{Puts: puts}
+	return
+}
+
+// gossipRangeWith reconciles our DHT with a peer's by comparing fingerprint ranges and
+// fetching the puts for the fingerprints they have that we don't
+func (dht *DHT) gossipRangeWith(id peer.ID) (err error) {
+	var set *fingerprintSet
+	set, err = dht.getFingerprintSet()
+	if err != nil {
+		return
+	}
+
+	var missing []string
+	prefixes := []string{""}
+	for len(prefixes) > 0 {
+		req := GossipRangeReq{Ranges: make([]FingerprintRange, len(prefixes))}
+		for i, p := range prefixes {
+			req.Ranges[i] = set.summarize(p)
+		}
+		var r interface{}
+		r, err = dht.h.Send(dht.h.node.ctx, GossipProtocol, id, dht.h.node.NewMessage(GOSSIP_REQUEST, req), 0)
+		if err != nil {
+			return
+		}
+		resp, ok := r.(GossipRangeResp)
+		if !ok {
+			err = fmt.Errorf("unexpected response type to range gossip: %T", r)
+			return
+		}
+		for _, f := range resp.Fingerprints {
+			digest, e := fingerprintDigest(f)
+			if e != nil {
+				err = e
+				return
+			}
+			if _, have := set.fingerprints[digest]; !have {
+				missing = append(missing, f)
+			}
+		}
+		prefixes = make([]string, 0, len(resp.Split)*len(hexDigits))
+		for _, p := range resp.Split {
+			for _, c := range hexDigits {
+				prefixes = append(prefixes, p+string(c))
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		dht.glog.Log("no differing fingerprints found")
+		return
+	}
+	dht.glog.Logf("fetching puts for %d differing fingerprints", len(missing))
+	for start := 0; start < len(missing); start += GossipFetchMax {
+		end := start + GossipFetchMax
+		if end > len(missing) {
+			end = len(missing)
+		}
+		var r interface{}
+		msg := dht.h.node.NewMessage(GOSSIP_REQUEST, GossipFetchReq{Fingerprints: missing[start:end]})
+		r, err = dht.h.Send(dht.h.node.ctx, GossipProtocol, id, msg, 0)
+		if err != nil {
+			return
+		}
+		gossip, ok := r.(Gossip)
+		if !ok {
+			err = fmt.Errorf("unexpected response type to range fetch: %T", r)
+			return
+		}
+		// what the peer doesn't send was pruned and what isn't ours to hold would be
+		// dropped, either way there's no point asking for it again
+		unheld := make(map[string]bool)
+		for _, f := range missing[start:end] {
+			unheld[f] = true
+		}
+		for _, p := range gossip.Puts {
+			f, e := p.M.Fingerprint()
+			if e != nil {
+				continue
+			}
+			if key, ok := changeKey(&p.M); ok && !dht.isInNeighborhood(key) {
+				continue
+			}
+			delete(unheld, f.String())
+			var ok bool
+			ok, err = dht.checkGossipPut(id, &p)
+			if err != nil {
+				return
+			}
+			if ok {
+				// put the message into the gossip put handling queue so we can return quickly
+				dht.gossipPuts <- p
+			}
+		}
+		ignored := make([]string, 0, len(unheld))
+		for f := range unheld {
+			ignored = append(ignored, f)
+		}
+		dht.ignoreFingerprints(ignored)
+	}
+	return
+}
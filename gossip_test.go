@@ -1,9 +1,11 @@
 package holochain
 
 import (
+	"errors"
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/metacurrency/holochain/hash"
+	queue "github.com/metacurrency/holochain/peerqueue"
 	ma "github.com/multiformats/go-multiaddr"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
@@ -166,6 +168,34 @@ func TestGetFindGossiper(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(idx, ShouldEqual, 0)
 	})
+
+	h.node.peerstore.AddAddrs(barAddr, []ma.Multiaddr{addr}, PeerTTL)
+	dht.AddGossiper(barAddr)
+
+	Convey("FindGossiper should back off from gossipers that keep failing", t, func() {
+		dht.recordGossip(barAddr, nil)
+		dht.recordGossip(fooAddr, errors.New("some error"))
+		dht.recordGossip(fooAddr, errors.New("some error"))
+		So(dht.gossipStats(fooAddr).Failures, ShouldEqual, 2)
+		g, err := dht.FindGossiper()
+		So(err, ShouldBeNil)
+		So(g, ShouldEqual, barAddr)
+		dht.recordGossip(fooAddr, nil)
+		So(dht.gossipStats(fooAddr).Failures, ShouldEqual, 0)
+	})
+
+	Convey("FindGossiper should favor gossipers it hasn't gossiped with recently", t, func() {
+		dht.gstats[fooAddr] = queue.GossipStats{LastGossip: time.Now().Add(-time.Hour)}
+		dht.gstats[barAddr] = queue.GossipStats{LastGossip: time.Now()}
+		g, err := dht.FindGossiper()
+		So(err, ShouldBeNil)
+		So(g, ShouldEqual, fooAddr)
+		dht.gstats[fooAddr] = queue.GossipStats{LastGossip: time.Now()}
+		dht.gstats[barAddr] = queue.GossipStats{LastGossip: time.Now().Add(-time.Hour)}
+		g, err = dht.FindGossiper()
+		So(err, ShouldBeNil)
+		So(g, ShouldEqual, barAddr)
+	})
 }
 
 func TestGossipData(t *testing.T) {
@@ -494,3 +524,71 @@ func findNodeIdx(nodes []*Holochain, id peer.ID) int {
 	}
 	panic("bork!")
 }
+
+func TestGossipRange(t *testing.T) {
+	nodesCount := 2
+	mt := setupMultiNodeTesting(nodesCount)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+
+	h1 := nodes[0]
+	h2 := nodes[1]
+	h2.Config.GossipMode = GossipModeRange
+
+	commit(h1, "oddNumbers", "3")
+	commit(h1, "oddNumbers", "5")
+	commit(h1, "oddNumbers", "7")
+
+	Convey("fingerprint ranges should only differ where the fingerprints do", t, func() {
+		set1, err := h1.dht.getFingerprintSet()
+		So(err, ShouldBeNil)
+		So(len(set1.digests), ShouldEqual, 5)
+		set2, err := h2.dht.getFingerprintSet()
+		So(err, ShouldBeNil)
+		So(len(set2.digests), ShouldEqual, 2)
+
+		resp, differ := set1.compare([]FingerprintRange{set1.summarize("")})
+		So(differ, ShouldBeFalse)
+		So(len(resp.Fingerprints), ShouldEqual, 0)
+
+		resp, differ = set1.compare([]FingerprintRange{set2.summarize("")})
+		So(differ, ShouldBeTrue)
+		So(len(resp.Fingerprints), ShouldEqual, 5)
+		So(len(resp.Split), ShouldEqual, 0)
+	})
+
+	ringConnect(t, mt.ctx, mt.nodes, nodesCount)
+	Convey("range gossipWith should add the puts", t, func() {
+		err := h2.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		go h2.dht.HandleGossipPuts()
+		time.Sleep(time.Millisecond * 100)
+		puts2, _ := h2.dht.GetPuts(0)
+		So(len(puts2), ShouldEqual, 7)
+	})
+
+	commit(h1, "evenNumbers", "2")
+	Convey("range gossipWith should only add the differing puts", t, func() {
+		err := h2.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		puts2, _ := h2.dht.GetPuts(0)
+		So(len(puts2), ShouldEqual, 8)
+	})
+
+	commit(h1, "oddNumbers", "9")
+	idx, _ := h1.dht.GetIdx()
+	h1.dht.db.PrunePuts([]int{idx})
+	Convey("range gossipWith should stop asking for puts the peer pruned", t, func() {
+		err := h2.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		time.Sleep(time.Millisecond * 100)
+		puts2, _ := h2.dht.GetPuts(0)
+		So(len(puts2), ShouldEqual, 8)
+
+		set1, _ := h1.dht.getFingerprintSet()
+		set2, _ := h2.dht.getFingerprintSet()
+		_, differ := set1.compare([]FingerprintRange{set2.summarize("")})
+		So(differ, ShouldBeFalse)
+	})
+}
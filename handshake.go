@@ -0,0 +1,363 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// the handshake lets nodes running different versions of holochain work out which protocol
+// version and message schemas they have in common before exchanging messages
+
+package holochain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	"strings"
+	"time"
+)
+
+const (
+	// ProtocolVersion is the version of the wire protocol this node speaks
+	ProtocolVersion = 2
+
+	// DefaultMinProtocolVersion is the oldest protocol version a node will talk to, where
+	// version 0 are nodes from before the handshake existed
+	DefaultMinProtocolVersion = 0
+
+	// how long the result of a handshake is trusted before it's done again
+	HandshakeTTL = PeerTTL
+
+	// how long a peer that didn't know the handshake is taken to be legacy before asking it
+	// again, which is shorter as the peer may just have been upgraded
+	LegacyHandshakeTTL = time.Minute
+
+	// the GOSSIP_REQUEST schema version that added range gossip
+	GossipRangeSchemaVersion = 2
+)
+
+var ErrProtocolVersion = errors.New("incompatible protocol version")
+var ErrSchemaVersion = errors.New("message schema not supported by peer")
+
+// MsgSchemas maps message types to the version of the schema of their bodies
+type MsgSchemas map[MsgType]int
+
+// MsgSchemaVersions holds the schema versions of the messages this node sends and understands.
+// A message's version must be bumped whenever its body changes in a way older nodes can't
+// handle, and a downgrade added to msgDowngrades if the new body can be made into the old one.
+var MsgSchemaVersions = MsgSchemas{
+	PUT_REQUEST:           4, // successor announcements
+	DEL_REQUEST:           1,
+	MOD_REQUEST:           1,
+	GET_REQUEST:           1,
+	LINK_REQUEST:          1,
+	GETLINK_REQUEST:       3, // tag queries
+	DELETELINK_REQUEST:    1,
+	GOSSIP_REQUEST:        2, // range gossip
+	VALIDATE_PUT_REQUEST:  1,
+	VALIDATE_LINK_REQUEST: 1,
+	VALIDATE_DEL_REQUEST:  1,
+	VALIDATE_MOD_REQUEST:  1,
+	APP_MESSAGE:           1,
+	LISTADD_REQUEST:       1,
+	FIND_NODE_REQUEST:     1,
+	HANDSHAKE_REQUEST:     1,
+	GETMANY_REQUEST:       1,
+}
+
+// legacySchemas are the message schema versions of nodes from before the handshake
+var legacySchemas = MsgSchemas{
+	PUT_REQUEST:           1,
+	DEL_REQUEST:           1,
+	MOD_REQUEST:           1,
+	GET_REQUEST:           1,
+	LINK_REQUEST:          1,
+	GETLINK_REQUEST:       1,
+	DELETELINK_REQUEST:    1,
+	GOSSIP_REQUEST:        1,
+	VALIDATE_PUT_REQUEST:  1,
+	VALIDATE_LINK_REQUEST: 1,
+	VALIDATE_DEL_REQUEST:  1,
+	VALIDATE_MOD_REQUEST:  1,
+	APP_MESSAGE:           1,
+	LISTADD_REQUEST:       1,
+	FIND_NODE_REQUEST:     1,
+}
+
+// msgSchema identifies a version of a message type's schema
+type msgSchema struct {
+	Type    MsgType
+	Version int
+}
+
+// msgDowngrades holds the functions that convert a message body from the given schema
+// version to the previous one
+var msgDowngrades = map[msgSchema]func(body interface{}) (interface{}, error){
+	{PUT_REQUEST, 2}: func(body interface{}) (interface{}, error) {
+		if r, ok := body.(PutReq); ok {
+			r.Header = nil
+			return r, nil
+		}
+		return body, nil
+	},
+	{PUT_REQUEST, 3}: func(body interface{}) (interface{}, error) {
+		// older nodes would only redirect an overflow put
+		if r, ok := body.(PutReq); ok && r.Overflow {
+			return nil, ErrSchemaVersion
+		}
+		return body, nil
+	},
+	{PUT_REQUEST, 4}: func(body interface{}) (interface{}, error) {
+		// older nodes would put the entry in the neighborhood of the header it follows
+		if r, ok := body.(PutReq); ok && r.Successor {
+			return nil, ErrSchemaVersion
+		}
+		return body, nil
+	},
+	{GETLINK_REQUEST, 2}: func(body interface{}) (interface{}, error) {
+		q, ok := body.(LinkQuery)
+		if ok && (q.Limit != 0 || q.Cursor != "") {
+			return nil, ErrSchemaVersion
+		}
+		return body, nil
+	},
+	{GETLINK_REQUEST, 3}: func(body interface{}) (interface{}, error) {
+		if q, ok := body.(LinkQuery); ok && q.tagQuery() {
+			return nil, ErrSchemaVersion
+		}
+		return body, nil
+	},
+	{GOSSIP_REQUEST, 2}: func(body interface{}) (interface{}, error) {
+		if _, ok := body.(GossipReq); !ok {
+			return nil, ErrSchemaVersion
+		}
+		return body, nil
+	},
+}
+
+// Handshake holds what a node tells its peers about the protocols it speaks
+type Handshake struct {
+	Version    int
+	MinVersion int
+	Schemas    MsgSchemas
+	Codecs     map[int][]string // the codecs the node reads for each protocol
+}
+
+// peerProtocol holds what we know about the protocols a peer speaks
+type peerProtocol struct {
+	Version int
+	Schemas MsgSchemas
+	Codecs  map[int][]string
+	at      time.Time
+}
+
+// handshake returns this node's handshake
+func (node *Node) handshake() Handshake {
+	codecs := make(map[int][]string)
+	for proto, names := range node.codecs {
+		codecs[proto] = names
+	}
+	return Handshake{Version: ProtocolVersion, MinVersion: node.minProtocolVersion, Schemas: MsgSchemaVersions, Codecs: codecs}
+}
+
+// checkHandshake returns an error if we can't talk to a node with the given handshake
+func (node *Node) checkHandshake(hs Handshake) (err error) {
+	if hs.Version < node.minProtocolVersion || ProtocolVersion < hs.MinVersion {
+		err = ErrProtocolVersion
+	}
+	return
+}
+
+// setPeerProtocol records the protocol version, message schemas and codecs of a peer
+func (node *Node) setPeerProtocol(id peer.ID, version int, schemas MsgSchemas, codecs map[int][]string) {
+	node.vlk.Lock()
+	defer node.vlk.Unlock()
+	node.protocolVersions[id] = &peerProtocol{Version: version, Schemas: schemas, Codecs: codecs, at: time.Now()}
+}
+
+// peerProtocol returns what we know about the protocols a peer speaks, if it's not too old
+func (node *Node) peerProtocol(id peer.ID) (p *peerProtocol, ok bool) {
+	node.vlk.RLock()
+	defer node.vlk.RUnlock()
+	p, ok = node.protocolVersions[id]
+	if ok {
+		ttl := HandshakeTTL
+		if p.Version == 0 {
+			ttl = LegacyHandshakeTTL
+		}
+		if time.Since(p.at) > ttl {
+			p, ok = nil, false
+		}
+	}
+	return
+}
+
+// noHandshakeHandler returns true if an error response to a handshake is the one nodes from
+// before the handshake give for message types their protocols don't handle
+func noHandshakeHandler(errResp ErrorResponse) bool {
+	return errResp.Code == ErrUnknownCode &&
+		strings.HasPrefix(errResp.Message, fmt.Sprintf("message type %d not in ", int(HANDSHAKE_REQUEST)))
+}
+
+// negotiate returns the protocols a peer speaks, doing a handshake with it over the given
+// protocol if we don't already know
+func (node *Node) negotiate(ctx context.Context, proto int, id peer.ID) (p *peerProtocol, err error) {
+	p, ok := node.peerProtocol(id)
+	if ok {
+		return
+	}
+	var r Message
+	// handshakes are always gob encoded as that's what every node speaks
+	r, err = node.transport.Request(ctx, id, node.protocols[proto].ID, node.NewMessage(HANDSHAKE_REQUEST, node.handshake()), gobWireCodec)
+	if err != nil {
+		return
+	}
+	if err = node.authenticate(id, &r); err != nil {
+		return
+	}
+	switch r.Type {
+	case OK_RESPONSE:
+		hs, ok := r.Body.(Handshake)
+		if !ok {
+			err = fmt.Errorf("unexpected response type to handshake: %T", r.Body)
+			return
+		}
+		if err = node.checkHandshake(hs); err != nil {
+			return
+		}
+		if hs.Version >= SignedMessagesVersion && r.Sig == nil {
+			err = ErrMessageUnsigned
+			return
+		}
+		node.setPeerProtocol(id, hs.Version, hs.Schemas, hs.Codecs)
+	case ERROR_RESPONSE:
+		errResp := r.Body.(ErrorResponse)
+		switch {
+		case noHandshakeHandler(errResp):
+			// nodes from before the handshake don't know the message
+			node.log.Logf("%v doesn't handshake, assuming legacy protocol", id)
+			if node.minProtocolVersion > 0 {
+				err = ErrProtocolVersion
+				return
+			}
+			node.setPeerProtocol(id, 0, legacySchemas, nil)
+		case errResp.Code == ErrProtocolVersionCode:
+			err = ErrProtocolVersion
+			return
+		default:
+			// some other reason to refuse, which the peer will tell us again when we send
+			// so don't record anything
+			p = &peerProtocol{Schemas: legacySchemas}
+			return
+		}
+	}
+	p, _ = node.peerProtocol(id)
+	return
+}
+
+// receiveHandshake handles a handshake from a peer
+func (node *Node) receiveHandshake(from peer.ID, m *Message) (response interface{}, err error) {
+	hs, ok := m.Body.(Handshake)
+	if !ok {
+		err = fmt.Errorf("expected handshake got %T", m.Body)
+		return
+	}
+	if err = node.checkHandshake(hs); err != nil {
+		node.log.Logf("rejecting handshake from %v: version %d", from, hs.Version)
+		return
+	}
+	if hs.Version >= SignedMessagesVersion && m.Sig == nil {
+		err = ErrMessageUnsigned
+		return
+	}
+	node.setPeerProtocol(from, hs.Version, hs.Schemas, hs.Codecs)
+	response = node.handshake()
+	return
+}
+
+// checkLegacy returns an error if a peer we've never had a handshake with sends us a message
+// and we no longer talk to legacy peers
+func (node *Node) checkLegacy(from peer.ID) (err error) {
+	if node.minProtocolVersion > 0 {
+		if _, ok := node.peerProtocol(from); !ok {
+			err = ErrProtocolVersion
+		}
+	}
+	return
+}
+
+// PeerSchema returns the version of a message type's schema that a peer understands
+func (node *Node) PeerSchema(ctx context.Context, proto int, id peer.ID, t MsgType) (version int, err error) {
+	if id == node.HashAddr {
+		version = MsgSchemaVersions[t]
+		return
+	}
+	var p *peerProtocol
+	p, err = node.negotiate(ctx, proto, id)
+	if err != nil {
+		return
+	}
+	version = p.Schemas[t]
+	return
+}
+
+// downgradeMessage returns the message with its body converted to the schema version the peer
+// understands, or ErrSchemaVersion if that can't be done
+func downgradeMessage(m *Message, schemas MsgSchemas) (dm *Message, err error) {
+	mine := MsgSchemaVersions[m.Type]
+	theirs, ok := schemas[m.Type]
+	if !ok {
+		err = ErrSchemaVersion
+		return
+	}
+	dm = m
+	if theirs >= mine {
+		return
+	}
+	body := m.Body
+	for v := mine; v > theirs; v-- {
+		downgrade, ok := msgDowngrades[msgSchema{m.Type, v}]
+		if !ok {
+			err = ErrSchemaVersion
+			return
+		}
+		body, err = downgrade(body)
+		if err != nil {
+			return
+		}
+	}
+	c := *m
+	c.Body = body
+	dm = &c
+	return
+}
+
+// SetCodecs sets the codecs, in order of preference, the node uses for a protocol
+func (node *Node) SetCodecs(proto int, names []string) (err error) {
+	if len(names) == 0 {
+		err = errors.New("no codecs given")
+		return
+	}
+	for _, name := range names {
+		if _, err = GetCodec(name); err != nil {
+			err = fmt.Errorf("%v: %s", err, name)
+			return
+		}
+	}
+	node.codecs[proto] = names
+	return
+}
+
+// peerCodec returns the codec to send messages to a peer via a protocol: the first of
+// ours the peer also reads, or gob if there isn't one
+func (node *Node) peerCodec(proto int, p *peerProtocol) (c Codec) {
+	c = gobWireCodec
+	for _, mine := range node.codecs[proto] {
+		for _, theirs := range p.Codecs[proto] {
+			if mine == theirs {
+				c, _ = GetCodec(mine)
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,122 @@
+package holochain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	nodesCount := 3
+	mt := setupSimMultiNodeTesting(nodesCount, 42)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	Convey("nodes should reject peers with too old a protocol version", t, func() {
+		h1.node.minProtocolVersion = ProtocolVersion + 1
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldEqual, ErrProtocolVersion)
+		_, ok := h0.node.peerProtocol(h1.nodeID)
+		So(ok, ShouldBeFalse)
+		So(h1.node.checkLegacy(h2.nodeID), ShouldEqual, ErrProtocolVersion)
+		h1.node.minProtocolVersion = DefaultMinProtocolVersion
+		So(h1.node.checkLegacy(h2.nodeID), ShouldBeNil)
+	})
+
+	Convey("nodes should record each other's versions on handshake", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		r, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+
+		p, ok := h0.node.peerProtocol(h1.nodeID)
+		So(ok, ShouldBeTrue)
+		So(p.Version, ShouldEqual, ProtocolVersion)
+		So(p.Schemas[GOSSIP_REQUEST], ShouldEqual, MsgSchemaVersions[GOSSIP_REQUEST])
+		p, ok = h1.node.peerProtocol(h0.nodeID)
+		So(ok, ShouldBeTrue)
+		So(p.Version, ShouldEqual, ProtocolVersion)
+
+		v, err := h0.node.PeerSchema(context.Background(), GossipProtocol, h1.nodeID, GETLINK_REQUEST)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, 3)
+	})
+
+	Convey("only a peer that doesn't handle the handshake should be taken as legacy", t, func() {
+		errResp := NewErrorResponse(fmt.Errorf("message type %d not in holochain-gossip protocol", int(HANDSHAKE_REQUEST)))
+		So(noHandshakeHandler(errResp), ShouldBeTrue)
+		errResp = NewErrorResponse(errors.New("some other failure"))
+		So(noHandshakeHandler(errResp), ShouldBeFalse)
+		errResp = NewErrorResponse(fmt.Errorf("message type %d not in holochain-gossip protocol", int(GOSSIP_REQUEST)))
+		So(noHandshakeHandler(errResp), ShouldBeFalse)
+	})
+
+	Convey("a peer taken as legacy should be asked again sooner than HandshakeTTL", t, func() {
+		h0.node.setPeerProtocol(h2.nodeID, 0, legacySchemas, nil)
+		_, ok := h0.node.peerProtocol(h2.nodeID)
+		So(ok, ShouldBeTrue)
+		h0.node.protocolVersions[h2.nodeID].at = time.Now().Add(-LegacyHandshakeTTL - time.Second)
+		_, ok = h0.node.peerProtocol(h2.nodeID)
+		So(ok, ShouldBeFalse)
+
+		v, err := h0.node.PeerSchema(context.Background(), GossipProtocol, h2.nodeID, GOSSIP_REQUEST)
+		So(err, ShouldBeNil)
+		So(v, ShouldEqual, MsgSchemaVersions[GOSSIP_REQUEST])
+	})
+
+	Convey("messages should be downgraded for older peers", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{MyIdx: 1})
+		dm, err := downgradeMessage(m, legacySchemas)
+		So(err, ShouldBeNil)
+		So(dm.Body, ShouldResemble, m.Body)
+
+		m = h0.node.NewMessage(GOSSIP_REQUEST, GossipRangeReq{})
+		_, err = downgradeMessage(m, legacySchemas)
+		So(err, ShouldEqual, ErrSchemaVersion)
+
+		m = h0.node.NewMessage(GETLINK_REQUEST, LinkQuery{T: "tag"})
+		_, err = downgradeMessage(m, legacySchemas)
+		So(err, ShouldBeNil)
+		m = h0.node.NewMessage(GETLINK_REQUEST, LinkQuery{T: "tag", Limit: 2})
+		_, err = downgradeMessage(m, legacySchemas)
+		So(err, ShouldEqual, ErrSchemaVersion)
+		m = h0.node.NewMessage(GETLINK_REQUEST, LinkQuery{TagPrefix: "ta"})
+		_, err = downgradeMessage(m, MsgSchemas{GETLINK_REQUEST: 2})
+		So(err, ShouldEqual, ErrSchemaVersion)
+
+		header := &Header{Type: "evenNumbers"}
+		m = h0.node.NewMessage(PUT_REQUEST, PutReq{H: h0.dnaHash, Header: header})
+		dm, err = downgradeMessage(m, legacySchemas)
+		So(err, ShouldBeNil)
+		So(dm.Body.(PutReq).Header, ShouldBeNil)
+		So(m.Body.(PutReq).Header, ShouldEqual, header)
+		m = h0.node.NewMessage(PUT_REQUEST, PutReq{H: h0.dnaHash, Overflow: true})
+		_, err = downgradeMessage(m, MsgSchemas{PUT_REQUEST: 2})
+		So(err, ShouldEqual, ErrSchemaVersion)
+		m = h0.node.NewMessage(PUT_REQUEST, PutReq{H: h0.dnaHash, Header: header, Successor: true})
+		_, err = downgradeMessage(m, MsgSchemas{PUT_REQUEST: 3})
+		So(err, ShouldEqual, ErrSchemaVersion)
+
+		m = h0.node.NewMessage(HANDSHAKE_REQUEST, h0.node.handshake())
+		_, err = downgradeMessage(m, legacySchemas)
+		So(err, ShouldEqual, ErrSchemaVersion)
+	})
+
+	Convey("range gossip should fall back to index gossip with legacy peers", t, func() {
+		h0.Config.GossipMode = GossipModeRange
+		h0.node.setPeerProtocol(h1.nodeID, 0, legacySchemas, nil)
+		err := h0.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		idx, _ := h0.dht.GetGossiper(h1.nodeID)
+		So(idx, ShouldBeGreaterThan, 0)
+		h0.Config.GossipMode = GossipModeIndex
+	})
+}
@@ -9,7 +9,6 @@ package holochain
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -58,12 +57,19 @@ type Config struct {
 	PeerModeDHTNode bool
 	EnableNATUPnP   bool
 	BootstrapServer string
+	DHTStoreType    string            // storage backend for the DHT: buntdb (the default), memory or bolt
+	GossipMode      string            // how to gossip: index (the default) replays puts, range compares fingerprints
+	WireCodecs      map[string]string // codecs to prefer per protocol, e.g. gossip = "cbor,gob"
+	StorageQuota    int64             // bytes of entries the DHT will hold before refusing new ones, 0 for no limit
+	Passphrase      string            `json:"-" toml:"-" yaml:"-"` // of the chain and DHT stores if encrypted at rest, read from HC_PASSPHRASE and never saved
+	NoChainVerify   bool              // skips checking the chain's header hashes, links and signatures at load
 	Loggers         Loggers
 
 	gossipInterval           time.Duration
 	bootstrapRefreshInterval time.Duration
 	routingRefreshInterval   time.Duration
 	retryInterval            time.Duration
+	gcInterval               time.Duration
 }
 
 // Progenitor holds data on the creator of the DNA
@@ -88,12 +94,15 @@ type Holochain struct {
 	dht              *DHT
 	nucleus          *Nucleus
 	node             *Node
-	chain            *Chain // This node's local source chain
+	chain            *Chain   // This node's local source chain
+	restKey          *RestKey // encrypts the chain and DHT stores at rest, nil if they aren't
+	queries          *queryIndex
 	bridgeDB         *buntdb.DB
 	validateProtocol *Protocol
 	gossipProtocol   *Protocol
 	actionProtocol   *Protocol
 	asyncSends       chan error
+	simNet           *SimNetwork // if set the node runs on this simulated network instead of libp2p
 }
 
 func (h *Holochain) Nucleus() (n *Nucleus) {
@@ -162,33 +171,42 @@ var _holochainInitialized bool
 func InitializeHolochain() {
 	// this should only run once
 	if !_holochainInitialized {
-		gob.Register(Header{})
-		gob.Register(AgentEntry{})
-		gob.Register(Hash{})
-		gob.Register(PutReq{})
-		gob.Register(GetReq{})
-		gob.Register(GetResp{})
-		gob.Register(ModReq{})
-		gob.Register(DelReq{})
-		gob.Register(LinkReq{})
-		gob.Register(LinkQuery{})
-		gob.Register(GossipReq{})
-		gob.Register(Gossip{})
-		gob.Register(ValidateQuery{})
-		gob.Register(ValidateResponse{})
-		gob.Register(Put{})
-		gob.Register(GobEntry{})
-		gob.Register(LinkQueryResp{})
-		gob.Register(TaggedHash{})
-		gob.Register(ErrorResponse{})
-		gob.Register(DelEntry{})
-		gob.Register(StatusChange{})
-		gob.Register(Package{})
-		gob.Register(AppMsg{})
-		gob.Register(ListAddReq{})
-		gob.Register(FindNodeReq{})
-		gob.Register(CloserPeersResp{})
-		gob.Register(PeerInfo{})
+		RegisterWireType(Header{})
+		RegisterWireType(AgentEntry{})
+		RegisterWireType(Hash{})
+		RegisterWireType(PutReq{})
+		RegisterWireType(GetReq{})
+		RegisterWireType(GetResp{})
+		RegisterWireType(GetManyReq{})
+		RegisterWireType(GetManyResp{})
+		RegisterWireType(ModReq{})
+		RegisterWireType(DelReq{})
+		RegisterWireType(LinkReq{})
+		RegisterWireType(LinkQuery{})
+		RegisterWireType(GossipReq{})
+		RegisterWireType(Gossip{})
+		RegisterWireType(GossipRangeReq{})
+		RegisterWireType(GossipRangeResp{})
+		RegisterWireType(GossipFetchReq{})
+		RegisterWireType(ValidateQuery{})
+		RegisterWireType(ValidateResponse{})
+		RegisterWireType(Put{})
+		RegisterWireType(GobEntry{})
+		RegisterWireType(LinkQueryResp{})
+		RegisterWireType(TaggedHash{})
+		RegisterWireType(ErrorResponse{})
+		RegisterWireType(DelEntry{})
+		RegisterWireType(StatusChange{})
+		RegisterWireType(Package{})
+		RegisterWireType(AppMsg{})
+		RegisterWireType(ListAddReq{})
+		RegisterWireType(FindNodeReq{})
+		RegisterWireType(CloserPeersResp{})
+		RegisterWireType(PeerInfo{})
+		RegisterWireType(Handshake{})
+		RegisterWireType(ChunkManifest{})
+		RegisterWireType(EncryptedEntry{})
+		RegisterWireType(SealedKey{})
 
 		RegisterBultinRibosomes()
 
@@ -273,8 +291,28 @@ func (h *Holochain) PrepareHashType() (err error) {
 	return
 }
 
+// UseSimNetwork makes the holochain's node run on a simulated in-process network,
+// it must be called before the holochain is prepared
+func (h *Holochain) UseSimNetwork(network *SimNetwork) {
+	h.simNet = network
+}
+
 // createNode creates a network node based on the current agent and port data
 func (h *Holochain) createNode() (err error) {
+	if h.simNet != nil {
+		h.node, err = NewSimNode(h.simNet, h.dnaHash.String(), h.Agent().(*LibP2PAgent), &h.Config.Loggers.Debug)
+	} else {
+		h.node, err = h.newNode()
+	}
+	if err != nil {
+		return
+	}
+	err = h.node.ConfigureCodecs(h.Config.WireCodecs)
+	return
+}
+
+// newNode creates a libp2p network node
+func (h *Holochain) newNode() (node *Node, err error) {
 	var ip string
 	if os.Getenv("_HCTEST") == "1" {
 		ip = "127.0.0.1"
@@ -282,7 +320,7 @@ func (h *Holochain) createNode() (err error) {
 		ip = "0.0.0.0"
 	}
 	listenaddr := fmt.Sprintf("/ip4/%s/tcp/%d", ip, h.Config.Port)
-	h.node, err = NewNode(listenaddr, h.dnaHash.String(), h.Agent().(*LibP2PAgent), h.Config.EnableNATUPnP, &h.Config.Loggers.Debug)
+	node, err = NewNode(listenaddr, h.dnaHash.String(), h.Agent().(*LibP2PAgent), h.Config.EnableNATUPnP, &h.Config.Loggers.Debug)
 	return
 }
 
@@ -504,6 +542,7 @@ func (config *Config) Setup() (err error) {
 	config.bootstrapRefreshInterval = BootstrapTTL
 	config.routingRefreshInterval = DefaultRoutingRefreshInterval
 	config.retryInterval = DefaultRetryInterval
+	config.gcInterval = DefaultGCInterval
 	err = config.SetupLogging()
 	return
 }
@@ -587,6 +626,75 @@ func (h *Holochain) Walk(fn WalkerFn, entriesToo bool) (err error) {
 	return
 }
 
+// VerifyChain checks the whole of the local chain, its hashes, links and signatures, that
+// it starts with the DNA and agent entries, that the DNA matches the saved DNA hash, that the
+// agent is the one whose key signs the chain, and that each entry conforms to its entry type.
+// Failures in a particular entry are returned as a ChainError.
+func (h *Holochain) VerifyChain() (err error) {
+	err = h.verifyChain(h.chain)
+	return
+}
+
+// verifyChain checks a chain as VerifyChain does as if it were this holochain's
+func (h *Holochain) verifyChain(c *Chain) (err error) {
+	if err = c.Verify(false); err != nil {
+		return
+	}
+	if len(c.Headers) < 2 {
+		err = ErrIncompleteChain
+		return
+	}
+	if c.Headers[0].Type != DNAEntryType {
+		return c.chainErr(0, errors.New("first entry isn't the DNA"))
+	}
+	if c.Headers[1].Type != AgentEntryType {
+		return c.chainErr(1, errors.New("second entry isn't an agent entry"))
+	}
+	var b []byte
+	if b, err = ReadFile(h.rootPath, DNAHashFileName); err != nil {
+		return
+	}
+	if c.Headers[0].EntryLink.String() != string(b) {
+		return c.chainErr(0, errors.New("DNA doesn't match file!"))
+	}
+
+	for i := 1; i < len(c.Headers); i++ {
+		header := c.Headers[i]
+		// deletions hold what was deleted rather than an entry of their type
+		if header.Change.Action == DelAction {
+			continue
+		}
+		var def *EntryDef
+		if _, def, err = h.GetEntryDef(header.Type); err != nil {
+			return c.chainErr(i, err)
+		}
+		var entry Entry
+		if entry, _, err = c.GetEntry(header.EntryLink); err != nil {
+			return c.chainErr(i, err)
+		}
+		if opened, e := h.openEntry(entry); e == nil {
+			entry = opened
+		} else if e != ErrNotRecipient {
+			return c.chainErr(i, e)
+		}
+		if err = sysValidateEntry(h, def, entry, nil); err != nil {
+			return c.chainErr(i, err)
+		}
+	}
+
+	var pub ic.PubKey
+	c.lk.RLock()
+	pub, err = c.agentKey(c.TypeTops[AgentEntryType])
+	c.lk.RUnlock()
+	if err != nil {
+		return
+	}
+	if !pub.Equals(h.agent.PubKey()) {
+		err = errors.New("agent's key doesn't match the chain's agent entry")
+	}
+	return
+}
+
 // GetEntryDef returns an EntryDef of the given name
 // @TODO this makes the incorrect assumption that entry type strings are unique across zomes
 func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
@@ -599,6 +707,9 @@ func (h *Holochain) GetEntryDef(t string) (zome *Zome, d *EntryDef, err error) {
 	} else if t == KeyEntryType {
 		d = KeyEntryDef
 		return
+	} else if t == ChunkEntryType {
+		d = ChunkEntryDef
+		return
 	}
 	for _, z := range h.nucleus.dna.Zomes {
 		d, err = z.GetEntryDef(t)
@@ -705,8 +816,7 @@ func (h *Holochain) Reset() (err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
-	if err != nil {
+	if err = h.openChain(); err != nil {
 		return
 	}
 
@@ -806,6 +916,7 @@ func (h *Holochain) StartBackgroundTasks() {
 		h.node.retrying = h.TaskTicker(h.Config.bootstrapRefreshInterval, BootstrapRefreshTask)
 	}
 	h.node.refreshing = h.TaskTicker(h.Config.routingRefreshInterval, RoutingRefreshTask)
+	h.node.collecting = h.TaskTicker(h.Config.gcInterval, GCTask)
 }
 
 // BootstrapRefreshTask refreshes our node and gets nodes from the bootstrap server
@@ -903,16 +1014,49 @@ type QueryConstrain struct {
 	Matches    string
 	Count      int
 	Page       int
+	Since      time.Time // only entries committed at or after this time
+	Before     time.Time // only entries committed before this time
 }
 
+// QueryOrder orders query results by the value of a JSON field of the entries, or by their
+// place in the chain if Field isn't set, in which case Ascending puts the latest first
 type QueryOrder struct {
 	Ascending bool
+	Field     string
+}
+
+// QueryAggregate asks for the results of a query to be aggregated rather than returned.
+// Sum, Min and Max name JSON fields, and results can be grouped by entry type, a field's
+// value or both.
+type QueryAggregate struct {
+	Count       bool
+	Sum         string
+	Min         string
+	Max         string
+	GroupByType bool
+	GroupBy     string
+}
+
+// isSet returns true if any aggregation was asked for
+func (a *QueryAggregate) isSet() bool {
+	return a.Count || a.Sum != "" || a.Min != "" || a.Max != "" || a.GroupByType || a.GroupBy != ""
+}
+
+// QueryGroup holds the aggregates of a group of query results, Key being what the group's
+// results have in common which is the entry type, the field value, or both as a pair
+type QueryGroup struct {
+	Key   interface{}
+	Count int
+	Sum   float64
+	Min   interface{}
+	Max   interface{}
 }
 
 type QueryOptions struct {
 	Return    QueryReturn
 	Constrain QueryConstrain
 	Order     QueryOrder
+	Aggregate QueryAggregate
 }
 
 type QueryResult struct {
@@ -922,6 +1066,19 @@ type QueryResult struct {
 
 // Query scans the local chain and returns a collection of results based on the options specified
 func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err error) {
+	var q *queryIndex
+	if q, err = h.queryIndex(); err != nil {
+		return
+	}
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	results, _, err = h.query(q, options)
+	return
+}
+
+// query runs a query returning the chain positions of the results along with them.
+// Callers must hold the index's lock.
+func (h *Holochain) query(q *queryIndex, options *QueryOptions) (results []QueryResult, positions []int, err error) {
 	if options == nil {
 		// default options
 		options = &QueryOptions{}
@@ -935,8 +1092,17 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 	var re *regexp.Regexp
 	var equalsMap, containsMap map[string]interface{}
 	var reMap map[string]*regexp.Regexp
+	var candidates []int
+	var equalsDone bool
+	if candidates, equalsDone, err = q.candidates(h, &options.Constrain); err != nil {
+		return
+	}
+	// entries are only read if they're returned or their content is needed
+	needContent := options.Constrain.Contains != "" || options.Constrain.Matches != "" || (options.Constrain.Equals != "" && !equalsDone)
+	needEntries := options.Return.Entries || needContent
 	defs := make(map[string]*EntryDef)
-	for i, header := range h.chain.Headers {
+	for _, i := range candidates {
+		header := h.chain.Headers[i]
 
 		var def *EntryDef
 		var ok bool
@@ -949,6 +1115,11 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 			defs[header.Type] = def
 		}
 
+		// chunks are only ever parts of other entries
+		if header.Type == ChunkEntryType {
+			continue
+		}
+
 		var skip bool
 		if len(options.Constrain.EntryTypes) > 0 {
 			skip = true
@@ -959,20 +1130,38 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 				}
 			}
 		}
-		if !skip && (options.Constrain.Equals != "" || options.Constrain.Contains != "" || options.Constrain.Matches != "") {
+		if !skip && !options.Constrain.Since.IsZero() && header.Time.Before(options.Constrain.Since) {
+			skip = true
+		}
+		if !skip && !options.Constrain.Before.IsZero() && !header.Time.Before(options.Constrain.Before) {
+			skip = true
+		}
+		var entry Entry
+		if !skip && needEntries {
+			entry, err = h.chain.Entry(i)
+			if err == nil {
+				entry, err = h.openEntry(entry)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if !skip && needContent {
 			var content string
 			var contentMap map[string]interface{}
 			if def.DataFormat == DataFormatJSON {
 				contentMap = make(map[string]interface{})
-				err = json.Unmarshal([]byte(h.chain.Entries[i].Content().(string)), &contentMap)
+				err = json.Unmarshal([]byte(entry.Content().(string)), &contentMap)
 				if err != nil {
 					return
 				}
+			} else if def.DataFormat == DataFormatBinary {
+				content = string(entry.Content().([]byte))
 			} else {
-				content = h.chain.Entries[i].Content().(string)
+				content = entry.Content().(string)
 			}
 
-			if !skip && options.Constrain.Equals != "" {
+			if !skip && options.Constrain.Equals != "" && !equalsDone {
 				if def.DataFormat == DataFormatJSON {
 					if equalsMap == nil {
 						equalsMap = make(map[string]interface{})
@@ -1061,25 +1250,34 @@ func (h *Holochain) Query(options *QueryOptions) (results []QueryResult, err err
 			// Return values gets limited down to the actual info in the Ribosomes
 			qr := QueryResult{Header: header}
 			if options.Return.Entries {
-				qr.Entry = h.chain.Entries[i]
+				qr.Entry = entry
 			}
 			if options.Order.Ascending {
 				results = append([]QueryResult{qr}, results...)
+				positions = append([]int{i}, positions...)
 			} else {
 				results = append(results, qr)
+				positions = append(positions, i)
 			}
 		}
 	}
+	if options.Order.Field != "" {
+		if err = q.sortResults(h, results, positions, options.Order.Field, options.Order.Ascending); err != nil {
+			return
+		}
+	}
 	if options.Constrain.Count > 0 {
 		start := options.Constrain.Page * options.Constrain.Count
 		if start >= len(results) {
 			results = []QueryResult{}
+			positions = []int{}
 		} else {
 			end := start + options.Constrain.Count
 			if end > len(results) {
 				end = len(results)
 			}
 			results = results[start:end]
+			positions = positions[start:end]
 		}
 	}
 	return
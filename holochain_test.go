@@ -72,6 +72,7 @@ func TestSetupConfig(t *testing.T) {
 		So(config.bootstrapRefreshInterval, ShouldEqual, BootstrapTTL)
 		So(config.routingRefreshInterval, ShouldEqual, DefaultRoutingRefreshInterval)
 		So(config.retryInterval, ShouldEqual, DefaultRetryInterval)
+		So(config.gcInterval, ShouldEqual, DefaultGCInterval)
 	})
 }
 
@@ -689,6 +690,110 @@ func TestQuery(t *testing.T) {
 		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Pebbles","lastName":"Flintstone"}`)
 		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Zerbina","lastName":"Pinhead"}`)
 	})
+	Convey("query with equals on an indexed field should use the index", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Constrain.Equals = `{"lastName":"Pinhead"}`
+		_, equalsDone, _ := h.queries.candidates(h, &q.Constrain)
+		So(equalsDone, ShouldBeTrue)
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Zippy","lastName":"Pinhead"}`)
+		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Zerbina","lastName":"Pinhead"}`)
+
+		commit(h, "profile", `{"firstName":"Wilma","lastName":"Flintstone"}`)
+		q.Constrain.Equals = `{"lastName":"Flintstone"}`
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Wilma","lastName":"Flintstone"}`)
+	})
+	Convey("query with time constraints should return entries committed in the range", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.Since = h.chain.Headers[3].Time
+		q.Constrain.Before = h.chain.Headers[6].Time
+		var expected int
+		for _, header := range h.chain.Headers {
+			if !header.Time.Before(q.Constrain.Since) && header.Time.Before(q.Constrain.Before) {
+				expected++
+			}
+		}
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, expected)
+		So(results[0].Header.Time.Before(q.Constrain.Since), ShouldBeFalse)
+	})
+	Convey("query with a field order should sort on it", t, func() {
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Order.Field = "firstName"
+		q.Order.Ascending = true
+		results, err := h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 5)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Pebbles","lastName":"Flintstone"}`)
+		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Wilma","lastName":"Flintstone"}`)
+		So(results[4].Entry.Content(), ShouldEqual, `{"firstName":"Zippy","lastName":"Pinhead"}`)
+
+		q.Order.Ascending = false
+		q.Constrain.Count = 2
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(len(results), ShouldEqual, 2)
+		So(results[0].Entry.Content(), ShouldEqual, `{"firstName":"Zippy","lastName":"Pinhead"}`)
+		So(results[1].Entry.Content(), ShouldEqual, `{"firstName":"Zerbina","lastName":"Pinhead"}`)
+
+		// indexed fields sort without reading the entries
+		q = &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Order.Field = "lastName"
+		q.Return.Hashes = true
+		results, err = h.Query(q)
+		So(err, ShouldBeNil)
+		So(results[0].Entry, ShouldBeNil)
+		So(results[0].Header.Type, ShouldEqual, "profile")
+		So(len(results), ShouldEqual, 5)
+	})
+	Convey("query with aggregations should return the aggregates", t, func() {
+		commit(h, "profile", `{"firstName":"Fred","lastName":"Flintstone","age":40}`)
+		commit(h, "profile", `{"firstName":"Barney","lastName":"Rubble","age":38}`)
+		q := &QueryOptions{}
+		q.Constrain.EntryTypes = []string{"profile"}
+		q.Aggregate = QueryAggregate{Count: true, Sum: "age", Min: "age", Max: "firstName"}
+		groups, err := h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(groups, ShouldResemble, []QueryGroup{{Count: 6, Sum: 78, Min: float64(38), Max: "Zippy"}})
+
+		q.Aggregate = QueryAggregate{GroupBy: "lastName", Sum: "age"}
+		groups, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(len(groups), ShouldEqual, 3)
+		So(groups[0], ShouldResemble, QueryGroup{Key: "Flintstone", Count: 3, Sum: 40})
+		So(groups[1], ShouldResemble, QueryGroup{Key: "Pinhead", Count: 2})
+		So(groups[2], ShouldResemble, QueryGroup{Key: "Rubble", Count: 1, Sum: 38})
+
+		q.Constrain.EntryTypes = nil
+		q.Aggregate = QueryAggregate{GroupByType: true}
+		r, err := NewQueryAction(q).Do(h)
+		So(err, ShouldBeNil)
+		groups = r.([]QueryGroup)
+		So(groups[0], ShouldResemble, QueryGroup{Key: DNAEntryType, Count: 1})
+		counts := make(map[interface{}]int)
+		for _, g := range groups {
+			counts[g.Key] = g.Count
+		}
+		So(counts["profile"], ShouldEqual, 6)
+		So(counts["oddNumbers"], ShouldEqual, 2)
+		So(counts["secret"], ShouldEqual, 3)
+
+		q.Constrain.EntryTypes = []string{"oddNumbers"}
+		q.Aggregate = QueryAggregate{Count: true}
+		q.Constrain.Since = time.Now().Add(time.Hour)
+		groups, err = h.QueryAggregate(q)
+		So(err, ShouldBeNil)
+		So(groups, ShouldResemble, []QueryGroup{{}})
+	})
 }
 
 func TestGetEntryDef(t *testing.T) {
@@ -12,6 +12,7 @@ import (
 	peer "github.com/libp2p/go-libp2p-peer"
 	. "github.com/metacurrency/holochain/hash"
 	"github.com/robertkrimen/otto"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -114,7 +115,10 @@ func (jsr *JSRibosome) ValidatePackagingRequest(action ValidatingAction, def *En
 }
 
 func prepareJSEntryArgs(def *EntryDef, entry Entry, header *Header) (args string, err error) {
-	entryStr := entry.Content().(string)
+	var entryStr string
+	if def.DataFormat != DataFormatBinary {
+		entryStr = entry.Content().(string)
+	}
 	switch def.DataFormat {
 	case DataFormatRawJS:
 		args = entryStr
@@ -124,6 +128,8 @@ func prepareJSEntryArgs(def *EntryDef, entry Entry, header *Header) (args string
 		fallthrough
 	case DataFormatJSON:
 		args = fmt.Sprintf(`JSON.parse("%s")`, jsSanitizeString(entryStr))
+	case DataFormatBinary:
+		args = jsByteArray(entry.Content().([]byte))
 	default:
 		err = errors.New("data format not implemented: " + def.DataFormat)
 		return
@@ -212,7 +218,10 @@ func mkJSSources(sources []string) (srcs string) {
 }
 
 func (jsr *JSRibosome) prepareJSValidateEntryArgs(def *EntryDef, entry Entry, sources []string) (e string, srcs string, err error) {
-	c := entry.Content().(string)
+	var c string
+	if def.DataFormat != DataFormatBinary {
+		c = entry.Content().(string)
+	}
 	switch def.DataFormat {
 	case DataFormatRawJS:
 		e = c
@@ -222,6 +231,8 @@ func (jsr *JSRibosome) prepareJSValidateEntryArgs(def *EntryDef, entry Entry, so
 		fallthrough
 	case DataFormatJSON:
 		e = fmt.Sprintf(`JSON.parse("%s")`, jsSanitizeString(c))
+	case DataFormatBinary:
+		e = jsByteArray(entry.Content().([]byte))
 	default:
 		err = errors.New("data format not implemented: " + def.DataFormat)
 		return
@@ -326,6 +337,8 @@ func (jsr *JSRibosome) Call(fn *FunctionDef, params interface{}) (result interfa
 			p := jsSanitizeString(params.(string))
 			code = fmt.Sprintf(`JSON.stringify(%s(JSON.parse("%s")));`, fn.Name, p)
 		}
+	case BINARY_CALLING:
+		code = fmt.Sprintf(`%s(%s);`, fn.Name, jsByteArray([]byte(params.(string))))
 	default:
 		err = errors.New("params type not implemented")
 		return
@@ -341,6 +354,8 @@ func (jsr *JSRibosome) Call(fn *FunctionDef, params interface{}) (result interfa
 			if err == nil {
 				err = errors.New(message.String())
 			}
+		} else if fn.CallingType == BINARY_CALLING && v.Class() == "Array" {
+			result, err = jsToBytes(v)
 		} else {
 			result, err = v.ToString()
 		}
@@ -348,6 +363,124 @@ func (jsr *JSRibosome) Call(fn *FunctionDef, params interface{}) (result interfa
 	return
 }
 
+// jsByteArray returns the code for a JS array of the given bytes
+func jsByteArray(b []byte) string {
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = strconv.Itoa(int(c))
+	}
+	return "[" + strings.Join(s, ",") + "]"
+}
+
+// jsToBytes converts a JS array of numbers into bytes
+func jsToBytes(v otto.Value) (b []byte, err error) {
+	if !v.IsObject() || v.Class() != "Array" {
+		err = errors.New("expected array of bytes")
+		return
+	}
+	o := v.Object()
+	var l otto.Value
+	l, err = o.Get("length")
+	if err != nil {
+		return
+	}
+	var n int64
+	n, err = l.ToInteger()
+	if err != nil {
+		return
+	}
+	b = make([]byte, n)
+	for i := range b {
+		var e otto.Value
+		e, err = o.Get(strconv.Itoa(i))
+		if err != nil {
+			return
+		}
+		var x int64
+		x, err = e.ToInteger()
+		if err != nil {
+			return
+		}
+		if x < 0 || x > 255 {
+			err = fmt.Errorf("byte out of range: %d", x)
+			return
+		}
+		b[i] = byte(x)
+	}
+	return
+}
+
+// jsEntryValue converts entry content to a JS value, bytes becoming an array
+func (jsr *JSRibosome) jsEntryValue(content interface{}) (v otto.Value, err error) {
+	if b, ok := content.([]byte); ok {
+		v, err = jsr.vm.Run(jsByteArray(b))
+	} else {
+		v, err = jsr.vm.ToValue(content)
+	}
+	return
+}
+
+// jsGetValue returns the javascript value of a get response, which is just the entry, its
+// type or its sources if the mask asks for only one of them, or else an object of them
+func (jsr *JSRibosome) jsGetValue(getResp GetResp, mask int) (result otto.Value, err error) {
+	var singleValueReturn bool
+	if mask&GetMaskEntry != 0 {
+		if GetMaskEntry == mask {
+			singleValueReturn = true
+			result, err = jsr.jsEntryValue(getResp.Entry.Content())
+		}
+	}
+	if mask&GetMaskEntryType != 0 {
+		if GetMaskEntryType == mask {
+			singleValueReturn = true
+			result, err = jsr.vm.ToValue(getResp.EntryType)
+		}
+	}
+	if mask&GetMaskSources != 0 {
+		if GetMaskSources == mask {
+			singleValueReturn = true
+			result, err = jsr.vm.ToValue(getResp.Sources)
+		}
+	}
+	if err == nil && !singleValueReturn {
+		respObj := make(map[string]interface{})
+		if mask&GetMaskEntry != 0 {
+			respObj["Entry"], err = jsr.jsEntryValue(getResp.Entry.Content())
+		}
+		if mask&GetMaskEntryType != 0 {
+			respObj["EntryType"] = getResp.EntryType
+		}
+		if mask&GetMaskSources != 0 {
+			respObj["Sources"] = getResp.Sources
+		}
+		result, err = jsr.vm.ToValue(respObj)
+	}
+	return
+}
+
+// jsBatchValue returns the javascript array of the results of a batch action, with an error
+// in place of each item that failed
+func (jsr *JSRibosome) jsBatchValue(results []BatchResult, value func(r interface{}) (otto.Value, error)) (result otto.Value, err error) {
+	var arr *otto.Object
+	if arr, err = jsr.vm.Object(`[]`); err != nil {
+		return
+	}
+	for _, r := range results {
+		var v otto.Value
+		if r.Err == nil {
+			v, r.Err = value(r.Response)
+		}
+		if r.Err != nil {
+			v = mkOttoErr(jsr, r.Err.Error())
+		}
+		if _, err = arr.Call("push", v); err != nil {
+			return
+		}
+	}
+	result = arr.Value()
+	return
+}
+
 // jsProcessArgs processes oArgs according to the args spec filling args[].value with the converted value
 func jsProcessArgs(jsr *JSRibosome, args []Arg, oArgs []otto.Value) (err error) {
 	err = checkArgCount(args, len(oArgs))
@@ -456,6 +589,13 @@ func jsProcessArgs(jsr *JSRibosome, args []Arg, oArgs []otto.Value) (err error)
 				if err != nil {
 					return err
 				}
+			case DataFormatBinary:
+				b, err := jsToBytes(arg)
+				if err != nil {
+					return argErr("array of bytes", i+1, args[i])
+				}
+				args[i].value = b
+				continue
 			default:
 				err = errors.New("data format not implemented: " + def.DataFormat)
 				return err
@@ -472,6 +612,20 @@ func jsProcessArgs(jsr *JSRibosome, args []Arg, oArgs []otto.Value) (err error)
 			} else {
 				return argErr("object", i+1, args[i])
 			}
+		case ListArg:
+			if arg.Class() == "Array" {
+				v, err := jsr.vm.Call("JSON.stringify", nil, arg)
+				if err != nil {
+					return err
+				}
+				var list []interface{}
+				if err = json.Unmarshal([]byte(v.String()), &list); err != nil {
+					return err
+				}
+				args[i].value = list
+			} else {
+				return argErr("array", i+1, args[i])
+			}
 		case ToStrArg:
 			var str string
 			if arg.IsObject() {
@@ -511,6 +665,61 @@ func numInterfaceToInt(num interface{}) (val int, ok bool) {
 	return
 }
 
+// jsLinksCode returns the javascript for the links in a get links response, with their
+// tags if asked for, their entries if they were loaded, and the next page's cursor if paging
+func jsLinksCode(h *Holochain, lqr *LinkQueryResp, tags bool, load bool, paging bool) (js string, err error) {
+	// we build up our response by creating the javascript object
+	// that we want and using otto to create it with vm.
+	// TODO: is there a faster way to do this?
+	for i, th := range lqr.Links {
+		var l string
+		l = `Hash:"` + th.H + `"`
+		if tags {
+			l += `,Tag:"` + jsSanitizeString(th.T) + `"`
+		}
+		if load {
+			l += `,EntryType:"` + jsSanitizeString(th.EntryType) + `"`
+			l += `,Source:"` + jsSanitizeString(th.Source) + `"`
+			var def *EntryDef
+			if _, def, err = h.GetEntryDef(th.EntryType); err != nil {
+				return
+			}
+			var entry string
+			switch def.DataFormat {
+			case DataFormatRawJS:
+				entry = th.E
+			case DataFormatRawZygo:
+				fallthrough
+			case DataFormatString:
+				entry = `"` + jsSanitizeString(th.E) + `"`
+			case DataFormatSysKey:
+				entry = fmt.Sprintf("%v", th.E)
+			case DataFormatSysAgent:
+				fallthrough
+			case DataFormatLinks:
+				fallthrough
+			case DataFormatJSON:
+				entry = `JSON.parse("` + jsSanitizeString(th.E) + `")`
+			default:
+				err = errors.New("data format not implemented: " + def.DataFormat)
+				return
+			}
+
+			l += `,Entry:` + entry
+		}
+		if i > 0 {
+			js += ","
+		}
+		js += `{` + l + `}`
+	}
+	js = `[` + js + `]`
+	warnLinksCut(lqr, paging)
+	if paging {
+		js = `{Links:` + js + `,Next:"` + jsSanitizeString(lqr.Next) + `"}`
+	}
+	return
+}
+
 // NewJSRibosome factory function to build a javascript execution environment for a zome
 func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 	jsr := JSRibosome{
@@ -795,10 +1004,17 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 		}
 
 		entryType := args[0].value.(string)
-		entryStr := args[1].value.(string)
+		content := args[1].value
 		var r interface{}
-		entry := GobEntry{C: entryStr}
-		r, err = NewCommitAction(entryType, &entry).Do(h)
+		entry := GobEntry{C: content}
+		commit := NewCommitAction(entryType, &entry)
+		if len(call.ArgumentList) == 3 {
+			commit.options, err = commitOptionsFrom(args[2].value)
+			if err != nil {
+				return mkOttoErr(&jsr, err.Error())
+			}
+		}
+		r, err = commit.Do(h)
 		if err != nil {
 			return mkOttoErr(&jsr, err.Error())
 		}
@@ -840,6 +1056,15 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 		if err != nil {
 			return mkOttoErr(&jsr, err.Error())
 		}
+		if groups, ok := r.([]QueryGroup); ok {
+			j, err := json.Marshal(groups)
+			if err != nil {
+				return mkOttoErr(&jsr, err.Error())
+			}
+			object, _ := jsr.vm.Object(fmt.Sprintf(`JSON.parse("%s")`, jsSanitizeString(string(j))))
+			results, _ := jsr.vm.ToValue(object)
+			return results
+		}
 		qr := r.([]QueryResult)
 
 		defs := make(map[string]*EntryDef)
@@ -888,6 +1113,8 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 					fallthrough
 				case DataFormatJSON:
 					entryCode = fmt.Sprintf(`JSON.parse("%s")`, jsSanitizeString(r.(string)))
+				case DataFormatBinary:
+					entryCode = jsByteArray(r.([]byte))
 				case DataFormatSysAgent:
 					j, err := json.Marshal(r.(AgentEntry))
 					if err != nil {
@@ -971,39 +1198,7 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			mask = GetMaskEntry
 		}
 		if err == nil {
-			getResp := r.(GetResp)
-			var singleValueReturn bool
-			if mask&GetMaskEntry != 0 {
-				if GetMaskEntry == mask {
-					singleValueReturn = true
-					result, err = jsr.vm.ToValue(getResp.Entry.Content())
-				}
-			}
-			if mask&GetMaskEntryType != 0 {
-				if GetMaskEntryType == mask {
-					singleValueReturn = true
-					result, err = jsr.vm.ToValue(getResp.EntryType)
-				}
-			}
-			if mask&GetMaskSources != 0 {
-				if GetMaskSources == mask {
-					singleValueReturn = true
-					result, err = jsr.vm.ToValue(getResp.Sources)
-				}
-			}
-			if err == nil && !singleValueReturn {
-				respObj := make(map[string]interface{})
-				if mask&GetMaskEntry != 0 {
-					respObj["Entry"] = getResp.Entry.Content()
-				}
-				if mask&GetMaskEntryType != 0 {
-					respObj["EntryType"] = getResp.EntryType
-				}
-				if mask&GetMaskSources != 0 {
-					respObj["Sources"] = getResp.Sources
-				}
-				result, err = jsr.vm.ToValue(respObj)
-			}
+			result, err = jsr.jsGetValue(r.(GetResp), mask)
 			return
 		}
 
@@ -1016,6 +1211,73 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 		return nil, err
 	}
 
+	err = jsr.vm.Set("getMany", func(call otto.FunctionCall) (result otto.Value) {
+		a := &ActionGetMany{}
+		args := a.Args()
+		err := jsProcessArgs(&jsr, args, call.ArgumentList)
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		hashes, err := getManyHashes(args[0].value.([]interface{}))
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		options := GetOptions{StatusMask: StatusDefault}
+		if len(call.ArgumentList) == 2 {
+			var j []byte
+			j, err = json.Marshal(args[1].value)
+			if err == nil {
+				err = json.Unmarshal(j, &options)
+			}
+			if err != nil {
+				return mkOttoErr(&jsr, err.Error())
+			}
+		}
+		mask := options.GetMask
+		if mask == GetMaskDefault {
+			mask = GetMaskEntry
+		}
+		r, err := NewGetManyAction(hashes, &options).Do(h)
+		if err == nil {
+			result, err = jsr.jsBatchValue(r.([]BatchResult), func(r interface{}) (otto.Value, error) {
+				return jsr.jsGetValue(r.(GetResp), mask)
+			})
+		}
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = jsr.vm.Set("commitMany", func(call otto.FunctionCall) (result otto.Value) {
+		a := &ActionCommitMany{}
+		args := a.Args()
+		err := jsProcessArgs(&jsr, args, call.ArgumentList)
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		items, err := commitManyItems(h, args[0].value.([]interface{}))
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		r, err := NewCommitManyAction(items).Do(h)
+		if err == nil {
+			result, err = jsr.jsBatchValue(r.([]BatchResult), func(r interface{}) (otto.Value, error) {
+				return jsr.vm.ToValue(r.(Hash).String())
+			})
+		}
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	err = jsr.vm.Set("update", func(call otto.FunctionCall) (result otto.Value) {
 		var a Action = &ActionMod{}
 		args := a.Args()
@@ -1024,11 +1286,18 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			return mkOttoErr(&jsr, err.Error())
 		}
 		entryType := args[0].value.(string)
-		entryStr := args[1].value.(string)
+		content := args[1].value
 		replaces := args[2].value.(Hash)
 
-		entry := GobEntry{C: entryStr}
-		resp, err := NewModAction(entryType, &entry, replaces).Do(h)
+		entry := GobEntry{C: content}
+		mod := NewModAction(entryType, &entry, replaces)
+		if len(call.ArgumentList) == 4 {
+			mod.options, err = commitOptionsFrom(args[3].value)
+			if err != nil {
+				return mkOttoErr(&jsr, err.Error())
+			}
+		}
+		resp, err := mod.Do(h)
 		if err != nil {
 			return mkOttoErr(&jsr, err.Error())
 		}
@@ -1164,62 +1433,81 @@ func NewJSRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				}
 				options.StatusMask = int(maskval)
 			}
+			pageSize, ok := opts["PageSize"]
+			if ok {
+				pageSizeVal, ok := numInterfaceToInt(pageSize)
+				if !ok {
+					return mkOttoErr(&jsr, fmt.Sprintf("expecting int PageSize attribute in object, got %T", pageSize))
+				}
+				options.PageSize = int(pageSizeVal)
+			}
+			cursor, ok := opts["Cursor"]
+			if ok {
+				cursorVal, ok := cursor.(string)
+				if !ok {
+					return mkOttoErr(&jsr, fmt.Sprintf("expecting string Cursor attribute in object, got %T", cursor))
+				}
+				options.Cursor = cursorVal
+			}
 		}
+		// if paging was asked for the links come back with the cursor for the next page
+		paging := options.PageSize > 0 || options.Cursor != ""
 		var response interface{}
 
-		response, err = NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask}, &options).Do(h)
+		response, err = NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask, Limit: options.PageSize, Cursor: options.Cursor}, &options).Do(h)
 
 		if err == nil {
-			// we build up our response by creating the javascript object
-			// that we want and using otto to create it with vm.
-			// TODO: is there a faster way to do this?
-			lqr := response.(*LinkQueryResp)
 			var js string
-			for i, th := range lqr.Links {
-				var l string
-				l = `Hash:"` + th.H + `"`
-				if tag == "" {
-					l += `,Tag:"` + jsSanitizeString(th.T) + `"`
-				}
-				if options.Load {
-					l += `,EntryType:"` + jsSanitizeString(th.EntryType) + `"`
-					l += `,Source:"` + jsSanitizeString(th.Source) + `"`
-					_, def, err := h.GetEntryDef(th.EntryType)
-					if err != nil {
-						break
-					}
-					var entry string
-					switch def.DataFormat {
-					case DataFormatRawJS:
-						entry = th.E
-					case DataFormatRawZygo:
-						fallthrough
-					case DataFormatString:
-						entry = `"` + jsSanitizeString(th.E) + `"`
-					case DataFormatSysKey:
-						entry = fmt.Sprintf("%v", th.E)
-					case DataFormatSysAgent:
-						fallthrough
-					case DataFormatLinks:
-						fallthrough
-					case DataFormatJSON:
-						entry = `JSON.parse("` + jsSanitizeString(th.E) + `")`
-					default:
-						err = errors.New("data format not implemented: " + def.DataFormat)
-						return
-					}
-
-					l += `,Entry:` + entry
-				}
-				if i > 0 {
-					js += ","
+			js, err = jsLinksCode(h, response.(*LinkQueryResp), tag == "", options.Load, paging)
+			if err == nil {
+				var obj *otto.Object
+				jsr.h.Debugf("getLinks code:\n%s", js)
+				obj, err = jsr.vm.Object(js)
+				if err == nil {
+					result = obj.Value()
 				}
-				js += `{` + l + `}`
 			}
+		}
+
+		if err != nil {
+			result = mkOttoErr(&jsr, err.Error())
+		}
+
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = jsr.vm.Set("queryLinks", func(call otto.FunctionCall) (result otto.Value) {
+		a := &ActionQueryLinks{}
+		args := a.Args()
+		err := jsProcessArgs(&jsr, args, call.ArgumentList)
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		base := args[0].value.(Hash)
+
+		options := QueryLinksOptions{}
+		var j []byte
+		j, err = json.Marshal(args[1].value)
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		err = json.Unmarshal(j, &options)
+		if err != nil {
+			return mkOttoErr(&jsr, err.Error())
+		}
+		paging := options.PageSize > 0 || options.Cursor != ""
+
+		var response interface{}
+		response, err = NewQueryLinksAction(base, &options).Do(h)
+		if err == nil {
+			var js string
+			js, err = jsLinksCode(h, response.(*LinkQueryResp), true, options.Load, paging)
 			if err == nil {
-				js = `[` + js + `]`
 				var obj *otto.Object
-				jsr.h.Debugf("getLinks code:\n%s", js)
+				jsr.h.Debugf("queryLinks code:\n%s", js)
 				obj, err = jsr.vm.Object(js)
 				if err == nil {
 					result = obj.Value()
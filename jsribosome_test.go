@@ -379,6 +379,10 @@ func TestJSQuery(t *testing.T) {
 			_, err := z.Run(`debug(query({Constrain:{EntryTypes:["rating"]}}))`)
 			So(err, ShouldBeNil)
 		})
+		ShouldLog(h.nucleus.alog, `[{"Key":"oddNumbers","Count":2,"Sum":0,"Min":null,"Max":null}]`, func() {
+			_, err := z.Run(`debug(query({Aggregate:{GroupByType:true},Constrain:{EntryTypes:["oddNumbers"]}}))`)
+			So(err, ShouldBeNil)
+		})
 	})
 }
 
@@ -700,6 +704,20 @@ func TestJSDHT(t *testing.T) {
 		So(fmt.Sprintf("%v", obj["Sources"]), ShouldEqual, fmt.Sprintf("[%v]", h.nodeIDStr))
 	})
 
+	Convey("commit and get should handle binary entries as byte arrays", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: `get(commit("image",[0,1,255]));`})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", x), ShouldEqual, `[0 1 255]`)
+
+		v, err = NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: `commit("image","not bytes");`})
+		So(err, ShouldBeNil)
+		z = v.(*JSRibosome)
+		So(z.lastResult.String(), ShouldEqual, "HolochainError: argument 2 (entry) should be array of bytes")
+	})
+
 	profileHash := commit(h, "profile", `{"firstName":"Zippy","lastName":"Pinhead"}`)
 	reviewHash := commit(h, "review", "this is my bogus review of some thing")
 
@@ -734,6 +752,55 @@ func TestJSDHT(t *testing.T) {
 
 	})
 
+	Convey("queryLinks should return the Links with matching tags", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`var q=queryLinks("%s",{TagPrefix:"4"});[q.length,q[0].Tag,q[1].Tag,queryLinks("%s",{Tags:["3stars","5stars"]}).length]`, hash.String(), hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", x), ShouldEqual, `[2 4stars 4stars 0]`)
+
+		v, err = NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`queryLinks("%s",{});`, hash.String())})
+		So(err, ShouldBeNil)
+		z = v.(*JSRibosome)
+		So(z.lastResult.String(), ShouldEqual, "HolochainError: queryLinks needs a TagPrefix or Tags")
+	})
+
+	Convey("commitMany and getMany should return a value or error for each entry", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: `var c=commitMany([{EntryType:"oddNumbers",Entry:"9"},{EntryType:"oddNumbers",Entry:"4"}]);var g=getMany([c[0],c[0]]);[c.length,c[1].name,g.length,g[0],g[1]]`})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", x), ShouldEqual, `[2 HolochainError 2 9 9]`)
+	})
+
+	Convey("getLinks with PageSize option should return pages of Links with a cursor", t, func() {
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`var p1=getLinks("%s","4stars",{PageSize:1});var p2=getLinks("%s","4stars",{PageSize:1,Cursor:p1.Next});[p1.Links.length,p1.Links[0].Hash,p2.Links.length,p2.Links[0].Hash,p2.Next]`, hash.String(), hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		r := x.([]interface{})
+		So(r[0], ShouldEqual, 1)
+		So(r[2], ShouldEqual, 1)
+		So(r[1], ShouldNotEqual, r[3])
+		So([]string{r[1].(string), r[3].(string)}, ShouldContain, reviewHash.String())
+		So([]string{r[1].(string), r[3].(string)}, ShouldContain, profileHash.String())
+		So(r[4], ShouldEqual, "")
+	})
+
+	Convey("getLinks cut short by MaxLinkSets should still return an array unless paging", t, func() {
+		h.dht.config.MaxLinkSets = 1
+		defer func() { h.dht.config.MaxLinkSets = 0 }()
+		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`var p=getLinks("%s","4stars");[Array.isArray(p),p.length]`, hash.String())})
+		So(err, ShouldBeNil)
+		z := v.(*JSRibosome)
+		x, err := z.lastResult.Export()
+		So(err, ShouldBeNil)
+		So(fmt.Sprintf("%v", x), ShouldEqual, "[true 1]")
+	})
+
 	Convey("getLinks with load option should return the Links and entries", t, func() {
 		v, err := NewJSRibosome(h, &Zome{RibosomeType: JSRibosomeType, Code: fmt.Sprintf(`getLinks("%s","4stars",{Load:true});`, hash.String())})
 		So(err, ShouldBeNil)
@@ -238,8 +238,7 @@ func (r *dhtQueryRunner) queryPeer(proc process.Process, p peer.ID) {
 	}()
 
 	// make sure we're connected to the peer.
-	// FIXME abstract away into the network layer
-	if conns := r.query.node.host.Network().ConnsToPeer(p); len(conns) == 0 {
+	if !r.query.node.transport.Connected(p) {
 		r.query.log.Log("not connected. dialing.")
 
 		/*
@@ -254,7 +253,7 @@ func (r *dhtQueryRunner) queryPeer(proc process.Process, p peer.ID) {
 
 		pi := pstore.PeerInfo{ID: p}
 
-		if err := r.query.node.host.Connect(ctx, pi); err != nil {
+		if err := r.query.node.transport.Connect(ctx, pi); err != nil {
 			r.query.log.Logf("Error connecting: %s", err)
 
 			/*
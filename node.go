@@ -16,7 +16,6 @@ import (
 	goprocess "github.com/jbenet/goprocess"
 	goprocessctx "github.com/jbenet/goprocess/context"
 	nat "github.com/libp2p/go-libp2p-nat"
-	net "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	protocol "github.com/libp2p/go-libp2p-protocol"
@@ -80,6 +79,14 @@ const (
 	// Kademlia messages
 
 	FIND_NODE_REQUEST
+
+	// Handshake messages
+
+	HANDSHAKE_REQUEST
+
+	// Batch messages
+
+	GETMANY_REQUEST
 )
 
 func (msgType MsgType) String() string {
@@ -99,7 +106,9 @@ func (msgType MsgType) String() string {
 		"VALIDATE_MOD_REQUEST",
 		"APP_MESSAGE",
 		"LISTADD_REQUEST",
-		"FIND_NODE_REQUEST"}[msgType]
+		"FIND_NODE_REQUEST",
+		"HANDSHAKE_REQUEST",
+		"GETMANY_REQUEST"}[msgType]
 }
 
 var ErrBlockedListed = errors.New("node blockedlisted")
@@ -110,6 +119,8 @@ type Message struct {
 	Time time.Time
 	From peer.ID
 	Body interface{}
+	Sig  []byte `bson:",omitempty"` // signature by the From node, see Sign
+	Key  []byte `bson:",omitempty"` // marshaled public key of the From node
 }
 
 // Node represents a node in the network
@@ -117,6 +128,7 @@ type Node struct {
 	HashAddr     peer.ID
 	NetAddr      ma.Multiaddr
 	host         *rhost.RoutedHost
+	transport    Transport
 	mdnsSvc      discovery.Service
 	blockedlist  map[peer.ID]bool
 	protocols    [_protocolCount]*Protocol
@@ -130,12 +142,19 @@ type Node struct {
 	gossiping     chan bool
 	bootstrapping chan bool
 	refreshing    chan bool
+	collecting    chan bool
 
 	// items for the kademlia implementation
 	plk   sync.Mutex
 	peers map[peer.ID]*peerTracker
 	ctx   context.Context
 	proc  goprocess.Process
+
+	// protocol versions from handshakes and the codecs we prefer for each protocol
+	codecs             [_protocolCount][]string
+	minProtocolVersion int
+	vlk                sync.RWMutex
+	protocolVersions   map[peer.ID]*peerProtocol
 }
 
 // Protocol encapsulates data for our different protocols
@@ -175,7 +194,7 @@ func (h *Holochain) addPeer(pi pstore.PeerInfo, confirm bool) (err error) {
 
 	// attempt a connection to see if this is actually valid
 	if confirm {
-		err = h.node.host.Connect(h.node.ctx, pi)
+		err = h.node.transport.Connect(h.node.ctx, pi)
 	}
 	if err != nil {
 		h.dht.dlog.Logf("Clearing peer %v, connection failed (%v)\n", pi.ID, err)
@@ -245,6 +264,10 @@ func (h *Holochain) AddPeer(pi pstore.PeerInfo) (err error) {
 }
 
 func (n *Node) EnableMDNSDiscovery(h *Holochain, interval time.Duration) (err error) {
+	if n.host == nil {
+		err = errors.New("mdns discovery requires a libp2p host")
+		return
+	}
 	ctx := context.Background()
 	tag := h.dnaHash.String() + "._udp"
 	n.mdnsSvc, err = discovery.NewMdnsService(ctx, n.host, interval, tag)
@@ -320,6 +343,30 @@ func (node *Node) discoverAndHandleNat(listenPort int) {
 	}
 }
 
+// initProtocols sets up the identifiers and receivers of our protocols
+func (n *Node) initProtocols(protoMux string) {
+	validateProtocolString := "/hc-validate-" + protoMux + "/0.0.0"
+	gossipProtocolString := "/hc-gossip-" + protoMux + "/0.0.0"
+	actionProtocolString := "/hc-action-" + protoMux + "/0.0.0"
+	kademliaProtocolString := "/hc-kademlia-" + protoMux + "/0.0.0"
+
+	n.log.Logf("Validate protocol identifier: " + validateProtocolString)
+	n.log.Logf("Gossip protocol identifier: " + gossipProtocolString)
+	n.log.Logf("Action protocol identifier: " + actionProtocolString)
+	n.log.Logf("Kademlia protocol identifier: " + kademliaProtocolString)
+
+	n.protocols[ValidateProtocol] = &Protocol{protocol.ID(validateProtocolString), ValidateReceiver}
+	n.protocols[GossipProtocol] = &Protocol{protocol.ID(gossipProtocolString), GossipReceiver}
+	n.protocols[ActionProtocol] = &Protocol{protocol.ID(actionProtocolString), ActionReceiver}
+	n.protocols[KademliaProtocol] = &Protocol{protocol.ID(kademliaProtocolString), KademliaReceiver}
+
+	for i := range n.codecs {
+		n.codecs[i] = []string{GobCodec}
+	}
+	n.minProtocolVersion = DefaultMinProtocolVersion
+	n.protocolVersions = make(map[peer.ID]*peerProtocol)
+}
+
 // NewNode creates a new node with given multiAddress listener string and identity
 func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUPnP bool, log *Logger) (node *Node, err error) {
 	var n Node
@@ -355,20 +402,7 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 	ps.AddPrivKey(nodeID, priv)
 	ps.AddPubKey(nodeID, priv.GetPublic())
 
-	validateProtocolString := "/hc-validate-" + protoMux + "/0.0.0"
-	gossipProtocolString := "/hc-gossip-" + protoMux + "/0.0.0"
-	actionProtocolString := "/hc-action-" + protoMux + "/0.0.0"
-	kademliaProtocolString := "/hc-kademlia-" + protoMux + "/0.0.0"
-
-	n.log.Logf("Validate protocol identifier: " + validateProtocolString)
-	n.log.Logf("Gossip protocol identifier: " + gossipProtocolString)
-	n.log.Logf("Action protocol identifier: " + actionProtocolString)
-	n.log.Logf("Kademlia protocol identifier: " + kademliaProtocolString)
-
-	n.protocols[ValidateProtocol] = &Protocol{protocol.ID(validateProtocolString), ValidateReceiver}
-	n.protocols[GossipProtocol] = &Protocol{protocol.ID(gossipProtocolString), GossipReceiver}
-	n.protocols[ActionProtocol] = &Protocol{protocol.ID(actionProtocolString), ActionReceiver}
-	n.protocols[KademliaProtocol] = &Protocol{protocol.ID(kademliaProtocolString), KademliaReceiver}
+	n.initProtocols(protoMux)
 
 	ctx := context.Background()
 	n.ctx = ctx
@@ -386,6 +420,7 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 	}
 
 	n.host = rhost.Wrap(bh, &n)
+	n.transport = &libP2PTransport{host: n.host, log: n.log}
 
 	m := pstore.NewMetrics()
 	n.routingTable = NewRoutingTable(KValue, nodeID, time.Minute, m)
@@ -398,14 +433,13 @@ func NewNode(listenAddr string, protoMux string, agent *LibP2PAgent, enableNATUP
 	n.proc = goprocessctx.WithContextAndTeardown(ctx, func() error {
 		// remove ourselves from network notifs.
 		n.host.Network().StopNotify((*netNotifiee)(node))
-		return n.host.Close()
+		return n.transport.Close()
 	})
 
 	return
 }
 
-// Encode codes a message to gob format
-// @TODO generalize for other message encoding formats
+// Encode codes a message to gob format, see EncodeMessage for other codecs
 func (m *Message) Encode() (data []byte, err error) {
 	data, err = ByteEncoder(m)
 	if err != nil {
@@ -414,19 +448,20 @@ func (m *Message) Encode() (data []byte, err error) {
 	return
 }
 
-// Decode converts a message from gob format
-// @TODO generalize for other message encoding formats
+// Decode converts a message from gob format, see DecodeMessage for other codecs
 func (m *Message) Decode(r io.Reader) (err error) {
 	dec := gob.NewDecoder(r)
 	err = dec.Decode(m)
 	return
 }
 
-// Fingerprint creates a hash of a message
+// Fingerprint creates a hash of a message, which doesn't include its signature
 func (m *Message) Fingerprint() (f Hash, err error) {
 	var data []byte
 	if m != nil {
-		data, err = bson.Marshal(m)
+		c := *m
+		c.Sig, c.Key = nil, nil
+		data, err = bson.Marshal(&c)
 
 		if err != nil {
 			return
@@ -444,9 +479,8 @@ func (m Message) String() string {
 	return fmt.Sprintf("%v @ %v From:%v Body:%v", m.Type, m.Time, m.From, m.Body)
 }
 
-// respondWith writes a message either error or otherwise, to the stream
-func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
-	var m *Message
+// newResponse builds a response message either error or otherwise
+func (node *Node) newResponse(err error, body interface{}) (m *Message) {
 	if err != nil {
 		errResp := NewErrorResponse(err)
 		errResp.Payload = body
@@ -454,36 +488,32 @@ func (node *Node) respondWith(s net.Stream, err error, body interface{}) {
 	} else {
 		m = node.NewMessage(OK_RESPONSE, body)
 	}
-
-	data, err := m.Encode()
-	if err != nil {
-		Infof("Response failed: unable to encode message: %v", m)
-	}
-	_, err = s.Write(data)
-	if err != nil {
-		Infof("Response failed: write returned error: %v", err)
-	}
+	return
 }
 
 // StartProtocol initiates listening for a protocol on the node
 func (node *Node) StartProtocol(h *Holochain, proto int) (err error) {
-	node.host.SetStreamHandler(node.protocols[proto].ID, func(s net.Stream) {
-		var m Message
-		err := m.Decode(s)
+	node.transport.SetHandler(node.protocols[proto].ID, func(from peer.ID, m *Message, err error) *Message {
 		var response interface{}
 		if m.From == "" {
 			// @todo other sanity checks on From?
 			err = errors.New("message must have a source")
 		} else {
-			if node.IsBlocked(s.Conn().RemotePeer()) {
+			if node.IsBlocked(from) {
 				err = ErrBlockedListed
+			} else if err = node.authenticate(from, m); err == ErrSenderMismatch || err == ErrMessageSignature {
+				h.blockSpoofer(from, err)
 			}
 
 			if err == nil {
-				response, err = node.protocols[proto].Receiver(h, &m)
+				if m.Type == HANDSHAKE_REQUEST {
+					response, err = node.receiveHandshake(from, m)
+				} else if err = node.checkLegacy(from); err == nil {
+					response, err = node.protocols[proto].Receiver(h, m)
+				}
 			}
 		}
-		node.respondWith(s, err, response)
+		return node.newResponse(err, response)
 	})
 	return
 }
@@ -508,6 +538,12 @@ func (node *Node) Close() error {
 		node.bootstrapping = nil
 		stop <- true
 	}
+	if node.collecting != nil {
+		node.log.Log("Stopping garbage collecting")
+		stop := node.collecting
+		node.collecting = nil
+		stop <- true
+	}
 	return node.proc.Close()
 }
 
@@ -519,39 +555,38 @@ func (node *Node) Send(ctx context.Context, proto int, addr peer.ID, m *Message)
 		return
 	}
 
-	s, err := node.host.NewStream(ctx, addr, node.protocols[proto].ID)
-	if err != nil {
-		return
-	}
-	defer s.Close()
-
-	// encode the message and send it
-	data, err := m.Encode()
+	var p *peerProtocol
+	p, err = node.negotiate(ctx, proto, addr)
 	if err != nil {
 		return
 	}
-
-	n, err := s.Write(data)
+	var dm *Message
+	dm, err = downgradeMessage(m, p.Schemas)
 	if err != nil {
 		return
 	}
-	if n != len(data) {
-		err = errors.New("unable to send all data")
+	// a downgraded message is a new message so needs a new signature
+	if dm.From == node.HashAddr && (dm != m || dm.Sig == nil) {
+		if err = node.sign(dm); err != nil {
+			return
+		}
 	}
 
-	// decode the response
-	err = response.Decode(s)
+	response, err = node.transport.Request(ctx, addr, node.protocols[proto].ID, dm, node.peerCodec(proto, p))
 	if err != nil {
-		node.log.Logf("failed to decode with err:%v ", err)
 		return
 	}
+	err = node.authenticate(addr, &response)
 	return
 }
 
-// NewMessage creates a message from the node with a new current timestamp
+// NewMessage creates a message from the node with a new current timestamp, signed by the node
 func (node *Node) NewMessage(t MsgType, body interface{}) (msg *Message) {
 	m := Message{Type: t, Time: time.Now().Round(0), Body: body, From: node.HashAddr}
 	msg = &m
+	if err := node.sign(msg); err != nil {
+		node.log.Logf("unable to sign %v message: %v", t, err)
+	}
 	return
 }
 
@@ -599,6 +634,9 @@ const (
 	ErrLinkNotFoundCode
 	ErrEntryTypeMismatchCode
 	ErrBlockedListedCode
+	ErrProtocolVersionCode
+	ErrEntryTooLargeCode
+	ErrStorageQuotaCode
 )
 
 // NewErrorResponse encodes standard errors for transmitting
@@ -618,6 +656,12 @@ func NewErrorResponse(err error) (errResp ErrorResponse) {
 		errResp.Code = ErrEntryTypeMismatchCode
 	case ErrBlockedListed:
 		errResp.Code = ErrBlockedListedCode
+	case ErrProtocolVersion:
+		errResp.Code = ErrProtocolVersionCode
+	case ErrEntryTooLarge:
+		errResp.Code = ErrEntryTooLargeCode
+	case ErrStorageQuota:
+		errResp.Code = ErrStorageQuotaCode
 	default:
 		errResp.Message = err.Error() //Code will be set to ErrUnknown by default cus it's 0
 	}
@@ -641,6 +685,12 @@ func (errResp ErrorResponse) DecodeResponseError() (err error) {
 		err = ErrEntryTypeMismatch
 	case ErrBlockedListedCode:
 		err = ErrBlockedListed
+	case ErrProtocolVersionCode:
+		err = ErrProtocolVersion
+	case ErrEntryTooLargeCode:
+		err = ErrEntryTooLarge
+	case ErrStorageQuotaCode:
+		err = ErrStorageQuota
 	default:
 		err = errors.New(errResp.Message)
 	}
@@ -661,9 +661,19 @@ type multiNodeTest struct {
 	d      string
 	nodes  []*Holochain
 	count  int
+	net    *SimNetwork
 }
 
 func setupMultiNodeTesting(n int) (mt *multiNodeTest) {
+	return setupMultiNodeTestingOn(n, nil)
+}
+
+// setupSimMultiNodeTesting creates n nodes on a simulated network seeded with seed
+func setupSimMultiNodeTesting(n int, seed int64) (mt *multiNodeTest) {
+	return setupMultiNodeTestingOn(n, NewSimNetwork(seed))
+}
+
+func setupMultiNodeTestingOn(n int, network *SimNetwork) (mt *multiNodeTest) {
 	ctx, cancel := context.WithCancel(context.Background())
 	d, s := SetupTestService()
 	mt = &multiNodeTest{
@@ -672,8 +682,9 @@ func setupMultiNodeTesting(n int) (mt *multiNodeTest) {
 		s:      s,
 		d:      d,
 		count:  n,
+		net:    network,
 	}
-	mt.nodes = makeTestNodes(mt.ctx, mt.s, n)
+	mt.nodes = makeTestNodes(mt.ctx, mt.s, n, network)
 	return
 }
 
@@ -685,13 +696,16 @@ func (mt *multiNodeTest) cleanupMultiNodeTesting() {
 	CleanupTestDir(mt.d)
 }
 
-func makeTestNodes(ctx context.Context, s *Service, n int) (nodes []*Holochain) {
+func makeTestNodes(ctx context.Context, s *Service, n int, network *SimNetwork) (nodes []*Holochain) {
 	nodes = make([]*Holochain, n)
 	for i := 0; i < n; i++ {
 		nodeName := fmt.Sprintf("node%d", i)
 		os.Setenv("HCLOG_PREFIX", nodeName+"_")
 		nodes[i] = setupTestChain(nodeName, i, s)
 		nodes[i].Config.EnableMDNS = false
+		if network != nil {
+			nodes[i].UseSimNetwork(network)
+		}
 		prepareTestChain(nodes[i])
 	}
 	for i := 0; i < n; i++ {
@@ -717,7 +731,7 @@ func connectNoSync(t *testing.T, ctx context.Context, ah, bh *Holochain) {
 		t.Fatal(err)
 	}
 
-	if err = a.host.Connect(ctx, pi); err != nil {
+	if err = a.transport.Connect(ctx, pi); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -0,0 +1,300 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// entries of Partial sharing types are encrypted to a set of recipient agents before they
+// are committed, so the DHT holds and gossips them like public entries, and anyone can
+// check their headers, but only the recipients can read them.  The content is sealed with
+// a random key which is then sealed to each recipient's curve25519 equivalent of their
+// ed25519 agent key.
+
+package holochain
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"github.com/agl/ed25519/extra25519"
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+	"sort"
+)
+
+var ErrNotRecipient = errors.New("not a recipient of this entry")
+var ErrBadEncryptedEntry = errors.New("badly formed encrypted entry")
+
+// CommitOptions holds the options of a commit
+type CommitOptions struct {
+	Recipients []string // key hashes of the agents a Partial entry is encrypted to
+}
+
+// commitOptionsFrom converts the options map passed to commit in the ribosomes
+func commitOptionsFrom(m interface{}) (options *CommitOptions, err error) {
+	var j []byte
+	j, err = json.Marshal(m)
+	if err != nil {
+		return
+	}
+	options = &CommitOptions{}
+	err = json.Unmarshal(j, options)
+	return
+}
+
+// SealedKey is the key of an encrypted entry sealed to one of its recipients
+type SealedKey struct {
+	Recipient string // key hash of the recipient
+	Key       []byte
+}
+
+// EncryptedEntry is committed in place of the content of a Partial entry
+type EncryptedEntry struct {
+	Nonce      []byte      // used for sealing both the content and its keys
+	Ephemeral  []byte      // curve25519 public key the content keys were sealed with
+	Content    []byte      // the marshaled entry sealed with the content key
+	Recipients []SealedKey // sorted by recipient so the entry hashes the same everywhere
+}
+
+// curvePubKey converts an agent's ed25519 public key to curve25519
+func curvePubKey(pub ic.PubKey) (curve *[32]byte, err error) {
+	var b []byte
+	b, err = ic.MarshalPublicKey(pub)
+	if err != nil {
+		return
+	}
+	// marshaled ed25519 keys are a 4 byte header followed by the key
+	if len(b) != 36 {
+		err = errors.New("only ed25519 keys can be encrypted to")
+		return
+	}
+	var ed [32]byte
+	copy(ed[:], b[4:])
+	curve = new([32]byte)
+	if !extra25519.PublicKeyToCurve25519(curve, &ed) {
+		err = errors.New("invalid ed25519 public key")
+	}
+	return
+}
+
+// curvePrivKey converts an agent's ed25519 private key to curve25519
+func curvePrivKey(priv ic.PrivKey) (curve *[32]byte, err error) {
+	var b []byte
+	b, err = ic.MarshalPrivateKey(priv)
+	if err != nil {
+		return
+	}
+	if len(b) < 68 {
+		err = errors.New("only ed25519 keys can be decrypted with")
+		return
+	}
+	var ed [64]byte
+	copy(ed[:], b[4:68])
+	curve = new([32]byte)
+	extra25519.PrivateKeyToCurve25519(curve, &ed)
+	return
+}
+
+// recipientKey returns the public key of the agent with the given key hash, from the
+// peerstore if we know it or else the key entry in the DHT
+func (h *Holochain) recipientKey(recipient string) (pub ic.PubKey, err error) {
+	var id peer.ID
+	id, err = peer.IDB58Decode(recipient)
+	if err != nil {
+		return
+	}
+	if id == h.nodeID {
+		pub = h.agent.PubKey()
+		return
+	}
+	if pub = h.node.peerstore.PubKey(id); pub != nil {
+		return
+	}
+	var hash Hash
+	hash, err = NewHash(recipient)
+	if err != nil {
+		return
+	}
+	options := GetOptions{StatusMask: StatusLive, GetMask: GetMaskEntry}
+	req := GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+	var r interface{}
+	r, err = NewGetAction(req, &options).Do(h)
+	if err != nil {
+		return
+	}
+	k, ok := r.(GetResp).Entry.C.([]byte)
+	if !ok {
+		err = errors.New("no public key for recipient " + recipient)
+		return
+	}
+	pub, err = ic.UnmarshalPublicKey(k)
+	if err != nil {
+		return
+	}
+	// make sure the DHT didn't give us someone else's key
+	var pid peer.ID
+	pid, err = peer.IDFromPublicKey(pub)
+	if err == nil && pid != id {
+		err = errors.New("public key doesn't match recipient " + recipient)
+	}
+	return
+}
+
+// encryptEntry seals an entry to the given recipients, always including ourselves
+func (h *Holochain) encryptEntry(entry Entry, recipients []string) (encrypted Entry, err error) {
+	var plain []byte
+	plain, err = entry.Marshal()
+	if err != nil {
+		return
+	}
+	var contentKey [32]byte
+	var nonce [24]byte
+	if _, err = rand.Read(contentKey[:]); err != nil {
+		return
+	}
+	if _, err = rand.Read(nonce[:]); err != nil {
+		return
+	}
+	var ephPub, ephPriv *[32]byte
+	ephPub, ephPriv, err = box.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	e := EncryptedEntry{
+		Nonce:     nonce[:],
+		Ephemeral: ephPub[:],
+		Content:   secretbox.Seal(nil, plain, &nonce, &contentKey),
+	}
+
+	sealed := map[string]bool{}
+	for _, r := range append([]string{h.nodeIDStr}, recipients...) {
+		if sealed[r] {
+			continue
+		}
+		var pub ic.PubKey
+		pub, err = h.recipientKey(r)
+		if err != nil {
+			return
+		}
+		var curve *[32]byte
+		curve, err = curvePubKey(pub)
+		if err != nil {
+			return
+		}
+		e.Recipients = append(e.Recipients, SealedKey{Recipient: r, Key: box.Seal(nil, contentKey[:], &nonce, curve, ephPriv)})
+		sealed[r] = true
+	}
+	sort.Slice(e.Recipients, func(i, j int) bool { return e.Recipients[i].Recipient < e.Recipients[j].Recipient })
+	encrypted = &GobEntry{C: e}
+	return
+}
+
+// checkEncryptedEntry checks that an encrypted entry is well formed
+func checkEncryptedEntry(e EncryptedEntry) (err error) {
+	if len(e.Nonce) != 24 || len(e.Ephemeral) != 32 || len(e.Content) < secretbox.Overhead || len(e.Recipients) == 0 {
+		err = ErrBadEncryptedEntry
+	}
+	return
+}
+
+// decryptEntry returns the entry an encrypted entry stands for, or the entry itself if it
+// isn't one, returning ErrNotRecipient if the entry wasn't encrypted to us
+func (h *Holochain) decryptEntry(entry Entry) (decrypted Entry, err error) {
+	decrypted = entry
+	if entry == nil {
+		return
+	}
+	e, ok := entry.Content().(EncryptedEntry)
+	if !ok {
+		return
+	}
+	if err = checkEncryptedEntry(e); err != nil {
+		return
+	}
+	var sealedKey []byte
+	for _, r := range e.Recipients {
+		if r.Recipient == h.nodeIDStr {
+			sealedKey = r.Key
+			break
+		}
+	}
+	if sealedKey == nil {
+		err = ErrNotRecipient
+		return
+	}
+	var curve *[32]byte
+	curve, err = curvePrivKey(h.agent.PrivKey())
+	if err != nil {
+		return
+	}
+	var nonce [24]byte
+	var ephPub, contentKey [32]byte
+	copy(nonce[:], e.Nonce)
+	copy(ephPub[:], e.Ephemeral)
+	key, ok := box.Open(nil, sealedKey, &nonce, &ephPub, curve)
+	if !ok || len(key) != 32 {
+		err = ErrBadEncryptedEntry
+		return
+	}
+	copy(contentKey[:], key)
+	plain, ok := secretbox.Open(nil, e.Content, &nonce, &contentKey)
+	if !ok {
+		err = ErrBadEncryptedEntry
+		return
+	}
+	var g GobEntry
+	if err = g.Unmarshal(plain); err != nil {
+		return
+	}
+	decrypted = &g
+	return
+}
+
+// openEntry returns what was committed for an entry, assembling it if it's chunked and
+// decrypting it if it's encrypted, in which case it may return ErrNotRecipient
+func (h *Holochain) openEntry(entry Entry) (opened Entry, err error) {
+	opened, err = h.assembleEntry(entry)
+	if err == nil {
+		opened, err = h.decryptEntry(opened)
+	}
+	return
+}
+
+// openResp opens the entry in a get response
+func (h *Holochain) openResp(resp *GetResp) (err error) {
+	switch resp.Entry.C.(type) {
+	case ChunkManifest, EncryptedEntry:
+		var entry Entry
+		entry, err = h.openEntry(&resp.Entry)
+		if err == nil {
+			resp.Entry = *entry.(*GobEntry)
+		}
+	}
+	return
+}
+
+// openAction returns a copy of an action with its entry opened so that validation sees
+// what was committed.  If the entry wasn't encrypted to us the copy holds it encrypted
+// and ErrNotRecipient is returned.
+func (h *Holochain) openAction(a ValidatingAction) (opened ValidatingAction, err error) {
+	opened = a
+	switch t := a.(type) {
+	case *ActionCommit:
+		c := *t
+		if c.entry, err = h.openEntry(t.entry); err == nil || err == ErrNotRecipient {
+			opened = &c
+		}
+	case *ActionPut:
+		c := *t
+		if c.entry, err = h.openEntry(t.entry); err == nil || err == ErrNotRecipient {
+			opened = &c
+		}
+	case *ActionMod:
+		c := *t
+		if c.entry, err = h.openEntry(t.entry); err == nil || err == ErrNotRecipient {
+			opened = &c
+		}
+	}
+	return
+}
@@ -0,0 +1,158 @@
+package holochain
+
+import (
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestEncryptedEntries(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	Convey("encrypted entries should decrypt to what was encrypted", t, func() {
+		encrypted, err := h.encryptEntry(&GobEntry{C: "my note"}, []string{h.nodeIDStr})
+		So(err, ShouldBeNil)
+		e := encrypted.Content().(EncryptedEntry)
+		So(len(e.Recipients), ShouldEqual, 1)
+		So(e.Recipients[0].Recipient, ShouldEqual, h.nodeIDStr)
+		So(checkEncryptedEntry(e), ShouldBeNil)
+
+		decrypted, err := h.decryptEntry(encrypted)
+		So(err, ShouldBeNil)
+		So(decrypted.Content(), ShouldEqual, "my note")
+	})
+
+	Convey("entries not encrypted to us should not decrypt", t, func() {
+		encrypted, _ := h.encryptEntry(&GobEntry{C: "my note"}, nil)
+		e := encrypted.Content().(EncryptedEntry)
+		e.Recipients[0].Recipient = "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+		_, err := h.decryptEntry(&GobEntry{C: e})
+		So(err, ShouldEqual, ErrNotRecipient)
+
+		e.Nonce = e.Nonce[1:]
+		So(checkEncryptedEntry(e), ShouldEqual, ErrBadEncryptedEntry)
+	})
+
+	Convey("partial entries should be committed encrypted", t, func() {
+		hash := commit(h, "note", "my note")
+		entry, entryType, err := h.chain.GetEntry(hash)
+		So(err, ShouldBeNil)
+		So(entryType, ShouldEqual, "note")
+		_, ok := entry.Content().(EncryptedEntry)
+		So(ok, ShouldBeTrue)
+
+		options := GetOptions{StatusMask: StatusDefault, GetMask: GetMaskEntry}
+		req := GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+		r, err := NewGetAction(req, &options).Do(h)
+		So(err, ShouldBeNil)
+		So(r.(GetResp).Entry.C, ShouldEqual, "my note")
+
+		q, err := h.Query(&QueryOptions{Constrain: QueryConstrain{EntryTypes: []string{"note"}}})
+		So(err, ShouldBeNil)
+		So(q[0].Entry.Content(), ShouldEqual, "my note")
+	})
+
+	Convey("updates of partial entries should be committed encrypted and shared", t, func() {
+		hash := commit(h, "note", "my note")
+		r, err := NewModAction("note", &GobEntry{C: "my new note"}, hash).Do(h)
+		So(err, ShouldBeNil)
+		newHash := r.(Hash)
+		entry, _, err := h.chain.GetEntry(newHash)
+		So(err, ShouldBeNil)
+		_, ok := entry.Content().(EncryptedEntry)
+		So(ok, ShouldBeTrue)
+
+		So(h.dht.exists(newHash, StatusLive), ShouldBeNil)
+		So(h.dht.exists(hash, StatusModified), ShouldBeNil)
+		options := GetOptions{StatusMask: StatusLive, GetMask: GetMaskEntry}
+		req := GetReq{H: newHash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+		r, err = NewGetAction(req, &options).Do(h)
+		So(err, ShouldBeNil)
+		So(r.(GetResp).Entry.C, ShouldEqual, "my new note")
+	})
+
+	Convey("entries not encrypted to us should only be sys validated", t, func() {
+		encrypted, _ := h.encryptEntry(&GobEntry{C: ""}, nil)
+		e := encrypted.Content().(EncryptedEntry)
+		e.Recipients[0].Recipient = "QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1"
+
+		// the app would fail an empty note but can't see it
+		a := NewPutAction("note", &GobEntry{C: e}, nil)
+		_, err := h.ValidateAction(a, a.entryType, nil, []peer.ID{h.nodeID})
+		So(err, ShouldBeNil)
+
+		a = NewPutAction("review", &GobEntry{C: e}, nil)
+		_, err = h.ValidateAction(a, a.entryType, nil, []peer.ID{h.nodeID})
+		So(err, ShouldEqual, ValidationFailedErr)
+
+		e.Recipients = nil
+		a = NewPutAction("note", &GobEntry{C: e}, nil)
+		_, err = h.ValidateAction(a, a.entryType, nil, []peer.ID{h.nodeID})
+		So(err, ShouldEqual, ErrBadEncryptedEntry)
+	})
+}
+
+func TestEncryptedEntriesMultiNode(t *testing.T) {
+	nodesCount := 3
+	mt := setupSimMultiNodeTesting(nodesCount, 42)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	n, _, _ := h0.MakeRibosome("jsSampleZome")
+	_, err := n.Run(fmt.Sprintf(`commit("note","for h1",{Recipients:["%s"]})`, h1.nodeIDStr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := NewHash(n.(*JSRibosome).lastResult.String())
+	options := GetOptions{StatusMask: StatusDefault, GetMask: GetMaskEntry}
+	req := GetReq{H: hash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+
+	Convey("recipients should get the decrypted entry", t, func() {
+		for _, h := range []*Holochain{h0, h1} {
+			r, err := NewGetAction(req, &options).Do(h)
+			So(err, ShouldBeNil)
+			So(r.(GetResp).Entry.C, ShouldEqual, "for h1")
+		}
+	})
+
+	Convey("others should not", t, func() {
+		_, err := NewGetAction(req, &options).Do(h2)
+		So(err, ShouldEqual, ErrNotRecipient)
+
+		n, _, _ := h2.MakeRibosome("jsSampleZome")
+		_, err = n.Run(fmt.Sprintf(`get("%s")`, hash.String()))
+		So(err, ShouldBeNil)
+		So(n.(*JSRibosome).lastResult.String(), ShouldContainSubstring, ErrNotRecipient.Error())
+	})
+
+	Convey("updates should be validated by recipients and sys validated by others", t, func() {
+		_, err := n.Run(fmt.Sprintf(`update("note","for h1 again","%s",{Recipients:["%s"]})`, hash.String(), h1.nodeIDStr))
+		So(err, ShouldBeNil)
+		newHash, err := NewHash(n.(*JSRibosome).lastResult.String())
+		So(err, ShouldBeNil)
+		entry, _, err := h0.chain.GetEntry(newHash)
+		So(err, ShouldBeNil)
+		_, ok := entry.Content().(EncryptedEntry)
+		So(ok, ShouldBeTrue)
+		header, _ := h0.chain.GetEntryHeader(newHash)
+
+		for _, h := range []*Holochain{h1, h2} {
+			a := NewModAction("note", entry, hash)
+			a.header = header
+			_, err = h.ValidateAction(a, a.entryType, nil, []peer.ID{h0.nodeID})
+			So(err, ShouldBeNil)
+		}
+
+		req := GetReq{H: newHash, StatusMask: options.StatusMask, GetMask: options.GetMask}
+		r, err := NewGetAction(req, &options).Do(h1)
+		So(err, ShouldBeNil)
+		So(r.(GetResp).Entry.C, ShouldEqual, "for h1 again")
+	})
+}
@@ -0,0 +1,119 @@
+package peerqueue
+
+import (
+	"container/heap"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	// each failure to gossip with a peer pushes it this many distance bits further away
+	GossipFailurePenalty = 16
+
+	// each period of this length since a peer last failed forgives one of its failures, so
+	// peers that failed get tried again once they have had time to recover
+	GossipFailureDecay = time.Minute
+
+	// each period of this length since we last gossiped with a peer brings it one distance bit closer
+	GossipStalenessUnit = time.Second
+
+	// the most distance bits staleness can make up for, which is also the bonus for peers never gossiped with
+	GossipMaxStaleness = 64
+)
+
+// GossipStats holds what we know about our past gossiping with a peer; Failures counts the
+// failures since the last success, so when it isn't zero LastGossip is the last failure
+type GossipStats struct {
+	LastGossip time.Time
+	Failures   int
+}
+
+// gossipPQ implements heap.Interface and PeerQueue
+type gossipPQ struct {
+	// from is the Key this PQ measures against
+	from Hash
+
+	// stats returns the gossip stats of a peer
+	stats func(peer.ID) GossipStats
+
+	// now is the time staleness is measured against
+	now time.Time
+
+	// heap is a heap of peerMetric items
+	heap peerMetricHeap
+
+	sync.RWMutex
+}
+
+func (pq *gossipPQ) Len() int {
+	pq.Lock()
+	defer pq.Unlock()
+	return len(pq.heap)
+}
+
+// metric scores a peer by the number of bits in its XOR distance from the key, plus the
+// penalty for the failures not yet forgiven, less the staleness bonus; the lowest score is
+// the best peer to gossip with.  Peers with equal scores are ordered by their actual distance.
+func (pq *gossipPQ) metric(p peer.ID) *big.Int {
+	distance := HashXORDistance(HashFromPeerID(p), pq.from)
+	score := int64(distance.BitLen())
+	s := pq.stats(p)
+	failures := s.Failures
+	if failures > 0 {
+		failures -= int(pq.now.Sub(s.LastGossip) / GossipFailureDecay)
+		if failures < 0 {
+			failures = 0
+		}
+	}
+	score += int64(failures * GossipFailurePenalty)
+	staleness := int64(GossipMaxStaleness)
+	if !s.LastGossip.IsZero() {
+		staleness = int64(pq.now.Sub(s.LastGossip) / GossipStalenessUnit)
+		if staleness > GossipMaxStaleness {
+			staleness = GossipMaxStaleness
+		}
+	}
+	score -= staleness
+	metric := big.NewInt(score)
+	metric.Lsh(metric, uint(8*len(pq.from.H)))
+	return metric.Add(metric, distance)
+}
+
+func (pq *gossipPQ) Enqueue(p peer.ID) {
+	pq.Lock()
+	defer pq.Unlock()
+
+	heap.Push(&pq.heap, &peerMetric{
+		peer:   p,
+		metric: pq.metric(p),
+	})
+}
+
+func (pq *gossipPQ) Dequeue() peer.ID {
+	pq.Lock()
+	defer pq.Unlock()
+
+	if len(pq.heap) < 1 {
+		panic("called Dequeue on an empty PeerQueue")
+		// will panic internally anyway, but we can help debug here
+	}
+
+	o := heap.Pop(&pq.heap)
+	p := o.(*peerMetric)
+	return p.peer
+}
+
+// NewGossipPQ returns a PeerQueue which maintains its peers sorted by how good a choice
+// they are to gossip with: close in XOR distance to from, not gossiped with recently, and
+// not failing when we try.
+func NewGossipPQ(from Hash, stats func(peer.ID) GossipStats) PeerQueue {
+	return &gossipPQ{
+		from:  from,
+		stats: stats,
+		now:   time.Now(),
+		heap:  peerMetricHeap{},
+	}
+}
@@ -67,6 +67,55 @@ func TestQueue(t *testing.T) {
 	}
 }
 
+func TestGossipQueue(t *testing.T) {
+	h1, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh1")
+	h2, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh2")
+	h3, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh3")
+	h4, _ := NewHash("QmY8Mzg9F69e5P9AoQPYat655HEhc1TVGs11tmfNSzkqh4")
+
+	p2 := PeerIDFromHash(h2)
+	p3 := PeerIDFromHash(h3)
+	p4 := PeerIDFromHash(h4)
+
+	stats := make(map[peer.ID]GossipStats)
+	order := func() []peer.ID {
+		pq := NewGossipPQ(h1, func(p peer.ID) GossipStats { return stats[p] })
+		pq.Enqueue(p4)
+		pq.Enqueue(p3)
+		pq.Enqueue(p2)
+		return []peer.ID{pq.Dequeue(), pq.Dequeue(), pq.Dequeue()}
+	}
+
+	// with no stats the ordering is by distance
+	o := order()
+	if o[0] != p2 || o[1] != p3 || o[2] != p4 {
+		t.Error("ordering by distance failed")
+	}
+
+	// a peer we just gossiped with should go to the back
+	stats[p2] = GossipStats{LastGossip: time.Now()}
+	o = order()
+	if o[2] != p2 {
+		t.Error("ordering by staleness failed")
+	}
+
+	// a failing peer should go to the back even if we haven't gossiped with it for a while
+	stats[p2] = GossipStats{LastGossip: time.Now().Add(-time.Hour)}
+	stats[p3] = GossipStats{LastGossip: time.Now().Add(-2 * time.Minute), Failures: 3}
+	stats[p4] = GossipStats{LastGossip: time.Now().Add(-time.Hour)}
+	o = order()
+	if o[0] != p2 || o[1] != p4 || o[2] != p3 {
+		t.Error("ordering by failures failed")
+	}
+
+	// but once its failures have been forgiven it should be picked again
+	stats[p3] = GossipStats{LastGossip: time.Now().Add(-time.Hour), Failures: 3}
+	o = order()
+	if o[0] != p2 || o[1] != p3 || o[2] != p4 {
+		t.Error("failures weren't forgiven")
+	}
+}
+
 func newPeerTime(t time.Time) peer.ID {
 	s := fmt.Sprintf("hmmm time: %v", t)
 	h, _ := mh.Sum([]byte(s), mh.SHA2_256, -1)
@@ -0,0 +1,360 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// indexes of the local chain for queries.  The positions of each entry type's entries are
+// indexed, as are the values of the JSON fields listed in each EntryDef's Indexes, so that
+// queries on them don't have to read and parse every entry.  The indexes are kept in
+// memory, built on the first query and brought up to date with the chain on each one after.
+// Query results can also be aggregated here, which is much faster than doing it in a zome.
+
+package holochain
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+)
+
+// queryIndex holds the indexes of a chain
+type queryIndex struct {
+	lk      sync.Mutex
+	chain   *Chain
+	indexed int                               // how many of the chain's entries are indexed
+	types   map[string][]int                  // positions of the entries of each type
+	fields  map[string]map[string]*fieldIndex // the indexed fields of each type
+}
+
+// fieldIndex holds the values of a JSON field of the entries of a type
+type fieldIndex struct {
+	values map[int]interface{} // value of the field in the entry at each position
+	equal  map[string][]int    // positions of the entries with each value, keyed by its JSON
+}
+
+// valueKey returns the key a field value is indexed under
+func valueKey(value interface{}) (key string, err error) {
+	var b []byte
+	b, err = json.Marshal(value)
+	key = string(b)
+	return
+}
+
+// queryIndex returns the indexes of the chain, updated with any entries added since the
+// last query
+func (h *Holochain) queryIndex() (q *queryIndex, err error) {
+	q = h.queries
+	if q == nil || q.chain != h.chain {
+		q = &queryIndex{chain: h.chain, types: make(map[string][]int), fields: make(map[string]map[string]*fieldIndex)}
+		h.queries = q
+	}
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	l := h.chain.Length()
+	for i := q.indexed; i < l; i++ {
+		header := h.chain.Headers[i]
+		q.types[header.Type] = append(q.types[header.Type], i)
+
+		var def *EntryDef
+		if _, def, err = h.GetEntryDef(header.Type); err != nil {
+			return
+		}
+		// deletions hold what was deleted rather than an entry of their type
+		if len(def.Indexes) == 0 || def.DataFormat != DataFormatJSON || header.Change.Action == DelAction {
+			continue
+		}
+		var entry Entry
+		if entry, err = h.chain.Entry(i); err == nil {
+			entry, err = h.openEntry(entry)
+		}
+		if err != nil {
+			return
+		}
+		var content map[string]interface{}
+		if json.Unmarshal([]byte(entry.Content().(string)), &content) != nil {
+			continue
+		}
+		fields := q.fields[header.Type]
+		if fields == nil {
+			fields = make(map[string]*fieldIndex)
+			q.fields[header.Type] = fields
+		}
+		for _, name := range def.Indexes {
+			value, ok := content[name]
+			if !ok {
+				continue
+			}
+			f := fields[name]
+			if f == nil {
+				f = &fieldIndex{values: make(map[int]interface{}), equal: make(map[string][]int)}
+				fields[name] = f
+			}
+			var key string
+			if key, err = valueKey(value); err != nil {
+				return
+			}
+			f.values[i] = value
+			f.equal[key] = append(f.equal[key], i)
+		}
+	}
+	q.indexed = l
+	return
+}
+
+// lookup returns the index of a field of a type, with ok false if the type doesn't declare
+// the field indexed, and nil if no entries have it yet
+func (q *queryIndex) lookup(h *Holochain, entryType string, field string) (f *fieldIndex, ok bool) {
+	_, def, err := h.GetEntryDef(entryType)
+	if err != nil || def.DataFormat != DataFormatJSON {
+		return
+	}
+	for _, name := range def.Indexes {
+		if name == field {
+			ok = true
+			if fields := q.fields[entryType]; fields != nil {
+				f = fields[field]
+			}
+			return
+		}
+	}
+	return
+}
+
+// candidates returns the positions of the entries a query could match in chain order, using
+// the type and field indexes when the query is constrained to types, and returns true for
+// equalsDone if the Equals constraint was answered from the field indexes
+func (q *queryIndex) candidates(h *Holochain, constrain *QueryConstrain) (positions []int, equalsDone bool, err error) {
+	if len(constrain.EntryTypes) == 0 {
+		positions = make([]int, q.indexed)
+		for i := range positions {
+			positions[i] = i
+		}
+		return
+	}
+
+	var equals map[string]interface{}
+	if constrain.Equals != "" {
+		if json.Unmarshal([]byte(constrain.Equals), &equals) == nil && len(equals) > 0 {
+			equalsDone = true
+			for _, t := range constrain.EntryTypes {
+				for name := range equals {
+					if _, ok := q.lookup(h, t, name); !ok {
+						equalsDone = false
+					}
+				}
+			}
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, t := range constrain.EntryTypes {
+		if !equalsDone {
+			for _, i := range q.types[t] {
+				seen[i] = true
+			}
+			continue
+		}
+		// entries match if any of the fields are equal
+		for name, value := range equals {
+			f, _ := q.lookup(h, t, name)
+			if f == nil {
+				continue
+			}
+			var key string
+			if key, err = valueKey(value); err != nil {
+				return
+			}
+			for _, i := range f.equal[key] {
+				seen[i] = true
+			}
+		}
+	}
+	for i := range seen {
+		positions = append(positions, i)
+	}
+	sort.Ints(positions)
+	return
+}
+
+// compareValues orders JSON values, numbers before strings before anything else
+func compareValues(a interface{}, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case float64:
+			return 0
+		case string:
+			return 1
+		}
+		return 2
+	}
+	ra, rb := rank(a), rank(b)
+	if ra != rb {
+		return ra - rb
+	}
+	switch av := a.(type) {
+	case float64:
+		bv := b.(float64)
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	case string:
+		bv := b.(string)
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+	}
+	return 0
+}
+
+// fieldValues returns the values of a field in query results, which are looked up in the
+// field indexes or else read from the entries' content, nil where a result doesn't have it
+func (q *queryIndex) fieldValues(h *Holochain, results []QueryResult, positions []int, field string) (values []interface{}, err error) {
+	values = make([]interface{}, len(results))
+	for r := range results {
+		header := results[r].Header
+		if f, ok := q.lookup(h, header.Type, field); ok {
+			if f != nil {
+				values[r] = f.values[positions[r]]
+			}
+			continue
+		}
+		var entry Entry
+		if entry, err = h.chain.Entry(positions[r]); err == nil {
+			entry, err = h.openEntry(entry)
+		}
+		if err != nil {
+			return
+		}
+		if s, ok := entry.Content().(string); ok {
+			var content map[string]interface{}
+			if json.Unmarshal([]byte(s), &content) == nil {
+				values[r] = content[field]
+			}
+		}
+	}
+	return
+}
+
+// sortResults sorts query results on the values of a field
+func (q *queryIndex) sortResults(h *Holochain, results []QueryResult, positions []int, field string, ascending bool) (err error) {
+	var values []interface{}
+	if values, err = q.fieldValues(h, results, positions, field); err != nil {
+		return
+	}
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := values[order[i]], values[order[j]]
+		// missing values go last either way
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		c := compareValues(a, b)
+		if ascending {
+			return c < 0
+		}
+		return c > 0
+	})
+	sorted := make([]QueryResult, len(results))
+	for i, o := range order {
+		sorted[i] = results[o]
+	}
+	copy(results, sorted)
+	return
+}
+
+// QueryAggregate runs a query and returns the aggregates asked for in its options, one
+// group if the results aren't grouped, or one per group in the order they first appear
+func (h *Holochain) QueryAggregate(options *QueryOptions) (groups []QueryGroup, err error) {
+	var q *queryIndex
+	if q, err = h.queryIndex(); err != nil {
+		return
+	}
+	q.lk.Lock()
+	defer q.lk.Unlock()
+
+	// only the headers are needed, field values come from the indexes or the chain
+	opts := *options
+	opts.Return = QueryReturn{Headers: true}
+	var results []QueryResult
+	var positions []int
+	if results, positions, err = h.query(q, &opts); err != nil {
+		return
+	}
+	agg := &options.Aggregate
+	fields := make(map[string][]interface{})
+	values := func(field string) (v []interface{}, err error) {
+		if field == "" {
+			return
+		}
+		var ok bool
+		if v, ok = fields[field]; !ok {
+			if v, err = q.fieldValues(h, results, positions, field); err == nil {
+				fields[field] = v
+			}
+		}
+		return
+	}
+	var keys, sums, mins, maxes []interface{}
+	if keys, err = values(agg.GroupBy); err != nil {
+		return
+	}
+	if sums, err = values(agg.Sum); err != nil {
+		return
+	}
+	if mins, err = values(agg.Min); err != nil {
+		return
+	}
+	if maxes, err = values(agg.Max); err != nil {
+		return
+	}
+
+	grouped := agg.GroupByType || agg.GroupBy != ""
+	if !grouped {
+		groups = []QueryGroup{{}}
+	}
+	index := make(map[string]int)
+	for r, result := range results {
+		var g int
+		if grouped {
+			var key interface{}
+			switch {
+			case agg.GroupByType && keys != nil:
+				key = []interface{}{result.Header.Type, keys[r]}
+			case agg.GroupByType:
+				key = result.Header.Type
+			default:
+				key = keys[r]
+			}
+			var k string
+			if k, err = valueKey(key); err != nil {
+				return
+			}
+			var ok bool
+			if g, ok = index[k]; !ok {
+				g = len(groups)
+				index[k] = g
+				groups = append(groups, QueryGroup{Key: key})
+			}
+		}
+		group := &groups[g]
+		group.Count++
+		if sums != nil {
+			if n, ok := sums[r].(float64); ok {
+				group.Sum += n
+			}
+		}
+		if mins != nil && mins[r] != nil && (group.Min == nil || compareValues(mins[r], group.Min) < 0) {
+			group.Min = mins[r]
+		}
+		if maxes != nil && maxes[r] != nil && (group.Max == nil || compareValues(maxes[r], group.Max) > 0) {
+			group.Max = maxes[r]
+		}
+	}
+	return
+}
@@ -22,6 +22,7 @@ const (
 
 	STRING_CALLING = "string"
 	JSON_CALLING   = "json"
+	BINARY_CALLING = "binary" // the params and result are raw bytes passed as byte lists
 
 	// exposure types for functions
 
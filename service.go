@@ -41,7 +41,9 @@ const (
 	StoreFileName        string = "chain.db"    // Filename for local data store
 	DNAHashFileName      string = "dna.hash"    // Filename for storing the hash of the holochain
 	DHTStoreFileName     string = "dht.db"      // Filname for storing the dht
+	DHTBoltStoreFileName string = "dht.bolt"    // Filname for storing the dht with the bolt store
 	BridgeDBFileName     string = "bridge.db"   // Filname for storing bridge keys
+	RestKeyFileName      string = "rest.key"    // Filename for storing the key chain and dht data is encrypted with
 
 	TestConfigFileName string = "_config.json"
 
@@ -472,6 +474,8 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 	if err != nil {
 		return
 	}
+	// the passphrase is never saved with the config, it comes from the environment
+	h.Config.Passphrase = os.Getenv(PassphraseEnvVar)
 	if err = h.Config.Setup(); err != nil {
 		return
 	}
@@ -508,8 +512,7 @@ func (s *Service) load(name string, format string) (hP *Holochain, err error) {
 		return
 	}
 
-	h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
-	if err != nil {
+	if err = h.openChain(); err != nil {
 		return
 	}
 
@@ -565,8 +568,7 @@ func gen(root string, initDB bool, makeH func(root string) (hP *Holochain, err e
 			return nil, err
 		}
 
-		h.chain, err = NewChainFromFile(h.hashSpec, filepath.Join(h.DBPath(), StoreFileName))
-		if err != nil {
+		if err = h.openChain(); err != nil {
 			return nil, err
 		}
 	}
@@ -634,6 +636,36 @@ func _makeConfig(s *Service) (config Config, err error) {
 		Debugf("makeConfig: using environment variable to set enableNATUPnP to: %s", val)
 		config.EnableNATUPnP = val == "true"
 	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_DHTSTORE")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set DHT store to: %s", val)
+		config.DHTStoreType = val
+	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_GOSSIPMODE")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set gossip mode to: %s", val)
+		config.GossipMode = val
+	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_STORAGEQUOTA")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set storage quota to: %s", val)
+		config.StorageQuota, err = strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return
+		}
+	}
+
+	val = os.Getenv("HOLOCHAINCONFIG_WIRECODECS")
+	if val != "" {
+		Debugf("makeConfig: using environment variable to set wire codecs to: %s", val)
+		config.WireCodecs, err = ParseWireCodecs(val)
+		if err != nil {
+			return
+		}
+	}
 	return
 }
 
@@ -642,19 +674,24 @@ func makeConfig(h *Holochain, s *Service) (err error) {
 	if err != nil {
 		return
 	}
+	if err = h.saveConfig(); err != nil {
+		return
+	}
+	if err = h.Config.Setup(); err != nil {
+		return
+	}
+	return
+}
+
+// saveConfig writes a holochain's config to its config file
+func (h *Holochain) saveConfig() (err error) {
 	p := filepath.Join(h.rootPath, ConfigFileName+"."+h.encodingFormat)
 	f, err := os.Create(p)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-
-	if err = Encode(f, h.encodingFormat, &h.Config); err != nil {
-		return
-	}
-	if err = h.Config.Setup(); err != nil {
-		return
-	}
+	err = Encode(f, h.encodingFormat, &h.Config)
 	return
 }
 
@@ -1359,7 +1396,8 @@ func TestingAppAppPackage() string {
                     "Name": "profile",
                     "DataFormat": "json",
                     "Schema": "` + jsSanitizeString(profileSchema) + `",
-                    "Sharing": "public"
+                    "Sharing": "public",
+                    "Indexes": ["lastName"]
                 },
                 {
                   "Name": "privateData",
@@ -1430,7 +1468,8 @@ func TestingAppAppPackage() string {
                     "Name": "profile",
                     "DataFormat": "json",
                     "Schema": "` + jsSanitizeString(profileSchema) + `",
-                    "Sharing": "public"
+                    "Sharing": "public",
+                    "Indexes": ["lastName"]
                 },
                 {
                     "Name": "rating",
@@ -1444,6 +1483,16 @@ func TestingAppAppPackage() string {
                 {
                     "Name": "secret",
                     "DataFormat": "string"
+                },
+                {
+                    "Name": "image",
+                    "DataFormat": "binary",
+                    "Sharing": "public"
+                },
+                {
+                    "Name": "note",
+                    "DataFormat": "string",
+                    "Sharing": "partial"
                 }
             ],
             "Functions": [
@@ -1462,6 +1511,11 @@ func TestingAppAppPackage() string {
                     "CallingType": "json",
                     "Exposure": "public"
                 },
+                {
+                    "Name": "addImage",
+                    "CallingType": "binary",
+                    "Exposure": "public"
+                },
                 {
                     "Name": "testStrFn1",
                     "CallingType": "string",
@@ -1682,6 +1736,7 @@ function testJsonFn2(x){ return [{a:'b'}] };
 function getProperty(x) {return property(x)};
 function addOdd(x) {return commit("oddNumbers",x);}
 function addProfile(x) {return commit("profile",x);}
+function addImage(x) {return get(commit("image",x));}
 function validatePut(entry_type,entry,header,pkg,sources) {
   return validate(entry_type,entry,header,sources);
 }
@@ -1708,6 +1763,12 @@ function validate(entry_type,entry,header,sources) {
   if (entry_type=="secret") {
     return true
   }
+  if (entry_type=="image") {
+    return entry.length > 0
+  }
+  if (entry_type=="note") {
+    return entry != ""
+  }
   return false
 }
 function validateLink(linkEntryType,baseHash,linkHash,tag,pkg,sources){return true}
@@ -0,0 +1,112 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// Transport defines how a node delivers messages to its peers, and implements it over libp2p
+
+package holochain
+
+import (
+	"context"
+	"errors"
+	net "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	rhost "github.com/libp2p/go-libp2p/p2p/host/routed"
+)
+
+// TransportHandler is called with each message that arrives for a protocol, along with any
+// error receiving it, and returns the response to send back, which transports encode with
+// the same codec as the message
+type TransportHandler func(from peer.ID, m *Message, err error) (response *Message)
+
+// Transport is the means by which a node exchanges messages with other nodes
+type Transport interface {
+	// SetHandler registers the function that handles messages arriving for the protocol
+	SetHandler(proto protocol.ID, fn TransportHandler)
+
+	// Request sends a message encoded with the codec to a peer via the protocol and returns
+	// its response
+	Request(ctx context.Context, to peer.ID, proto protocol.ID, m *Message, codec Codec) (response Message, err error)
+
+	// Connect makes sure the peer can be reached
+	Connect(ctx context.Context, pi pstore.PeerInfo) (err error)
+
+	// Connected returns true if there is a live connection to the peer
+	Connected(id peer.ID) bool
+
+	// Close shuts down the transport
+	Close() (err error)
+}
+
+// libP2PTransport is the Transport implemented with libp2p streams
+type libP2PTransport struct {
+	host *rhost.RoutedHost
+	log  *Logger
+}
+
+// SetHandler implements Transport
+func (t *libP2PTransport) SetHandler(proto protocol.ID, fn TransportHandler) {
+	t.host.SetStreamHandler(proto, func(s net.Stream) {
+		m, codec, err := DecodeMessage(s)
+		response := fn(s.Conn().RemotePeer(), &m, err)
+
+		data, err := EncodeMessage(response, codec)
+		if err != nil {
+			Infof("Response failed: unable to encode message: %v", response)
+		}
+		_, err = s.Write(data)
+		if err != nil {
+			Infof("Response failed: write returned error: %v", err)
+		}
+	})
+}
+
+// Request implements Transport
+func (t *libP2PTransport) Request(ctx context.Context, to peer.ID, proto protocol.ID, m *Message, codec Codec) (response Message, err error) {
+	s, err := t.host.NewStream(ctx, to, proto)
+	if err != nil {
+		return
+	}
+	defer s.Close()
+
+	// encode the message and send it
+	data, err := EncodeMessage(m, codec)
+	if err != nil {
+		return
+	}
+
+	n, err := s.Write(data)
+	if err != nil {
+		return
+	}
+	if n != len(data) {
+		err = errors.New("unable to send all data")
+	}
+
+	// decode the response
+	response, _, err = DecodeMessage(s)
+	if err != nil {
+		t.log.Logf("failed to decode with err:%v ", err)
+		return
+	}
+	return
+}
+
+// Connect implements Transport
+func (t *libP2PTransport) Connect(ctx context.Context, pi pstore.PeerInfo) (err error) {
+	err = t.host.Connect(ctx, pi)
+	return
+}
+
+// Connected implements Transport
+func (t *libP2PTransport) Connected(id peer.ID) bool {
+	return len(t.host.Network().ConnsToPeer(id)) > 0
+}
+
+// Close implements Transport
+func (t *libP2PTransport) Close() (err error) {
+	err = t.host.Close()
+	return
+}
@@ -0,0 +1,301 @@
+// Copyright (C) 2013-2017, The MetaCurrency Project (Eric Harris-Braun, Arthur Brock, et. al.)
+// Use of this source code is governed by GPLv3 found in the LICENSE file
+//----------------------------------------------------------------------------------------
+
+// simulated in-process network transport, so that many holochain nodes can be run and tested
+// in a single process with controllable latency, packet loss and network partitions
+
+package holochain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	goprocessctx "github.com/jbenet/goprocess/context"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	protocol "github.com/libp2p/go-libp2p-protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrSimUnreachable = errors.New("peer unreachable on simulated network")
+var ErrSimPacketLost = errors.New("message lost on simulated network")
+
+// simLink identifies the connection between two peers on a SimNetwork
+type simLink struct {
+	a, b peer.ID
+}
+
+func makeSimLink(a, b peer.ID) simLink {
+	if peer.IDB58Encode(a) > peer.IDB58Encode(b) {
+		a, b = b, a
+	}
+	return simLink{a, b}
+}
+
+// SimNetwork is an in-process network connecting the nodes created on it with NewSimNode
+type SimNetwork struct {
+	lk         sync.Mutex
+	rand       *rand.Rand
+	transports map[peer.ID]*SimTransport
+	nodes      int
+	latency    time.Duration
+	links      map[simLink]time.Duration
+	loss       float64
+	partitions map[peer.ID]int
+
+	// counts of messages delivered and dropped
+	Delivered int
+	Dropped   int
+}
+
+// SimTransport is the Transport of a node on a SimNetwork
+type SimTransport struct {
+	lk       sync.RWMutex
+	net      *SimNetwork
+	node     *Node
+	handlers map[protocol.ID]TransportHandler
+}
+
+// NewSimNetwork creates a simulated network whose packet loss is decided by a random
+// source with the given seed, so that test runs can be repeated
+func NewSimNetwork(seed int64) (n *SimNetwork) {
+	n = &SimNetwork{
+		rand:       rand.New(rand.NewSource(seed)),
+		transports: make(map[peer.ID]*SimTransport),
+		links:      make(map[simLink]time.Duration),
+		partitions: make(map[peer.ID]int),
+	}
+	return
+}
+
+// SetLatency sets the one way delay of messages between any two nodes
+func (n *SimNetwork) SetLatency(latency time.Duration) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.latency = latency
+}
+
+// SetLinkLatency sets the one way delay of messages between two particular nodes
+func (n *SimNetwork) SetLinkLatency(a, b peer.ID, latency time.Duration) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.links[makeSimLink(a, b)] = latency
+}
+
+// SetLoss sets the probability, from 0 to 1, that a message is lost
+func (n *SimNetwork) SetLoss(loss float64) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.loss = loss
+}
+
+// Partition splits the network so that nodes can only reach nodes in the same group,
+// nodes not in any of the groups can still reach each other
+func (n *SimNetwork) Partition(groups ...[]peer.ID) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	n.partitions = make(map[peer.ID]int)
+	for i, group := range groups {
+		for _, id := range group {
+			n.partitions[id] = i + 1
+		}
+	}
+}
+
+// Heal removes all partitions
+func (n *SimNetwork) Heal() {
+	n.Partition()
+}
+
+// reachable returns the transport of a peer if it can be reached from another,
+// assumes the lock is held
+func (n *SimNetwork) reachable(from, to peer.ID) (t *SimTransport, err error) {
+	t, ok := n.transports[to]
+	if !ok || n.partitions[from] != n.partitions[to] {
+		err = ErrSimUnreachable
+	}
+	return
+}
+
+// route decides the fate of a message sent from one peer to another, returning the
+// transport it's delivered to and how long it takes to get there
+func (n *SimNetwork) route(from, to peer.ID) (t *SimTransport, latency time.Duration, err error) {
+	n.lk.Lock()
+	defer n.lk.Unlock()
+	t, err = n.reachable(from, to)
+	if err == nil && n.loss > 0 && n.rand.Float64() < n.loss {
+		err = ErrSimPacketLost
+	}
+	if err != nil {
+		n.Dropped++
+		return
+	}
+	n.Delivered++
+	latency, ok := n.links[makeSimLink(from, to)]
+	if !ok {
+		latency = n.latency
+	}
+	return
+}
+
+// simDelay waits for the latency to pass or the context to be done
+func simDelay(ctx context.Context, latency time.Duration) (err error) {
+	if latency <= 0 {
+		return
+	}
+	select {
+	case <-time.After(latency):
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// simTransfer copies a message the way it would be copied by sending it over the wire
+func simTransfer(m *Message, codec Codec) (c Message, used Codec, err error) {
+	var data []byte
+	data, err = EncodeMessage(m, codec)
+	if err != nil {
+		return
+	}
+	c, used, err = DecodeMessage(bytes.NewReader(data))
+	return
+}
+
+// NewSimNode creates a new node on the simulated network with the given identity
+func NewSimNode(network *SimNetwork, protoMux string, agent *LibP2PAgent, log *Logger) (node *Node, err error) {
+	var n Node
+	n.log = log
+	n.log.Logf("Creating new simulated node with protoMux: %s\n", protoMux)
+	nodeID, _, err := agent.NodeID()
+	if err != nil {
+		return
+	}
+	n.log.Logf("NodeID is: %v\n", nodeID)
+
+	network.lk.Lock()
+	if _, exists := network.transports[nodeID]; exists {
+		network.lk.Unlock()
+		err = fmt.Errorf("node %v already on simulated network", nodeID)
+		return
+	}
+	network.nodes++
+	i := network.nodes
+	t := &SimTransport{net: network, node: &n, handlers: make(map[protocol.ID]TransportHandler)}
+	network.transports[nodeID] = t
+	network.lk.Unlock()
+
+	// sim nodes don't listen anywhere but other code expects active peers to have addresses
+	n.NetAddr, err = ma.NewMultiaddr(fmt.Sprintf("/ip4/10.%d.%d.%d/tcp/1", (i>>16)&255, (i>>8)&255, i&255))
+	if err != nil {
+		return
+	}
+
+	ps := pstore.NewPeerstore()
+	n.peerstore = ps
+	ps.AddAddrs(nodeID, []ma.Multiaddr{n.NetAddr}, pstore.PermanentAddrTTL)
+
+	n.HashAddr = nodeID
+	priv := agent.PrivKey()
+	ps.AddPrivKey(nodeID, priv)
+	ps.AddPubKey(nodeID, priv.GetPublic())
+
+	n.initProtocols(protoMux)
+
+	n.ctx = context.Background()
+	n.transport = t
+
+	m := pstore.NewMetrics()
+	n.routingTable = NewRoutingTable(KValue, nodeID, time.Minute, m)
+	n.peers = make(map[peer.ID]*peerTracker)
+
+	n.proc = goprocessctx.WithContextAndTeardown(n.ctx, func() error {
+		return n.transport.Close()
+	})
+
+	node = &n
+	return
+}
+
+// SetHandler implements Transport
+func (t *SimTransport) SetHandler(proto protocol.ID, fn TransportHandler) {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	t.handlers[proto] = fn
+}
+
+// handler returns the handler for a protocol
+func (t *SimTransport) handler(proto protocol.ID) (fn TransportHandler, err error) {
+	t.lk.RLock()
+	defer t.lk.RUnlock()
+	fn, ok := t.handlers[proto]
+	if !ok {
+		err = fmt.Errorf("protocol %s not supported by %v", proto, t.node.HashAddr)
+	}
+	return
+}
+
+// Request implements Transport
+func (t *SimTransport) Request(ctx context.Context, to peer.ID, proto protocol.ID, m *Message, codec Codec) (response Message, err error) {
+	from := t.node.HashAddr
+	remote, latency, err := t.net.route(from, to)
+	if err != nil {
+		return
+	}
+	if err = simDelay(ctx, latency); err != nil {
+		return
+	}
+	fn, err := remote.handler(proto)
+	if err != nil {
+		return
+	}
+
+	req, used, e := simTransfer(m, codec)
+	r := fn(from, &req, e)
+
+	_, latency, err = t.net.route(to, from)
+	if err != nil {
+		return
+	}
+	if err = simDelay(ctx, latency); err != nil {
+		return
+	}
+	response, _, err = simTransfer(r, used)
+	return
+}
+
+// Connect implements Transport
+func (t *SimTransport) Connect(ctx context.Context, pi pstore.PeerInfo) (err error) {
+	from := t.node.HashAddr
+	t.net.lk.Lock()
+	remote, err := t.net.reachable(from, pi.ID)
+	t.net.lk.Unlock()
+	if err != nil {
+		return
+	}
+	// as with libp2p connection notifications both sides learn of each other
+	t.node.routingTable.Update(pi.ID)
+	remote.node.routingTable.Update(from)
+	return
+}
+
+// Connected implements Transport
+func (t *SimTransport) Connected(id peer.ID) bool {
+	t.net.lk.Lock()
+	defer t.net.lk.Unlock()
+	_, err := t.net.reachable(t.node.HashAddr, id)
+	return err == nil
+}
+
+// Close implements Transport
+func (t *SimTransport) Close() (err error) {
+	t.net.lk.Lock()
+	defer t.net.lk.Unlock()
+	delete(t.net.transports, t.node.HashAddr)
+	return
+}
@@ -0,0 +1,105 @@
+package holochain
+
+import (
+	"context"
+	"fmt"
+	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestSimNetwork(t *testing.T) {
+	nodesCount := 3
+	mt := setupSimMultiNodeTesting(nodesCount, 42)
+	defer mt.cleanupMultiNodeTesting()
+	nodes := mt.nodes
+	h0 := nodes[0]
+	h1 := nodes[1]
+	h2 := nodes[2]
+	ringConnect(t, mt.ctx, nodes, nodesCount)
+
+	Convey("sim nodes should connect and fill their routing tables", t, func() {
+		So(h0.node.transport.Connected(h1.nodeID), ShouldBeTrue)
+		So(h0.node.routingTable.Find(h1.nodeID), ShouldEqual, h1.nodeID)
+		So(h1.node.routingTable.Find(h0.nodeID), ShouldEqual, h0.nodeID)
+	})
+
+	Convey("sim nodes should exchange messages", t, func() {
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		r, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, OK_RESPONSE)
+		So(r.From, ShouldEqual, h1.nodeID)
+		So(fmt.Sprintf("%T", r.Body), ShouldEqual, "holochain.Gossip")
+
+		m = h0.node.NewMessage(PUT_REQUEST, "fish")
+		r, err = h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+		So(r.Type, ShouldEqual, ERROR_RESPONSE)
+		So(r.Body.(ErrorResponse).Message, ShouldEqual, "message type 2 not in holochain-gossip protocol")
+	})
+
+	Convey("sim nodes should gossip", t, func() {
+		So(len(h0.dht.gossipPuts), ShouldEqual, 0)
+		err := h0.dht.gossipWith(h1.nodeID)
+		So(err, ShouldBeNil)
+		So(len(h0.dht.gossipPuts), ShouldEqual, 2)
+	})
+
+	Convey("partitioned nodes should not reach each other", t, func() {
+		mt.net.Partition([]peer.ID{h0.nodeID}, []peer.ID{h1.nodeID, h2.nodeID})
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldEqual, ErrSimUnreachable)
+		So(h0.node.transport.Connected(h1.nodeID), ShouldBeFalse)
+
+		m = h1.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err = h1.node.Send(context.Background(), GossipProtocol, h2.nodeID, m)
+		So(err, ShouldBeNil)
+
+		mt.net.Heal()
+		m = h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err = h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+	})
+
+	Convey("messages should be lost", t, func() {
+		dropped := mt.net.Dropped
+		mt.net.SetLoss(1)
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldEqual, ErrSimPacketLost)
+		So(mt.net.Dropped, ShouldEqual, dropped+1)
+		mt.net.SetLoss(0)
+	})
+
+	Convey("messages should be delayed by latency", t, func() {
+		mt.net.SetLatency(20 * time.Millisecond)
+		mt.net.SetLinkLatency(h0.nodeID, h2.nodeID, 50*time.Millisecond)
+
+		start := time.Now()
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := h0.node.Send(context.Background(), GossipProtocol, h1.nodeID, m)
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+
+		start = time.Now()
+		_, err = h0.node.Send(context.Background(), GossipProtocol, h2.nodeID, m)
+		So(err, ShouldBeNil)
+		So(time.Since(start), ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = h0.node.Send(ctx, GossipProtocol, h1.nodeID, m)
+		So(err, ShouldEqual, context.DeadlineExceeded)
+		mt.net.SetLatency(0)
+	})
+
+	Convey("closed nodes should be unreachable", t, func() {
+		h2.node.Close()
+		m := h0.node.NewMessage(GOSSIP_REQUEST, GossipReq{})
+		_, err := h0.node.Send(context.Background(), GossipProtocol, h2.nodeID, m)
+		So(err, ShouldEqual, ErrSimUnreachable)
+	})
+}
@@ -13,10 +13,13 @@ import (
 	"fmt"
 	websocket "github.com/gorilla/websocket"
 	holo "github.com/metacurrency/holochain"
+	hash "github.com/metacurrency/holochain/hash"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 type WebServer struct {
@@ -59,6 +62,8 @@ func (ws *WebServer) Start() {
 			ws.errs.Logf(err.Error())
 			return
 		}
+		s := &sock{conn: conn, subs: make(map[int]bool)}
+		defer s.unsubscribeAll(ws.h.DHT())
 
 		for {
 			var v map[string]string
@@ -70,17 +75,30 @@ func (ws *WebServer) Start() {
 				ws.errs.Log(err)
 				return
 			}
-			zome := v["zome"]
-			function := v["fn"]
-			result, err := ws.call(zome, function, v["arg"])
-			switch t := result.(type) {
-			case string:
-				err = conn.WriteMessage(websocket.TextMessage, []byte(t))
-			case []byte:
-				err = conn.WriteMessage(websocket.TextMessage, t)
-				//err = conn.WriteJSON(t)
-			default:
-				err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
+			if base, ok := v["subscribe"]; ok {
+				err = s.subscribe(ws.h.DHT(), base, v["tag"])
+			} else if id, ok := v["unsubscribe"]; ok {
+				err = s.unsubscribe(ws.h.DHT(), id)
+			} else {
+				zome := v["zome"]
+				function := v["fn"]
+				var result interface{}
+				result, err = ws.call(zome, function, v["arg"])
+				if err != nil {
+					// the call failing is the client's business, not a reason to drop it
+					ws.log.Logf("call of %s:%s resulted in error: %v\n", zome, function, err)
+					err = s.writeJSON(sockReply{Error: err.Error()})
+				} else {
+					switch t := result.(type) {
+					case string:
+						err = s.write(websocket.TextMessage, []byte(t))
+					case []byte:
+						err = s.write(websocket.TextMessage, t)
+						//err = conn.WriteJSON(t)
+					default:
+						err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
+					}
+				}
 			}
 
 			if err != nil {
@@ -134,7 +152,9 @@ func (ws *WebServer) Start() {
 		case string:
 			fmt.Fprint(w, t)
 		case []byte:
-			fmt.Fprint(w, string(t))
+			// results of binary functions are passed through raw
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(t)
 		default:
 			err = fmt.Errorf("Unknown type from Call of %s:%s", zome, function)
 		}
@@ -232,3 +252,74 @@ func (ws *WebServer) call(zome string, function string, args string) (result int
 	}
 	return
 }
+
+// sock is a websocket connection along with the DHT subscriptions made over it, whose
+// events are written to it as they happen
+type sock struct {
+	conn *websocket.Conn
+	lk   sync.Mutex // only one write at a time is allowed on a websocket
+	subs map[int]bool
+}
+
+// sockReply is what's written to a websocket in answer to a subscribe or unsubscribe, and
+// for each event of a subscription
+type sockReply struct {
+	Subscription int
+	Event        *holo.DHTEvent `json:",omitempty"`
+	Error        string         `json:",omitempty"`
+}
+
+func (s *sock) write(messageType int, data []byte) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.conn.WriteMessage(messageType, data)
+}
+
+func (s *sock) writeJSON(reply sockReply) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.conn.WriteJSON(reply)
+}
+
+// subscribe subscribes to the changes to an entry and the links on it with the given tag,
+// or all its links if the tag is empty, replying with the subscription's id
+func (s *sock) subscribe(dht *holo.DHT, base string, tag string) (err error) {
+	if _, err = hash.NewHash(base); err != nil {
+		err = s.writeJSON(sockReply{Error: "bad subscription hash: " + err.Error()})
+		return
+	}
+	id, events := dht.Subscribe(holo.DHTSubscription{Hash: base, Tag: tag})
+	s.subs[id] = true
+	if err = s.writeJSON(sockReply{Subscription: id}); err != nil {
+		return
+	}
+	go func() {
+		for e := range events {
+			e := e
+			if s.writeJSON(sockReply{Subscription: id, Event: &e}) != nil {
+				return
+			}
+		}
+	}()
+	return
+}
+
+// unsubscribe stops one of the subscriptions made over the websocket
+func (s *sock) unsubscribe(dht *holo.DHT, id string) (err error) {
+	n, e := strconv.Atoi(id)
+	if e != nil || !s.subs[n] {
+		err = s.writeJSON(sockReply{Error: "unknown subscription: " + id})
+		return
+	}
+	dht.Unsubscribe(n)
+	delete(s.subs, n)
+	err = s.writeJSON(sockReply{Subscription: n})
+	return
+}
+
+// unsubscribeAll stops the subscriptions made over the websocket once it's closed
+func (s *sock) unsubscribeAll(dht *holo.DHT) {
+	for id := range s.subs {
+		dht.Unsubscribe(id)
+	}
+}
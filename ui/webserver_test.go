@@ -2,6 +2,8 @@ package ui
 
 import (
 	"bytes"
+	"fmt"
+	websocket "github.com/gorilla/websocket"
 	. "github.com/metacurrency/holochain"
 	. "github.com/metacurrency/holochain/hash"
 	. "github.com/smartystreets/goconvey/convey"
@@ -55,6 +57,18 @@ func TestWebServer(t *testing.T) {
 		So(string(b), ShouldEqual, "en")
 	})
 
+	Convey("it should pass binary bodies through to binary functions", t, func() {
+		image := []byte{0, 1, 2, 255}
+		resp, err := http.Post("http://0.0.0.0:31415/fn/jsSampleZome/addImage", "application/octet-stream", bytes.NewBuffer(image))
+		So(err, ShouldBeNil)
+		defer resp.Body.Close()
+		var b []byte
+		b, err = ioutil.ReadAll(resp.Body)
+		So(err, ShouldBeNil)
+		So(resp.Header.Get("Content-Type"), ShouldEqual, "application/octet-stream")
+		So(b, ShouldResemble, image)
+	})
+
 	fakeFromApp, _ := NewHash("QmVGtdTZdTFaLsaj2RwdVG8jcjNNcp1DE914DKZ2kHmXHx")
 	token, _ := h.AddBridgeAsCallee(fakeFromApp, "")
 
@@ -79,6 +93,54 @@ func TestWebServer(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(string(b), ShouldEqual, "en")
 	})
+
+	Convey("it should push the DHT changes subscribed to over the websocket", t, func() {
+		conn, _, err := websocket.DefaultDialer.Dial("ws://0.0.0.0:31415/_sock/", nil)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+
+		e := GobEntry{C: "7"}
+		hash, _ := e.Sum(h.HashSpec())
+		err = conn.WriteJSON(map[string]string{"subscribe": hash.String()})
+		So(err, ShouldBeNil)
+		var reply map[string]interface{}
+		err = conn.ReadJSON(&reply)
+		So(err, ShouldBeNil)
+		id := reply["Subscription"]
+		So(id, ShouldNotBeNil)
+
+		_, err = h.Call("jsSampleZome", "addOdd", "7", PUBLIC_EXPOSURE)
+		So(err, ShouldBeNil)
+		err = conn.ReadJSON(&reply)
+		So(err, ShouldBeNil)
+		So(reply["Subscription"], ShouldEqual, id)
+		event := reply["Event"].(map[string]interface{})
+		So(event["Type"], ShouldEqual, DHTEventPut)
+		So(event["Hash"], ShouldEqual, hash.String())
+		So(event["EntryType"], ShouldEqual, "oddNumbers")
+
+		err = conn.WriteJSON(map[string]string{"subscribe": "bogus"})
+		So(err, ShouldBeNil)
+		reply = nil
+		err = conn.ReadJSON(&reply)
+		So(err, ShouldBeNil)
+		So(reply["Error"], ShouldStartWith, "bad subscription hash")
+
+		// calls that fail send their error back rather than closing the websocket
+		err = conn.WriteJSON(map[string]string{"zome": "jsSampleZome", "fn": "noSuchFn"})
+		So(err, ShouldBeNil)
+		reply = nil
+		err = conn.ReadJSON(&reply)
+		So(err, ShouldBeNil)
+		So(reply["Error"], ShouldNotEqual, "")
+		err = conn.WriteJSON(map[string]string{"unsubscribe": fmt.Sprintf("%v", id)})
+		So(err, ShouldBeNil)
+		reply = nil
+		err = conn.ReadJSON(&reply)
+		So(err, ShouldBeNil)
+		So(reply["Subscription"], ShouldEqual, id)
+	})
+
 	ws.Stop()
 	ws.Wait()
 }
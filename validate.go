@@ -110,7 +110,11 @@ func MakeValidationPackage(h *Holochain, pkg *Package) (vpkg *ValidationPackage,
 		}
 		if flags&ChainMarshalFlagsNoEntries == 0 {
 			// restore the chain's DNA data
-			vp.Chain.Entries[0].(*GobEntry).C = h.chain.Entries[0].(*GobEntry).C
+			var dna Entry
+			if dna, err = h.chain.Entry(0); err != nil {
+				return
+			}
+			vp.Chain.Entries[0].(*GobEntry).C = dna.(*GobEntry).C
 		}
 		if flags&ChainMarshalFlagsNoHeaders == 0 {
 			err = vp.Chain.Validate(flags&ChainMarshalFlagsNoEntries != 0)
@@ -15,6 +15,7 @@ import (
 
 const (
 	SelfRevocationType = iota
+	ForkType
 )
 
 // Warrant abstracts the notion of a multi-party cryptographically verifiable signed claim
@@ -62,6 +63,9 @@ func DecodeWarrant(warrantType int, data []byte) (w Warrant, err error) {
 	case SelfRevocationType:
 		w = &SelfRevocationWarrant{}
 		err = w.Decode(data)
+	case ForkType:
+		w = &ForkWarrant{}
+		err = w.Decode(data)
 	default:
 		err = UnknownWarrantTypeErr
 	}
@@ -154,3 +158,87 @@ func (w *SelfRevocationWarrant) Decode(data []byte) (err error) {
 	err = w.Revocation.Unmarshal(data)
 	return
 }
+
+var ErrBadForkWarrant = errors.New("warrant doesn't prove a fork")
+
+// ForkWarrant warrants that its party forked its chain, by publishing two different headers
+// that follow the same header.  The proof is the two put requests that carried the headers,
+// which the party signed.
+type ForkWarrant struct {
+	Puts [2]Message
+}
+
+func NewForkWarrant(put1 *Message, put2 *Message) (wP *ForkWarrant, err error) {
+	w := ForkWarrant{Puts: [2]Message{*put1, *put2}}
+	wP = &w
+	return
+}
+
+func (w *ForkWarrant) Type() int {
+	return ForkType
+}
+
+func (w *ForkWarrant) Parties() (parties []Hash, err error) {
+	parties = append(parties, HashFromPeerID(w.Puts[0].From))
+	return
+}
+
+func (w *ForkWarrant) Verify(h *Holochain) (err error) {
+	var hashes [2]Hash
+	var headers [2]*Header
+	for i := range w.Puts {
+		m := &w.Puts[i]
+		if m.Type != PUT_REQUEST || m.From != w.Puts[0].From {
+			err = ErrBadForkWarrant
+			return
+		}
+		if err = m.Verify(); err != nil {
+			return
+		}
+		var ok bool
+		if headers[i], ok = putHeader(m); !ok {
+			err = ErrBadForkWarrant
+			return
+		}
+		hashes[i], _, err = headers[i].Sum(h.hashSpec)
+		if err != nil {
+			return
+		}
+	}
+	// the headers must differ but follow the same one
+	if hashes[0].Equal(&hashes[1]) || headers[0].HeaderLink.IsNullHash() || !headers[0].HeaderLink.Equal(&headers[1].HeaderLink) {
+		err = ErrBadForkWarrant
+	}
+	return
+}
+
+func (w *ForkWarrant) Property(key string) (value interface{}, err error) {
+	switch key {
+	case "prev":
+		if header, ok := putHeader(&w.Puts[0]); ok {
+			value = header.HeaderLink
+			return
+		}
+	case "headers":
+		var headers []Header
+		for i := range w.Puts {
+			if header, ok := putHeader(&w.Puts[i]); ok {
+				headers = append(headers, *header)
+			}
+		}
+		value = headers
+		return
+	}
+	err = WarrantPropertyNotFoundErr
+	return
+}
+
+func (w *ForkWarrant) Encode() (data []byte, err error) {
+	data, err = ByteEncoder(&w.Puts)
+	return
+}
+
+func (w *ForkWarrant) Decode(data []byte) (err error) {
+	err = ByteDecoder(data, &w.Puts)
+	return
+}
@@ -3,9 +3,11 @@ package holochain
 import (
 	"fmt"
 	peer "github.com/libp2p/go-libp2p-peer"
+	. "github.com/metacurrency/holochain/hash"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"testing"
+	"time"
 )
 
 func TestSelfRevocationWarrant(t *testing.T) {
@@ -87,3 +89,87 @@ func TestSelfRevocationWarrant(t *testing.T) {
 
 	})
 }
+
+func TestForkWarrant(t *testing.T) {
+	d, _, h := PrepareTestChain("test")
+	defer CleanupTestChain(h, d)
+
+	// an author with two headers following the same one
+	author, key := makePeer("forker")
+	prev := h.chain.Hashes[len(h.chain.Hashes)-1]
+	put := func(content string) *Message {
+		_, header, _ := newHeader(h.hashSpec, time.Now(), "evenNumbers", &GobEntry{C: content}, key, prev, NullHash(), nil)
+		m := Message{Type: PUT_REQUEST, Time: time.Now().Round(0), From: author, Body: PutReq{H: header.EntryLink, Header: header, Successor: true}}
+		m.Sign(key)
+		return &m
+	}
+	put1 := put("2")
+	put2 := put("4")
+
+	Convey("it should verify two headers following the same one", t, func() {
+		w, err := NewForkWarrant(put1, put2)
+		So(err, ShouldBeNil)
+		So(w.Type(), ShouldEqual, ForkType)
+		parties, err := w.Parties()
+		So(err, ShouldBeNil)
+		So(parties[0].String(), ShouldEqual, peer.IDB58Encode(author))
+		So(w.Verify(h), ShouldBeNil)
+
+		p, err := w.Property("prev")
+		So(err, ShouldBeNil)
+		So(p.(Hash).String(), ShouldEqual, prev.String())
+		headers, _ := w.Property("headers")
+		So(len(headers.([]Header)), ShouldEqual, 2)
+
+		encoded, err := w.Encode()
+		So(err, ShouldBeNil)
+		w2, err := DecodeWarrant(ForkType, encoded)
+		So(err, ShouldBeNil)
+		So(w2.Verify(h), ShouldBeNil)
+	})
+
+	Convey("it should not verify if the headers don't fork", t, func() {
+		w, _ := NewForkWarrant(put1, put1)
+		So(w.Verify(h), ShouldEqual, ErrBadForkWarrant)
+
+		tampered := *put2
+		req := tampered.Body.(PutReq)
+		header := *req.Header
+		header.HeaderLink = h.chain.Hashes[0]
+		req.Header = &header
+		tampered.Body = req
+		w, _ = NewForkWarrant(put1, &tampered)
+		So(w.Verify(h), ShouldEqual, ErrMessageSignature)
+	})
+
+	Convey("it should only let its party be added to a list", t, func() {
+		w, _ := NewForkWarrant(put1, put2)
+		data, _ := w.Encode()
+		other, _ := makePeer("other")
+		m := h.node.NewMessage(LISTADD_REQUEST, ListAddReq{
+			ListType:    BlockedList,
+			Peers:       []string{peer.IDB58Encode(author), peer.IDB58Encode(other)},
+			WarrantType: ForkType,
+			Warrant:     data,
+		})
+		_, err := ActionReceiver(h, m)
+		So(err.Error(), ShouldEqual, fmt.Sprintf("List add request rejected on warrant failure: %v is not a party to the warrant", peer.IDB58Encode(other)))
+		So(h.node.IsBlocked(author), ShouldBeFalse)
+	})
+
+	Convey("DHT nodes should block authors seen forking", t, func() {
+		r, err := ActionReceiver(h, put1)
+		So(err, ShouldBeNil)
+		So(r, ShouldEqual, DHTChangeOK)
+		_, err = ActionReceiver(h, put1)
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(author), ShouldBeFalse)
+
+		_, err = ActionReceiver(h, put2)
+		So(err, ShouldBeNil)
+		So(h.node.IsBlocked(author), ShouldBeTrue)
+		list, err := h.dht.getList(BlockedList)
+		So(err, ShouldBeNil)
+		So(list.Records[0].ID, ShouldEqual, author)
+	})
+}
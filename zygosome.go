@@ -138,7 +138,10 @@ func (z *ZygoRibosome) ValidatePackagingRequest(action ValidatingAction, def *En
 }
 
 func prepareZyEntryArgs(def *EntryDef, entry Entry, header *Header) (args string, err error) {
-	entryStr := entry.Content().(string)
+	var entryStr string
+	if def.DataFormat != DataFormatBinary {
+		entryStr = entry.Content().(string)
+	}
 	switch def.DataFormat {
 	case DataFormatRawZygo:
 		args = entryStr
@@ -148,6 +151,8 @@ func prepareZyEntryArgs(def *EntryDef, entry Entry, header *Header) (args string
 		fallthrough
 	case DataFormatJSON:
 		args = fmt.Sprintf(`(unjson (raw "%s"))`, sanitizeZyString(entryStr))
+	case DataFormatBinary:
+		args = zyByteList(entry.Content().([]byte))
 	default:
 		err = errors.New("data format not implemented: " + def.DataFormat)
 		return
@@ -245,7 +250,10 @@ func mkZySources(sources []string) (srcs string) {
 }
 
 func (z *ZygoRibosome) prepareValidateArgs(def *EntryDef, entry Entry, sources []string) (e string, srcs string, err error) {
-	c := entry.Content().(string)
+	var c string
+	if def.DataFormat != DataFormatBinary {
+		c = entry.Content().(string)
+	}
 	// @todo handle JSON if schema type is different
 	switch def.DataFormat {
 	case DataFormatRawZygo:
@@ -256,6 +264,8 @@ func (z *ZygoRibosome) prepareValidateArgs(def *EntryDef, entry Entry, sources [
 		fallthrough
 	case DataFormatJSON:
 		e = fmt.Sprintf(`(unjson (raw "%s"))`, sanitizeZyString(c))
+	case DataFormatBinary:
+		e = zyByteList(entry.Content().([]byte))
 	default:
 		err = errors.New("data format not implemented: " + def.DataFormat)
 		return
@@ -314,6 +324,45 @@ func (z *ZygoRibosome) validateEntry(fnName string, def *EntryDef, entry Entry,
 	return
 }
 
+// zyByteList returns the code for a zygo array of the given bytes
+func zyByteList(b []byte) string {
+	s := make([]string, len(b))
+	for i, c := range b {
+		s[i] = strconv.Itoa(int(c))
+	}
+	return "[" + strings.Join(s, " ") + "]"
+}
+
+// zyByteArray converts bytes into a zygo array of ints
+func zyByteArray(env *zygo.Zlisp, b []byte) *zygo.SexpArray {
+	bytes := make([]zygo.Sexp, len(b))
+	for i, c := range b {
+		bytes[i] = &zygo.SexpInt{Val: int64(c)}
+	}
+	return env.NewSexpArray(bytes)
+}
+
+// zyToBytes converts a zygo raw or array of ints into bytes
+func zyToBytes(s zygo.Sexp) (b []byte, err error) {
+	switch t := s.(type) {
+	case *zygo.SexpRaw:
+		b = t.Val
+	case *zygo.SexpArray:
+		b = make([]byte, len(t.Val))
+		for i, v := range t.Val {
+			x, ok := v.(*zygo.SexpInt)
+			if !ok || x.Val < 0 || x.Val > 255 {
+				err = errors.New("expected array of bytes")
+				return
+			}
+			b[i] = byte(x.Val)
+		}
+	default:
+		err = fmt.Errorf("expected raw or array of bytes, got %T", s)
+	}
+	return
+}
+
 // sanitizeZyString makes sure all quotes are quoted
 func sanitizeZyString(s string) string {
 	s = strings.Replace(s, "\"", "\\\"", -1)
@@ -332,6 +381,8 @@ func (z *ZygoRibosome) Call(fn *FunctionDef, params interface{}) (result interfa
 		} else {
 			code = fmt.Sprintf(`(json (%s (unjson (raw "%s"))))`, fn.Name, sanitizeZyString(params.(string)))
 		}
+	case BINARY_CALLING:
+		code = fmt.Sprintf(`(%s %s)`, fn.Name, zyByteList([]byte(params.(string))))
 	default:
 		err = errors.New("params type not implemented")
 		return
@@ -363,6 +414,13 @@ func (z *ZygoRibosome) Call(fn *FunctionDef, params interface{}) (result interfa
 			default:
 				err = errors.New("expected SexpRaw return type")
 			}
+		case BINARY_CALLING:
+			switch t := result.(type) {
+			case *zygo.SexpStr:
+				result = []byte(t.S)
+			default:
+				result, err = zyToBytes(result.(zygo.Sexp))
+			}
 		}
 
 	}
@@ -409,6 +467,86 @@ func makeResult(env *zygo.Zlisp, resultValue zygo.Sexp, resultError error) (zygo
 	return result, err
 }
 
+// zyGetValue returns the zygo value of a get response, which is just the entry, its type or
+// its sources if the mask asks for only one of them, or else a hash of them
+func zyGetValue(env *zygo.Zlisp, getResp GetResp, mask int) (resultValue zygo.Sexp, err error) {
+	resultValue = zygo.SexpNull
+	var entrySexp zygo.Sexp = &zygo.SexpStr{}
+	var singleValueReturn bool
+	if mask&GetMaskEntry != 0 {
+		if b, ok := getResp.Entry.Content().([]byte); ok {
+			// binary content comes back as a byte list
+			entrySexp = zyByteArray(env, b)
+			if GetMaskEntry == mask {
+				singleValueReturn = true
+				resultValue = entrySexp
+			}
+		} else {
+			j, err := json.Marshal(getResp.Entry.Content())
+			if err == nil {
+				entrySexp = &zygo.SexpStr{S: string(j)}
+				if GetMaskEntry == mask {
+					singleValueReturn = true
+					resultValue = entrySexp
+				}
+			}
+		}
+	}
+	if mask&GetMaskEntryType != 0 {
+		if GetMaskEntryType == mask {
+			singleValueReturn = true
+			resultValue = &zygo.SexpStr{S: getResp.EntryType}
+		}
+	}
+	var zSources *zygo.SexpArray
+	if mask&GetMaskSources != 0 {
+		sources := make([]zygo.Sexp, len(getResp.Sources))
+		for i := range getResp.Sources {
+			sources[i] = &zygo.SexpStr{S: getResp.Sources[i]}
+		}
+		zSources = env.NewSexpArray(sources)
+		if GetMaskSources == mask {
+			singleValueReturn = true
+			resultValue = zSources
+		}
+	}
+	if err == nil && !singleValueReturn {
+		// build the return object
+		var respObj *zygo.SexpHash
+		respObj, err = zygo.MakeHash(nil, "hash", env)
+		if err == nil {
+			resultValue = respObj
+			if mask&GetMaskEntry != 0 {
+				err = respObj.HashSet(env.MakeSymbol("Entry"), entrySexp)
+			}
+			if err == nil && mask&GetMaskEntryType != 0 {
+				err = respObj.HashSet(env.MakeSymbol("EntryType"), &zygo.SexpStr{S: getResp.EntryType})
+			}
+			if err == nil && mask&GetMaskSources != 0 {
+				err = respObj.HashSet(env.MakeSymbol("Sources"), zSources)
+			}
+		}
+	}
+	return
+}
+
+// zyBatchValue returns the zygo array of the results of a batch action, each of them a hash
+// with the result or error as makeResult would return
+func zyBatchValue(env *zygo.Zlisp, results []BatchResult, value func(r interface{}) (zygo.Sexp, error)) (zygo.Sexp, error) {
+	items := make([]zygo.Sexp, len(results))
+	for i, r := range results {
+		var v zygo.Sexp = zygo.SexpNull
+		if r.Err == nil {
+			v, r.Err = value(r.Response)
+		}
+		var err error
+		if items[i], err = makeResult(env, v, r.Err); err != nil {
+			return zygo.SexpNull, err
+		}
+	}
+	return env.NewSexpArray(items), nil
+}
+
 // cleanZygoJson removes zygos crazy crap
 func cleanZygoJson(s string) string {
 	s = strings.Replace(s, `"Atype":"hash", `, "", -1)
@@ -514,6 +652,13 @@ func zyProcessArgs(z *ZygoRibosome, args []Arg, zyArgs []zygo.Sexp) (err error)
 				default:
 					entry = cleanZygoJson(zygo.SexpToJson(a))
 				}
+			case DataFormatBinary:
+				b, err := zyToBytes(a)
+				if err != nil {
+					return argErr("raw or array of bytes", i+1, args[i])
+				}
+				args[i].value = b
+				continue
 			default:
 				err = errors.New("data format not implemented: " + def.DataFormat)
 				return err
@@ -533,6 +678,19 @@ func zyProcessArgs(z *ZygoRibosome, args []Arg, zyArgs []zygo.Sexp) (err error)
 			default:
 				return argErr("hash", i+1, args[i])
 			}
+		case ListArg:
+			switch t := a.(type) {
+			case *zygo.SexpArray:
+				j := cleanZygoJson(zygo.SexpToJson(t))
+				var l []interface{}
+				var err = json.Unmarshal([]byte(j), &l)
+				if err != nil {
+					return err
+				}
+				args[i].value = l
+			default:
+				return argErr("array", i+1, args[i])
+			}
 		case ToStrArg:
 			var str string
 
@@ -828,10 +986,17 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				return zygo.SexpNull, err
 			}
 			entryType := args[0].value.(string)
-			entry := args[1].value.(string)
+			entry := args[1].value
 			var r interface{}
 			e := GobEntry{C: entry}
-			r, err = NewCommitAction(entryType, &e).Do(h)
+			commit := NewCommitAction(entryType, &e)
+			if len(zyargs) == 3 {
+				commit.options, err = commitOptionsFrom(args[2].value)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
+			}
+			r, err = commit.Do(h)
 			if err != nil {
 				return zygo.SexpNull, err
 			}
@@ -871,13 +1036,21 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			if err != nil {
 				return zygo.SexpNull, err
 			}
+			if groups, ok := r.([]QueryGroup); ok {
+				var j []byte
+				j, err = json.Marshal(groups)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
+				return &zygo.SexpStr{S: string(j)}, nil
+			}
 			qr := r.([]QueryResult)
 
 			defs := make(map[string]*EntryDef)
 			results := make([]zygo.Sexp, len(qr))
 			for i, result := range qr {
-				var sexp zygo.Sexp
-				var hashSexp, entrySexp *zygo.SexpStr
+				var sexp, entrySexp zygo.Sexp
+				var hashSexp *zygo.SexpStr
 				var headerSexp *zygo.SexpHash
 				var returnCount int
 				if a.options.Return.Hashes {
@@ -947,10 +1120,14 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 							return zygo.SexpNull, err
 						}
 						content = string(j)
+					case DataFormatBinary:
+						entrySexp = zyByteArray(env, r.([]byte))
 					default:
 						return zygo.SexpNull, fmt.Errorf("data format not implemented: %s", def.DataFormat)
 					}
-					entrySexp = &zygo.SexpStr{S: content}
+					if entrySexp == nil {
+						entrySexp = &zygo.SexpStr{S: content}
+					}
 					sexp = entrySexp
 
 				}
@@ -1023,57 +1200,66 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 			var resultValue zygo.Sexp
 			resultValue = zygo.SexpNull
 			if err == nil {
-				getResp := r.(GetResp)
-				var entryStr string
-				var singleValueReturn bool
-				if mask&GetMaskEntry != 0 {
-					j, err := json.Marshal(getResp.Entry.Content())
-					if err == nil {
-						if GetMaskEntry == mask {
-							singleValueReturn = true
-							resultValue = &zygo.SexpStr{S: string(j)}
-						} else {
-							entryStr = string(j)
-						}
-					}
-				}
-				if mask&GetMaskEntryType != 0 {
-					if GetMaskEntryType == mask {
-						singleValueReturn = true
-						resultValue = &zygo.SexpStr{S: getResp.EntryType}
-					}
-				}
-				var zSources *zygo.SexpArray
-				if mask&GetMaskSources != 0 {
-					sources := make([]zygo.Sexp, len(getResp.Sources))
-					for i := range getResp.Sources {
-						sources[i] = &zygo.SexpStr{S: getResp.Sources[i]}
-					}
-					zSources = env.NewSexpArray(sources)
-					if GetMaskSources == mask {
-						singleValueReturn = true
-						resultValue = zSources
-					}
+				resultValue, err = zyGetValue(env, r.(GetResp), mask)
+			}
+			return makeResult(env, resultValue, err)
+		})
+
+	z.env.AddFunction("getMany",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			a := &ActionGetMany{}
+			args := a.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			hashes, err := getManyHashes(args[0].value.([]interface{}))
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			options := GetOptions{StatusMask: StatusDefault, GetMask: GetMaskDefault}
+			if len(zyargs) == 2 {
+				var j []byte
+				j, err = json.Marshal(args[1].value)
+				if err == nil {
+					err = json.Unmarshal(j, &options)
 				}
-				if err == nil && !singleValueReturn {
-					// build the return object
-					var respObj *zygo.SexpHash
-					respObj, err = zygo.MakeHash(nil, "hash", env)
-					if err == nil {
-						resultValue = respObj
-						if mask&GetMaskEntry != 0 {
-							err = respObj.HashSet(env.MakeSymbol("Entry"), &zygo.SexpStr{S: entryStr})
-						}
-						if err == nil && mask&GetMaskEntryType != 0 {
-							err = respObj.HashSet(env.MakeSymbol("EntryType"), &zygo.SexpStr{S: getResp.EntryType})
-						}
-						if err == nil && mask&GetMaskSources != 0 {
-							err = respObj.HashSet(env.MakeSymbol("Sources"), zSources)
-						}
-					}
+				if err != nil {
+					return zygo.SexpNull, err
 				}
 			}
-			return makeResult(env, resultValue, err)
+			mask := options.GetMask
+			if mask == GetMaskDefault {
+				mask = GetMaskEntry
+			}
+			r, err := NewGetManyAction(hashes, &options).Do(h)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return zyBatchValue(env, r.([]BatchResult), func(r interface{}) (zygo.Sexp, error) {
+				return zyGetValue(env, r.(GetResp), mask)
+			})
+		})
+
+	z.env.AddFunction("commitMany",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			a := &ActionCommitMany{}
+			args := a.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			items, err := commitManyItems(h, args[0].value.([]interface{}))
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			r, err := NewCommitManyAction(items).Do(h)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			return zyBatchValue(env, r.([]BatchResult), func(r interface{}) (zygo.Sexp, error) {
+				return &zygo.SexpStr{S: r.(Hash).String()}, nil
+			})
 		})
 
 	z.env.AddFunction("update",
@@ -1085,11 +1271,18 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 				return zygo.SexpNull, err
 			}
 			entryType := args[0].value.(string)
-			entryStr := args[1].value.(string)
+			content := args[1].value
 			replaces := args[2].value.(Hash)
 
-			entry := GobEntry{C: entryStr}
-			resp, err := NewModAction(entryType, &entry, replaces).Do(h)
+			entry := GobEntry{C: content}
+			mod := NewModAction(entryType, &entry, replaces)
+			if len(zyargs) == 4 {
+				mod.options, err = commitOptionsFrom(args[3].value)
+				if err != nil {
+					return zygo.SexpNull, err
+				}
+			}
+			resp, err := mod.Do(h)
 			if err != nil {
 				return zygo.SexpNull, err
 			}
@@ -1204,16 +1397,83 @@ func NewZygoRibosome(h *Holochain, zome *Zome) (n Ribosome, err error) {
 					}
 					options.StatusMask = int(maskval)
 				}
+				pageSize, ok := opts["PageSize"]
+				if ok {
+					pageSizeVal, ok := pageSize.(float64)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting int PageSize attribute in object, got %T", pageSize)
+					}
+					options.PageSize = int(pageSizeVal)
+				}
+				cursor, ok := opts["Cursor"]
+				if ok {
+					cursorVal, ok := cursor.(string)
+					if !ok {
+						return zygo.SexpNull,
+							fmt.Errorf("expecting string Cursor attribute in object, got %T", cursor)
+					}
+					options.Cursor = cursorVal
+				}
 			}
 
 			var r interface{}
-			r, err = NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask}, &options).Do(h)
+			r, err = NewGetLinksAction(&LinkQuery{Base: base, T: tag, StatusMask: options.StatusMask, Limit: options.PageSize, Cursor: options.Cursor}, &options).Do(h)
 			var resultValue zygo.Sexp
 			if err == nil {
 				response := r.(*LinkQueryResp)
 				resultValue = zygo.SexpNull
 				var j []byte
-				j, err = json.Marshal(response.Links)
+				// if paging was asked for the links come back with the cursor for the next page
+				paging := options.PageSize > 0 || options.Cursor != ""
+				warnLinksCut(response, paging)
+				if paging {
+					j, err = json.Marshal(response)
+				} else {
+					j, err = json.Marshal(response.Links)
+				}
+				if err == nil {
+					resultValue = &zygo.SexpStr{S: string(j)}
+				}
+			}
+			return makeResult(env, resultValue, err)
+		})
+
+	z.env.AddFunction("queryLinks",
+		func(env *zygo.Zlisp, name string, zyargs []zygo.Sexp) (zygo.Sexp, error) {
+			a := &ActionQueryLinks{}
+			args := a.Args()
+			err := zyProcessArgs(&z, args, zyargs)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			base := args[0].value.(Hash)
+
+			options := QueryLinksOptions{}
+			var j []byte
+			j, err = json.Marshal(args[1].value)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+			err = json.Unmarshal(j, &options)
+			if err != nil {
+				return zygo.SexpNull, err
+			}
+
+			var r interface{}
+			r, err = NewQueryLinksAction(base, &options).Do(h)
+			var resultValue zygo.Sexp
+			if err == nil {
+				response := r.(*LinkQueryResp)
+				resultValue = zygo.SexpNull
+				// if paging was asked for the links come back with the cursor for the next page
+				paging := options.PageSize > 0 || options.Cursor != ""
+				warnLinksCut(response, paging)
+				if paging {
+					j, err = json.Marshal(response)
+				} else {
+					j, err = json.Marshal(response.Links)
+				}
 				if err == nil {
 					resultValue = &zygo.SexpStr{S: string(j)}
 				}
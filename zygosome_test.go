@@ -592,6 +592,17 @@ func TestZygoDHT(t *testing.T) {
 		e, _ = resp.HashGet(z.env, z.env.MakeSymbol("Sources"))
 		So(e.(*zygo.SexpArray).Val[0].(*zygo.SexpStr).S, ShouldEqual, h.nodeIDStr)
 	})
+
+	Convey("commit and get should handle binary entries as byte lists", t, func() {
+		v, err := NewZygoRibosome(h, &Zome{RibosomeType: ZygoRibosomeType, Code: `(get (commit "image" [0 1 255]))`})
+		So(err, ShouldBeNil)
+		z := v.(*ZygoRibosome)
+		r, err := z.lastResult.(*zygo.SexpHash).HashGet(z.env, z.env.MakeSymbol("result"))
+		So(err, ShouldBeNil)
+		b, err := zyToBytes(r)
+		So(err, ShouldBeNil)
+		So(b, ShouldResemble, []byte{0, 1, 255})
+	})
 	profileHash := commit(h, "profile", `{"firstName":"Zippy","lastName":"Pinhead"}`)
 
 	commit(h, "rating", fmt.Sprintf(`{"Links":[{"Base":"%s","Link":"%s","Tag":"4stars"}]}`, hash.String(), profileHash.String()))